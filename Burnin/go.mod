@@ -1,20 +1,24 @@
 module Burnin
 
-go 1.18
+go 1.21
 
 require (
 	github.com/SKAARHOJ/ibeam-lib-utils v1.0.0
-	github.com/SKAARHOJ/rawpanel-lib v1.0.1
-	github.com/s00500/env_logger v0.1.23
-	google.golang.org/protobuf v1.28.0
+	github.com/SKAARHOJ/rawpanel-lib v1.4.0
+	github.com/s00500/env_logger v0.1.29
+	rwptransport v0.0.0
 )
 
 require (
-	github.com/disintegration/gift v1.2.1 // indirect
-	github.com/mattn/go-colorable v0.1.8 // indirect
-	github.com/mattn/go-isatty v0.0.12 // indirect
-	github.com/sirupsen/logrus v1.8.1 // indirect
-	go.uber.org/atomic v1.10.0 // indirect
-	golang.org/x/sys v0.0.0-20220412211240-33da011f77ad // indirect
-	golang.org/x/xerrors v0.0.0-20200804184101-5ec99f83aff1 // indirect
+	github.com/antchfx/xpath v1.2.4 // indirect
+	github.com/mattn/go-colorable v0.1.13 // indirect
+	github.com/mattn/go-isatty v0.0.20 // indirect
+	github.com/sirupsen/logrus v1.9.3 // indirect
+	github.com/subchen/go-xmldom v1.1.2 // indirect
+	go.uber.org/atomic v1.11.0 // indirect
+	golang.org/x/exp v0.0.0-20230728194245-b0cb94b80691 // indirect
+	golang.org/x/sys v0.20.0 // indirect
+	google.golang.org/protobuf v1.36.3 // indirect
 )
+
+replace rwptransport => ../rwptransport
@@ -1,18 +1,16 @@
 /*
-	Raw Panel / Blue Pill Burn-In tester
-	Uses protobuf format internally
+Raw Panel / Blue Pill Burn-In tester
+Uses protobuf format internally
 */
 package main
 
 import (
-	"bufio"
-	"encoding/binary"
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"net"
 	"os"
 	"reflect"
 	"sort"
@@ -24,7 +22,7 @@ import (
 	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
 	log "github.com/s00500/env_logger"
 
-	"google.golang.org/protobuf/proto"
+	"rwptransport"
 )
 
 type BurninData struct {
@@ -62,116 +60,51 @@ func (burninData *BurninData) save(file string) {
 // Panel centric view:
 // Inbound TCP commands - from external system to SKAARHOJ panel
 // Outbound TCP commands - from panel to external system
-func connectToPanel(panelIPAndPort string, incoming chan []*rwp.InboundMessage, outgoing chan []*rwp.OutboundMessage, binaryPanel bool, brightness int) {
+func connectToPanel(panelIPAndPort string, incoming chan []*rwp.InboundMessage, outgoing chan []*rwp.OutboundMessage, binaryPanel bool, autoPanel bool, recorder *rwptransport.Recorder, brightness int) {
+	config := rwptransport.Config{
+		Encoding:       rwptransport.EncodingFromFlag(binaryPanel),
+		DetectEncoding: autoPanel,
+		Recorder:       recorder,
+		OnEvent: func(e rwptransport.Event) {
+			switch e.Type {
+			case rwptransport.Connected:
+				fmt.Println("Success - Connected to panel (" + e.Encoding.String() + ")")
 
-	for {
-		fmt.Println("Trying to connect to panel on " + panelIPAndPort + "...")
-		c, err := net.Dial("tcp", panelIPAndPort)
-
-		if err != nil {
-			fmt.Println(err)
-			time.Sleep(time.Second * 3)
-		} else {
-			fmt.Println("Success - Connected to panel")
-
-			incoming <- []*rwp.InboundMessage{
-				&rwp.InboundMessage{
-					Command: &rwp.Command{
-						ActivatePanel:         true,
-						SendPanelInfo:         true,
-						SendBurninProfile:     true,
-						ReportHWCavailability: true,
-						PanelBrightness: &rwp.Brightness{
-							LEDs:  uint32(brightness),
-							OLEDs: uint32(brightness),
+				incoming <- []*rwp.InboundMessage{
+					&rwp.InboundMessage{
+						Command: &rwp.Command{
+							ActivatePanel:         true,
+							SendPanelInfo:         true,
+							SendBurninProfile:     true,
+							ReportHWCavailability: true,
+							PanelBrightness: &rwp.Brightness{
+								LEDs:  uint32(brightness),
+								OLEDs: uint32(brightness),
+							},
 						},
 					},
-				},
-			}
-
-			quit := make(chan bool)
-			go func() {
-				for {
-					select {
-					case <-quit:
-						close(quit)
-						return
-					case incomingMessages := <-incoming:
-
-						//su.Debug(outboundMessages)
-						if binaryPanel {
-							for _, msg := range incomingMessages {
-								pbdata, err := proto.Marshal(msg)
-								log.Should(err)
-								header := make([]byte, 4)                                  // Create a 4-bytes header
-								binary.LittleEndian.PutUint32(header, uint32(len(pbdata))) // Fill it in
-								pbdata = append(header, pbdata...)                         // and concatenate it with the binary message
-								//log.Println("System -> Panel: ", pbdata)
-								_, err = c.Write(pbdata)
-								log.Should(err)
-							}
-						} else {
-							lines := helpers.InboundMessagesToRawPanelASCIIstrings(incomingMessages)
-
-							for _, line := range lines {
-								fmt.Println(string("System -> Panel: " + strings.TrimSpace(string(line))))
-								c.Write([]byte(line + "\n"))
-							}
-						}
-					}
 				}
-			}()
-
-			if binaryPanel {
-				for {
-					c.SetReadDeadline(time.Time{}) // Reset deadline, waiting for header
-					headerArray := make([]byte, 4)
-					_, err := io.ReadFull(c, headerArray) // Read 4 header bytes
-					if err != nil {
-						log.Println("Binary: ", err)
-						break
-					} else {
-						currentPayloadLength := binary.LittleEndian.Uint32(headerArray[0:4])
-						if currentPayloadLength < 500000 {
-							payload := make([]byte, currentPayloadLength)
-							c.SetReadDeadline(time.Now().Add(2 * time.Second)) // Set a deadline that we want all data within at most 2 seconds. This helps a run-away scenario where not all data arrives or we read the wront (and too big) header
-							_, err := io.ReadFull(c, payload)
-							if err != nil {
-								log.Println(err)
-								break
-							} else {
-								outcomingMessage := &rwp.OutboundMessage{}
-								proto.Unmarshal(payload, outcomingMessage)
-								outgoing <- []*rwp.OutboundMessage{outcomingMessage}
-							}
-						} else {
-							log.Error(" Payload", currentPayloadLength, "exceed limit")
-							break
-						}
-					}
-				}
-			} else {
-				connectionReader := bufio.NewReader(c) // Define OUTSIDE the for loop
-				for {
-					netData, err := connectionReader.ReadString('\n')
-					if err != nil {
-						if err == io.EOF {
-							fmt.Println("Panel: " + c.RemoteAddr().String() + " disconnected")
-							time.Sleep(time.Second)
-						} else {
-							fmt.Println(err)
-						}
-						break
-					} else {
-						outgoing <- helpers.RawPanelASCIIstringsToOutboundMessages([]string{strings.TrimSpace(netData)})
-					}
+			case rwptransport.Disconnected:
+				fmt.Println("Panel: " + e.Addr + " disconnected")
+				if e.Err != nil && e.Err != io.EOF {
+					log.Println(e.Err)
 				}
+			case rwptransport.DialFailed:
+				fmt.Println(e.Err)
+				fmt.Println("Trying to connect to panel on " + panelIPAndPort + "...")
+			case rwptransport.ReadError:
+				log.Error(e.Err)
 			}
-
-			quit <- true
-			c.Close()
-		}
+		},
+		OnWrite: func(line []byte, encoding rwptransport.Encoding) {
+			if encoding == rwptransport.ASCII {
+				fmt.Println(string("System -> Panel: " + strings.TrimSpace(string(line))))
+			}
+		},
 	}
+
+	fmt.Println("Trying to connect to panel on " + panelIPAndPort + "...")
+	rwptransport.DialPanel(context.Background(), panelIPAndPort, incoming, outgoing, config)
 }
 
 func feedbackBinary(incoming chan []*rwp.InboundMessage, Event *rwp.HWCEvent, displayHWC int, outputHWC int, failed bool) {
@@ -802,7 +735,8 @@ func main() {
 	brightness := flag.Int("brightness", 8, "Sets the brightness, value from 0-8 (8 is default)")
 	file := flag.String("file", "", "File to read burnin data from. By default it will be fetched from the panel, if possible")
 	record := flag.Bool("record", false, "Will record to the file instead of reading from it")
-	binPanel := flag.Bool("binPanel", false, "Connects to the panels in binary mode")
+	binPanel := flag.Bool("binPanel", false, "Connects to the panels in binary mode (-binPanel=false for ASCII). If not given, the encoding is detected")
+	sessionLog := flag.String("sessionLog", "", "Logs all messages to and from the panel to this file, for replay with RawPanelReplay")
 	flag.Parse()
 
 	arguments := flag.Args()
@@ -816,20 +750,31 @@ func main() {
 	// Welcome message!
 	fmt.Println("Welcome to Raw Panel - Server Panel BurnIn test! Made by Kasper Skaarhoj (c) 2021-2022")
 
+	var recorder *rwptransport.Recorder
+	if *sessionLog != "" {
+		var err error
+		recorder, err = rwptransport.CreateRecorder(*sessionLog)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		defer recorder.Close()
+	}
+
 	for _, argument := range arguments {
-		startTest(argument, initialFlashes, initialOutputCycle, brightness, file, record, binPanel)
+		startTest(argument, initialFlashes, initialOutputCycle, brightness, file, record, binPanel, !rwptransport.FlagPassed("binPanel"), recorder)
 	}
 	select {}
 }
 
-func startTest(panelIPAndPort string, initialFlashes *int, initialOutputCycle *int, brightness *int, file *string, record *bool, binPanel *bool) {
+func startTest(panelIPAndPort string, initialFlashes *int, initialOutputCycle *int, brightness *int, file *string, record *bool, binPanel *bool, autoPanel bool, recorder *rwptransport.Recorder) {
 	fmt.Println("Ready to test panel " + panelIPAndPort + "...\n")
 
 	// Set up server:
 	incoming := make(chan []*rwp.InboundMessage, 10)
 	outgoing := make(chan []*rwp.OutboundMessage, 10)
 
-	go connectToPanel(panelIPAndPort, incoming, outgoing, *binPanel, *brightness)
+	go connectToPanel(panelIPAndPort, incoming, outgoing, *binPanel, autoPanel, recorder, *brightness)
 	go testManager(incoming, outgoing, *initialFlashes, *file, *record, *initialOutputCycle)
 
 }
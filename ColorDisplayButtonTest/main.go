@@ -11,13 +11,12 @@ PARTICULAR PURPOSE. MIT License
 package main
 
 import (
-	"bufio"
+	"context"
 	"flag"
 	"fmt"
 	"io"
 	"math"
 	"math/rand"
-	"net"
 	"sort"
 	"strings"
 	"sync"
@@ -28,10 +27,9 @@ import (
 	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
 	log "github.com/s00500/env_logger"
 
-	"encoding/binary"
 	"encoding/json"
 
-	"google.golang.org/protobuf/proto"
+	"rwptransport"
 )
 
 // TODO: Import these definitions from somewhere else... (so it is shared)
@@ -124,213 +122,152 @@ type TopologyHWcTypeDef_Display struct {
 // Panel centric view:
 // Inbound TCP commands - from external system to SKAARHOJ panel
 // Outbound TCP commands - from panel to external system
-func connectToPanel(panelIPAndPort string, incoming chan []*rwp.InboundMessage, outgoing chan []*rwp.OutboundMessage, binaryPanel bool, panelNum int, verboseIncoming *int, analogProfiling *bool, cpuProfiling *int, brightness *int, fullPowerStartUp *bool) {
+func connectToPanel(panelIPAndPort string, incoming chan []*rwp.InboundMessage, outgoing chan []*rwp.OutboundMessage, binaryPanel bool, autoPanel bool, recorder *rwptransport.Recorder, panelNum int, verboseIncoming *int, analogProfiling *bool, cpuProfiling *int, brightness *int, fullPowerStartUp *bool) {
 
-	connected := false
-	var c net.Conn
-	var err error
-
-	// This go routine will listen indefinitely on the incoming channel for Raw Panel messages to send to the connected panel.
+	// This go routine will listen indefinitely on the incoming channel and pass Raw Panel messages on to the connection, printing them on the way if requested.
+	toPanel := make(chan []*rwp.InboundMessage, 100)
 	go func() {
-		for {
-			select {
-			case incomingMessages := <-incoming:
-				if connected {
-					if *verboseIncoming > 1 {
-						log.Println(log.Indent(incomingMessages))
-					}
-					if binaryPanel { // Writing Raw Panel Protobuf messages out to panel in Binary encoding:
-						for _, msg := range incomingMessages {
-							pbdata, err := proto.Marshal(msg)
-							log.Should(err)
-							header := make([]byte, 4)                                  // Create a 4-bytes header
-							binary.LittleEndian.PutUint32(header, uint32(len(pbdata))) // Fill it in
-							pbdata = append(header, pbdata...)                         // and concatenate it with the binary message
-
-							if *verboseIncoming > 0 {
-								log.Println("System -> Panel: ", pbdata)
-							}
-							_, err = c.Write(pbdata)
-							log.Should(err)
-						}
-					} else { // Writing Raw Panel Protobuf messages out to panel in ASCII encoding:
-						lines := helpers.InboundMessagesToRawPanelASCIIstrings(incomingMessages)
-						for _, line := range lines {
-							if *verboseIncoming > 0 {
-								log.Println(string("System -> Panel: " + strings.TrimSpace(string(line))))
-							}
-							c.Write([]byte(line + "\n"))
-						}
-					}
-				}
+		for incomingMessages := range incoming {
+			if *verboseIncoming > 1 {
+				log.Println(log.Indent(incomingMessages))
 			}
+			toPanel <- incomingMessages
 		}
 	}()
 
-	// Here, we will connect to the panel and manage message from the panel to the connecting system:
-	for {
-		log.Printf("Trying to connect to panel %d on %s ...\n", panelNum, panelIPAndPort)
-		c, err = net.Dial("tcp", panelIPAndPort)
-
-		if err != nil {
-			log.Should(err)
-			time.Sleep(time.Second * 3) // Wait three seconds before a connection retry
-		} else {
-			log.Printf("Success - Connected to panel %d on %s ...\n", panelNum, panelIPAndPort)
-			connected = true
-
-			// Send a connection message to the panel:
-			incoming <- []*rwp.InboundMessage{
-				{
-					Command: &rwp.Command{
-						ActivatePanel:         true,
-						SendPanelInfo:         true, // Asks panel to return info about itself
-						ReportHWCavailability: true, // Asks panel to return mapping of hardware components
-						SendPanelTopology:     true, // Asks panel to send its topology
-						SetSleepTimeout: &rwp.SleepTimeout{ // Disable sleep
-							Value: 0,
-						},
-						PanelBrightness: &rwp.Brightness{
-							OLEDs: uint32(*brightness),
-							LEDs:  uint32(*brightness),
-						},
-					},
-				},
-			}
-
-			if *cpuProfiling >= 0 {
-				log.Println("CPU Profiling enabled - check folder ColorDisplayButtonTest/ for log files")
+	config := rwptransport.Config{
+		Encoding:       rwptransport.EncodingFromFlag(binaryPanel),
+		DetectEncoding: autoPanel,
+		Recorder:       recorder,
+		OnEvent: func(e rwptransport.Event) {
+			switch e.Type {
+			case rwptransport.Connected:
+				log.Printf("Success - Connected to panel %d on %s (%s) ...\n", panelNum, panelIPAndPort, e.Encoding)
 
+				// Send a connection message to the panel:
 				incoming <- []*rwp.InboundMessage{
 					{
 						Command: &rwp.Command{
-							LoadCPU: &rwp.LoadCPU{
-								Level: rwp.LoadCPU_LevelE(*cpuProfiling), // 0-4, 2 cores
+							ActivatePanel:         true,
+							SendPanelInfo:         true, // Asks panel to return info about itself
+							ReportHWCavailability: true, // Asks panel to return mapping of hardware components
+							SendPanelTopology:     true, // Asks panel to send its topology
+							SetSleepTimeout: &rwp.SleepTimeout{ // Disable sleep
+								Value: 0,
 							},
-							PublishSystemStat: &rwp.PublishSystemStat{
-								PeriodSec: 5,
+							PanelBrightness: &rwp.Brightness{
+								OLEDs: uint32(*brightness),
+								LEDs:  uint32(*brightness),
 							},
 						},
 					},
 				}
-			} else {
-				incoming <- []*rwp.InboundMessage{
-					{
-						Command: &rwp.Command{
-							LoadCPU: &rwp.LoadCPU{
-								Level: rwp.LoadCPU_LevelE(0), // Disable
-							},
-							PublishSystemStat: &rwp.PublishSystemStat{
-								PeriodSec: 0, // Disable
+
+				if *cpuProfiling >= 0 {
+					log.Println("CPU Profiling enabled - check folder ColorDisplayButtonTest/ for log files")
+
+					incoming <- []*rwp.InboundMessage{
+						{
+							Command: &rwp.Command{
+								LoadCPU: &rwp.LoadCPU{
+									Level: rwp.LoadCPU_LevelE(*cpuProfiling), // 0-4, 2 cores
+								},
+								PublishSystemStat: &rwp.PublishSystemStat{
+									PeriodSec: 5,
+								},
 							},
 						},
-					},
-				}
-			}
-
-			if *analogProfiling {
-				log.Println("Analog Profiling enabled - check folder ColorDisplayButtonTest/ for log files")
-			}
-			allHWCs := []uint32{}
-			for a := 0; a < 200; a++ {
-				allHWCs = append(allHWCs, uint32(a+1))
-			}
-			incoming <- []*rwp.InboundMessage{
-				{
-					States: []*rwp.HWCState{
-						&rwp.HWCState{
-							HWCIDs: allHWCs,
-							PublishRawADCValues: &rwp.PublishRawADCValues{
-								Enabled: *analogProfiling,
+					}
+				} else {
+					incoming <- []*rwp.InboundMessage{
+						{
+							Command: &rwp.Command{
+								LoadCPU: &rwp.LoadCPU{
+									Level: rwp.LoadCPU_LevelE(0), // Disable
+								},
+								PublishSystemStat: &rwp.PublishSystemStat{
+									PeriodSec: 0, // Disable
+								},
 							},
 						},
-					},
-				},
-			}
+					}
+				}
 
-			if *fullPowerStartUp {
-				log.Println("Setting Full Power Startup")
+				if *analogProfiling {
+					log.Println("Analog Profiling enabled - check folder ColorDisplayButtonTest/ for log files")
+				}
 				allHWCs := []uint32{}
 				for a := 0; a < 200; a++ {
 					allHWCs = append(allHWCs, uint32(a+1))
 				}
 				incoming <- []*rwp.InboundMessage{
-					&rwp.InboundMessage{
+					{
 						States: []*rwp.HWCState{
 							&rwp.HWCState{
 								HWCIDs: allHWCs,
-								HWCMode: &rwp.HWCMode{
-									State: rwp.HWCMode_ON,
-								},
-								HWCColor: &rwp.HWCColor{
-									ColorIndex: &rwp.ColorIndex{
-										Index: rwp.ColorIndex_Colors(2),
-									},
-								},
-								HWCText: &rwp.HWCText{
-									Inverted:   true,
-									Formatting: 7,
-									//Textline1:  "Inverted",
+								PublishRawADCValues: &rwp.PublishRawADCValues{
+									Enabled: *analogProfiling,
 								},
 							},
 						},
 					},
 				}
-			}
 
-			if binaryPanel { // Reading from the panel, expecting binary protobud encoder content:
-				for {
-					c.SetReadDeadline(time.Time{}) // Reset deadline, waiting for header
-					headerArray := make([]byte, 4)
-					_, err := io.ReadFull(c, headerArray) // Read 4 header bytes
-					if err != nil {
-						log.Error("on binary panel read: ", err)
-						break
+				if *fullPowerStartUp {
+					log.Println("Setting Full Power Startup")
+					allHWCs := []uint32{}
+					for a := 0; a < 200; a++ {
+						allHWCs = append(allHWCs, uint32(a+1))
 					}
-					currentPayloadLength := binary.LittleEndian.Uint32(headerArray[0:4])
-					if currentPayloadLength < 500000 {
-						payload := make([]byte, currentPayloadLength)
-						c.SetReadDeadline(time.Now().Add(2 * time.Second)) // Set a deadline that we want all data within at most 2 seconds. This helps a run-away scenario where not all data arrives or we read the wront (and too big) header
-						_, err := io.ReadFull(c, payload)
-						if err != nil {
-							log.Errorln(err)
-							break
-						}
-						outcomingMessage := &rwp.OutboundMessage{}
-						//fmt.Println(payload)
-						proto.Unmarshal(payload, outcomingMessage)
-						outgoing <- []*rwp.OutboundMessage{outcomingMessage}
-					} else {
-						log.Errorln("Error: Payload", currentPayloadLength, "exceed limit")
-						break
+					incoming <- []*rwp.InboundMessage{
+						&rwp.InboundMessage{
+							States: []*rwp.HWCState{
+								&rwp.HWCState{
+									HWCIDs: allHWCs,
+									HWCMode: &rwp.HWCMode{
+										State: rwp.HWCMode_ON,
+									},
+									HWCColor: &rwp.HWCColor{
+										ColorIndex: &rwp.ColorIndex{
+											Index: rwp.ColorIndex_Colors(2),
+										},
+									},
+									HWCText: &rwp.HWCText{
+										Inverted:   true,
+										Formatting: 7,
+										//Textline1:  "Inverted",
+									},
+								},
+							},
+						},
 					}
 				}
-			} else { // Reading from the panel, expecting ASCII encoded content:
-				err := c.SetReadDeadline(time.Time{})
-				log.Should(err)
-
-				connectionReader := bufio.NewReader(c)
-				for {
-					netData, err := connectionReader.ReadString('\n')
-					if err != nil {
-						if err == io.EOF {
-							log.Printf("Panel %d: %s disconnected\n", panelNum, c.RemoteAddr().String())
-							time.Sleep(time.Second)
-						} else {
-							log.Should(err)
-						}
-						break
-					} else {
-						outgoing <- helpers.RawPanelASCIIstringsToOutboundMessages([]string{strings.TrimSpace(netData)})
-					}
+			case rwptransport.Disconnected:
+				log.Printf("Panel %d: %s disconnected\n", panelNum, e.Addr)
+				if e.Err != nil && e.Err != io.EOF {
+					log.Errorln(e.Err)
 				}
+			case rwptransport.DialFailed:
+				log.Should(e.Err)
+				log.Printf("Trying to connect to panel %d on %s ...\n", panelNum, panelIPAndPort)
+			case rwptransport.ReadError:
+				log.Errorln(e.Err)
 			}
-
-			c.Close()
-			connected = false
-			time.Sleep(time.Second * 1)
-		}
+		},
+		OnWrite: func(data []byte, encoding rwptransport.Encoding) {
+			if *verboseIncoming > 0 {
+				if encoding == rwptransport.Binary {
+					log.Println("System -> Panel: ", data)
+				} else {
+					log.Println(string("System -> Panel: " + strings.TrimSpace(string(data))))
+				}
+			}
+		},
 	}
+
+	// Here, we will connect to the panel and manage message from the panel to the connecting system:
+	log.Printf("Trying to connect to panel %d on %s ...\n", panelNum, panelIPAndPort)
+	rwptransport.DialPanel(context.Background(), panelIPAndPort, toPanel, outgoing, config)
 }
 
 var PanelName = make(map[int]string)
@@ -612,8 +549,8 @@ func testManager(incoming chan []*rwp.InboundMessage, outgoing chan []*rwp.Outbo
 									dispMsg = helpers.RawPanelASCIIstringsToInboundMessages(HWCgfxStrings[HWCdispIndex[int(Event.HWCID)]-numberOfTextStrings])
 								}
 
-								txt := rwp.HWCText{}
-								img := rwp.HWCGfx{}
+								txt := &rwp.HWCText{}
+								img := &rwp.HWCGfx{}
 								if EMC {
 									txt.Formatting = 7
 									txt.Title = fmt.Sprintf("HWc #%d", Event.HWCID)
@@ -622,11 +559,11 @@ func testManager(incoming chan []*rwp.InboundMessage, outgoing chan []*rwp.Outbo
 									txt.Inverted = true
 								} else {
 									if len(dispMsg) > 0 && dispMsg[0].States[0].HWCText != nil {
-										txt = *dispMsg[0].States[0].HWCText
+										txt = dispMsg[0].States[0].HWCText
 										txt.Inverted = true
 									}
 									if len(dispMsg) > 0 && dispMsg[0].States[0].HWCGfx != nil {
-										img = *dispMsg[0].States[0].HWCGfx
+										img = dispMsg[0].States[0].HWCGfx
 									}
 								}
 
@@ -643,8 +580,8 @@ func testManager(incoming chan []*rwp.InboundMessage, outgoing chan []*rwp.Outbo
 														Index: rwp.ColorIndex_Colors(HWCcolor[int(Event.HWCID)] + 2),
 													},
 												},
-												HWCText: &txt,
-												HWCGfx:  &img,
+												HWCText: txt,
+												HWCGfx:  img,
 											},
 										},
 									},
@@ -684,8 +621,8 @@ func testManager(incoming chan []*rwp.InboundMessage, outgoing chan []*rwp.Outbo
 									dispMsg = helpers.RawPanelASCIIstringsToInboundMessages(HWCgfxStrings[HWCdispIndex[int(Event.HWCID)]-numberOfTextStrings])
 								}
 
-								txt := rwp.HWCText{}
-								img := rwp.HWCGfx{}
+								txt := &rwp.HWCText{}
+								img := &rwp.HWCGfx{}
 
 								if EMC {
 									txt.Formatting = 7
@@ -695,11 +632,11 @@ func testManager(incoming chan []*rwp.InboundMessage, outgoing chan []*rwp.Outbo
 									txt.Inverted = true
 								} else {
 									if len(dispMsg) > 0 && dispMsg[0].States[0].HWCText != nil {
-										txt = *dispMsg[0].States[0].HWCText
+										txt = dispMsg[0].States[0].HWCText
 										txt.Inverted = !txt.Inverted
 									}
 									if len(dispMsg) > 0 && dispMsg[0].States[0].HWCGfx != nil {
-										img = *dispMsg[0].States[0].HWCGfx
+										img = dispMsg[0].States[0].HWCGfx
 									}
 								}
 
@@ -716,8 +653,8 @@ func testManager(incoming chan []*rwp.InboundMessage, outgoing chan []*rwp.Outbo
 														Index: rwp.ColorIndex_Colors(HWCcolor[int(Event.HWCID)] + 2),
 													},
 												},
-												HWCText: &txt,
-												HWCGfx:  &img,
+												HWCText: txt,
+												HWCGfx:  img,
 											},
 										},
 									},
@@ -825,7 +762,7 @@ func main() {
 	rand.Seed(time.Now().UnixNano())
 
 	// Setting up and parsing command line parameters
-	binPanel := flag.Bool("binPanel", false, "Connects to the panels in binary mode")
+	binPanel := flag.Bool("binPanel", false, "Connects to the panels in binary mode (-binPanel=false for ASCII). If not given, the encoding is detected")
 	invertCallAll := flag.Int("invertCallAll", 0, "Inverts which button edges that triggers 'call all' change of button colors and display contents. 0=Left+Right edge, >1=Up+Down+Encoder+None. If 2, any trigger will activate all displays and components and show HWC number (useful for Immunity testing)")
 	verboseO := flag.Int("verboseOutgoing", 0, "Verbose output from panel, otherwise only events are shown. 1=Low intensity, 2=Higher intensity (protobuf messages as JSON)")
 	verboseI := flag.Int("verboseIncoming", 0, "Verbose input messages to panel (default is none shown). 1=Low intensity, 2=Higher intensity (protobuf messages as JSON)")
@@ -842,6 +779,7 @@ func main() {
 	EMC := flag.Bool("EMC", false, "If set, will run standard test for EMC")
 	WC := flag.Bool("WC", false, "")
 
+	sessionLog := flag.String("sessionLog", "", "Logs all messages to and from the panel to this file, for replay with RawPanelReplay")
 	flag.Parse()
 
 	fmt.Println("Welcome to Raw Panel - Server Panel Color/Display/Button test! Made by Kasper Skaarhoj, (c) 2020-22")
@@ -879,21 +817,32 @@ func main() {
 		}()
 	}
 
+	var recorder *rwptransport.Recorder
+	if *sessionLog != "" {
+		var err error
+		recorder, err = rwptransport.CreateRecorder(*sessionLog)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		defer recorder.Close()
+	}
+
 	startTicker()
 
 	for panelNum, argument := range arguments {
-		startTest(argument, binPanel, invertCallAll, panelNum+1, autoInterval, exclusiveHWClist, demoModeDelay, verboseO, verboseI, analogProfiling, cpuProfiling, brightness, fullPowerStartUp, demoModeFaders, demoModeImgsOnly, mixColors)
+		startTest(argument, binPanel, !rwptransport.FlagPassed("binPanel"), recorder, invertCallAll, panelNum+1, autoInterval, exclusiveHWClist, demoModeDelay, verboseO, verboseI, analogProfiling, cpuProfiling, brightness, fullPowerStartUp, demoModeFaders, demoModeImgsOnly, mixColors)
 	}
 	select {}
 }
 
-func startTest(panelIPAndPort string, binPanel *bool, invertCallAll *int, panelNum int, autoInterval *int, exclusiveHWClist *string, demoModeDelay *int, verboseO *int, verboseI *int, analogProfiling *bool, cpuProfiling *int, brightness *int, fullPowerStartUp *bool, demoModeFaders *bool, demoModeImgsOnly *bool, mixColors *bool) {
+func startTest(panelIPAndPort string, binPanel *bool, autoPanel bool, recorder *rwptransport.Recorder, invertCallAll *int, panelNum int, autoInterval *int, exclusiveHWClist *string, demoModeDelay *int, verboseO *int, verboseI *int, analogProfiling *bool, cpuProfiling *int, brightness *int, fullPowerStartUp *bool, demoModeFaders *bool, demoModeImgsOnly *bool, mixColors *bool) {
 
 	// Set up server:
 	incoming := make(chan []*rwp.InboundMessage, 100)
 	outgoing := make(chan []*rwp.OutboundMessage, 100)
 
-	go connectToPanel(panelIPAndPort, incoming, outgoing, *binPanel, panelNum, verboseI, analogProfiling, cpuProfiling, brightness, fullPowerStartUp)
+	go connectToPanel(panelIPAndPort, incoming, outgoing, *binPanel, autoPanel, recorder, panelNum, verboseI, analogProfiling, cpuProfiling, brightness, fullPowerStartUp)
 	go testManager(incoming, outgoing, *invertCallAll, panelNum, autoInterval, exclusiveHWClist, demoModeDelay, verboseO, demoModeFaders, demoModeImgsOnly, mixColors)
 }
 
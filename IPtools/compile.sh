@@ -14,6 +14,20 @@ GOOS=windows GOARCH=386 go build -o binaries/IPClient.Win-386.exe ipclient.go
 GOOS=linux GOARCH=amd64 go build -o binaries/IPClient.Linux-amd64 ipclient.go
 GOOS=linux GOARCH=386 go build -o binaries/IPClient.Linux-386 ipclient.go
 
+GOOS=darwin GOARCH=arm64 go build -o binaries/IPProxy.Mac-arm64-m1 ipproxy.go
+GOOS=darwin GOARCH=amd64 go build -o binaries/IPProxy.Mac-x86-intel ipproxy.go
+GOOS=windows GOARCH=amd64 go build -o binaries/IPProxy.Win-amd64.exe ipproxy.go
+GOOS=windows GOARCH=386 go build -o binaries/IPProxy.Win-386.exe ipproxy.go
+GOOS=linux GOARCH=amd64 go build -o binaries/IPProxy.Linux-amd64 ipproxy.go
+GOOS=linux GOARCH=386 go build -o binaries/IPProxy.Linux-386 ipproxy.go
+
+GOOS=darwin GOARCH=arm64 go build -o binaries/IPReplay.Mac-arm64-m1 ipreplay.go
+GOOS=darwin GOARCH=amd64 go build -o binaries/IPReplay.Mac-x86-intel ipreplay.go
+GOOS=windows GOARCH=amd64 go build -o binaries/IPReplay.Win-amd64.exe ipreplay.go
+GOOS=windows GOARCH=386 go build -o binaries/IPReplay.Win-386.exe ipreplay.go
+GOOS=linux GOARCH=amd64 go build -o binaries/IPReplay.Linux-amd64 ipreplay.go
+GOOS=linux GOARCH=386 go build -o binaries/IPReplay.Linux-386 ipreplay.go
+
 
 cd binaries
 
@@ -29,4 +43,16 @@ zip IPClient.Linux.zip IPClient.Linux-amd64 IPClient.Linux-386
 
 rm IPClient.Win-amd64.exe IPClient.Win-386.exe IPClient.Linux-amd64 IPClient.Linux-386 IPClient.Mac-arm64-m1 IPClient.Mac-x86-intel
 
+zip IPProxy.Mac.zip IPProxy.Mac-arm64-m1 IPProxy.Mac-x86-intel 
+zip IPProxy.Win.zip IPProxy.Win-amd64.exe IPProxy.Win-386.exe 
+zip IPProxy.Linux.zip IPProxy.Linux-amd64 IPProxy.Linux-386
+
+rm IPProxy.Win-amd64.exe IPProxy.Win-386.exe IPProxy.Linux-amd64 IPProxy.Linux-386 IPProxy.Mac-arm64-m1 IPProxy.Mac-x86-intel
+
+zip IPReplay.Mac.zip IPReplay.Mac-arm64-m1 IPReplay.Mac-x86-intel 
+zip IPReplay.Win.zip IPReplay.Win-amd64.exe IPReplay.Win-386.exe 
+zip IPReplay.Linux.zip IPReplay.Linux-amd64 IPReplay.Linux-386
+
+rm IPReplay.Win-amd64.exe IPReplay.Win-386.exe IPReplay.Linux-amd64 IPReplay.Linux-386 IPReplay.Mac-arm64-m1 IPReplay.Mac-x86-intel
+
 cd ..
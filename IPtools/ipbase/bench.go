@@ -0,0 +1,334 @@
+package ipbase
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+Benchmark packets are sent by ipclient -bench and echoed by ipserver -echo. Each starts with a header:
+
+	49 50 42 31   "IPB1"
+	xx xx xx xx   Size of the packet including the header, u32 big endian
+	xx xx xx xx   Sequence number, u32 big endian, counting from 0
+	xx ... xx     Time sent in nanoseconds since the benchmark started, u64 big endian
+
+and is padded to the size. The size lets the packets be found again in a TCP stream.
+*/
+
+var benchMagic = []byte("IPB1")
+
+// BenchHeaderSize is the smallest size of a benchmark packet
+const BenchHeaderSize = 20
+
+const benchMaxSize = 65507 // Largest UDP payload
+
+// benchLinger is how long to wait for echoes after the last packet is sent
+const benchLinger = 2 * time.Second
+
+// Bench sends sequence numbered, timestamped packets at a rate and measures what is echoed back
+type Bench struct {
+	size     int
+	rate     float64
+	duration time.Duration
+	csv      *os.File
+
+	start time.Time
+
+	mu            sync.Mutex
+	sent          int
+	sentBytes     int
+	received      map[uint32]bool
+	receivedBytes int
+	highest       int64 // Highest sequence number received, -1 before the first
+	reordered     int
+	duplicates    int
+	garbage       int
+	rtts          []time.Duration
+}
+
+// NewBench returns a benchmark sending packets of size bytes, rate times a second for duration.
+// Each echo is written as a line to the CSV file csvFilename, if given.
+func NewBench(size int, rate float64, duration time.Duration, csvFilename string) (*Bench, error) {
+	if size < BenchHeaderSize || size > benchMaxSize {
+		return nil, fmt.Errorf("size must be between %d and %d bytes", BenchHeaderSize, benchMaxSize)
+	}
+	if rate <= 0 {
+		return nil, fmt.Errorf("rate must be above 0")
+	}
+
+	bench := &Bench{size: size, rate: rate, duration: duration, received: make(map[uint32]bool), highest: -1}
+	if csvFilename != "" {
+		csv, err := os.Create(csvFilename)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintln(csv, "seq,sent_s,received_s,rtt_ms,status")
+		bench.csv = csv
+	}
+	return bench, nil
+}
+
+// Run sends the packets on c and reads the echoes with read, printing a status line every second and a summary at the end.
+// With datagrams, each read returns one packet (UDP), otherwise the reads are a stream (TCP).
+func (b *Bench) Run(c io.Writer, read func([]byte) (int, error), datagrams bool) error {
+	b.start = time.Now()
+
+	readErr := make(chan error, 1)
+	go func() {
+		readErr <- b.receive(read, datagrams)
+	}()
+
+	statusDone := make(chan bool)
+	go func() {
+		status := time.NewTicker(time.Second)
+		defer status.Stop()
+		for {
+			select {
+			case <-status.C:
+				b.printStatus()
+			case <-statusDone:
+				return
+			}
+		}
+	}()
+
+	interval := time.Duration(float64(time.Second) / b.rate)
+	packet := make([]byte, b.size)
+	copy(packet, benchMagic)
+	binary.BigEndian.PutUint32(packet[4:], uint32(b.size))
+	for i := BenchHeaderSize; i < b.size; i++ {
+		packet[i] = byte(i)
+	}
+
+	var err error
+sending:
+	for seq := 0; time.Duration(seq)*interval < b.duration; seq++ {
+		// Sending on schedule relative to the start, so a late packet is made up for by the next ones
+		select {
+		case <-time.After(time.Until(b.start.Add(time.Duration(seq) * interval))):
+		case err = <-readErr:
+			break sending
+		}
+
+		binary.BigEndian.PutUint32(packet[8:], uint32(seq))
+		binary.BigEndian.PutUint64(packet[12:], uint64(time.Since(b.start)))
+		if _, err = c.Write(packet); err != nil {
+			break
+		}
+
+		b.mu.Lock()
+		b.sent++
+		b.sentBytes += len(packet)
+		b.mu.Unlock()
+	}
+	sendingDone := time.Since(b.start)
+
+	// Waiting for the last echoes:
+	if err == nil {
+		linger := time.NewTimer(benchLinger)
+	waiting:
+		for !b.allReceived() {
+			select {
+			case <-linger.C:
+				break waiting
+			case err = <-readErr:
+				break waiting
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+		linger.Stop()
+	}
+	close(statusDone)
+
+	b.printSummary(sendingDone)
+	b.closeCSV()
+	return err
+}
+
+// receive reads echoes until read fails
+func (b *Bench) receive(read func([]byte) (int, error), datagrams bool) error {
+	byteArray := make([]byte, benchMaxSize+BenchHeaderSize)
+	var stream []byte
+	for {
+		byteCount, err := read(byteArray)
+		if err != nil {
+			return err
+		}
+		now := time.Since(b.start)
+
+		if datagrams {
+			packet := byteArray[:byteCount]
+			if byteCount < BenchHeaderSize || !bytes.Equal(packet[:4], benchMagic) || int(binary.BigEndian.Uint32(packet[4:])) != byteCount {
+				b.mu.Lock()
+				b.garbage += byteCount
+				b.mu.Unlock()
+				continue
+			}
+			b.echoed(packet, now)
+			continue
+		}
+
+		stream = append(stream, byteArray[:byteCount]...)
+		for len(stream) >= 8 {
+			size := int(binary.BigEndian.Uint32(stream[4:]))
+			if !bytes.Equal(stream[:4], benchMagic) || size < BenchHeaderSize || size > benchMaxSize {
+				// Skipping to the next header
+				skip := bytes.Index(stream[1:], benchMagic) + 1
+				if skip == 0 {
+					skip = len(stream) - len(benchMagic) + 1
+				}
+				b.mu.Lock()
+				b.garbage += skip
+				b.mu.Unlock()
+				stream = stream[skip:]
+				continue
+			}
+			if len(stream) < size {
+				break
+			}
+			b.echoed(stream[:size], now)
+			stream = stream[size:]
+		}
+	}
+}
+
+// echoed counts a packet received back at now
+func (b *Bench) echoed(packet []byte, now time.Duration) {
+	seq := binary.BigEndian.Uint32(packet[8:])
+	sentAt := time.Duration(binary.BigEndian.Uint64(packet[12:]))
+	rtt := now - sentAt
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	status := "ok"
+	switch {
+	case b.received[seq]:
+		b.duplicates++
+		status = "duplicate"
+	case int64(seq) < b.highest:
+		b.reordered++
+		status = "reordered"
+	}
+	if status != "duplicate" {
+		b.received[seq] = true
+		b.receivedBytes += len(packet)
+		b.rtts = append(b.rtts, rtt)
+	}
+	if int64(seq) > b.highest {
+		b.highest = int64(seq)
+	}
+
+	if b.csv != nil {
+		fmt.Fprintf(b.csv, "%d,%.6f,%.6f,%.3f,%s\n", seq, sentAt.Seconds(), now.Seconds(), float64(rtt)/float64(time.Millisecond), status)
+	}
+}
+
+func (b *Bench) allReceived() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.received) >= b.sent
+}
+
+// printStatus prints a line with the counts so far
+func (b *Bench) printStatus() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	elapsed := time.Since(b.start)
+	fmt.Printf("%5.1fs: sent %d, received %d, %s, RTT %s\n", elapsed.Seconds(), b.sent, len(b.received), bitrate(b.receivedBytes, elapsed), rttStats(b.rtts))
+}
+
+// printSummary prints the results. Throughput is measured over the time spent sending.
+func (b *Bench) printSummary(sendingDone time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	lost := b.sent - len(b.received)
+	fmt.Println()
+	fmt.Println("Benchmark summary:")
+	fmt.Printf("  packets:    %d of %d bytes sent in %v\n", b.sent, b.size, sendingDone.Round(time.Millisecond))
+	fmt.Printf("  sent:       %s\n", bitrate(b.sentBytes, sendingDone))
+	fmt.Printf("  received:   %s\n", bitrate(b.receivedBytes, sendingDone))
+	fmt.Printf("  RTT:        %s\n", rttStats(b.rtts))
+	fmt.Printf("  lost:       %d (%.2f%%)\n", lost, percent(lost, b.sent))
+	fmt.Printf("  reordered:  %d (%.2f%%)\n", b.reordered, percent(b.reordered, b.sent))
+	if b.duplicates > 0 {
+		fmt.Printf("  duplicates: %d\n", b.duplicates)
+	}
+	if b.garbage > 0 {
+		fmt.Printf("  garbage:    %d bytes that were not benchmark packets\n", b.garbage)
+	}
+	fmt.Println()
+
+	if b.csv != nil {
+		for seq := 0; seq < b.sent; seq++ {
+			if !b.received[uint32(seq)] {
+				fmt.Fprintf(b.csv, "%d,%.6f,,,lost\n", seq, (time.Duration(seq) * time.Duration(float64(time.Second)/b.rate)).Seconds())
+			}
+		}
+	}
+}
+
+func (b *Bench) closeCSV() {
+	if b.csv != nil {
+		b.csv.Close()
+	}
+}
+
+// bitrate formats bytes over elapsed as kbit/s
+func bitrate(byteCount int, elapsed time.Duration) string {
+	if elapsed <= 0 {
+		return "0.0 kbit/s"
+	}
+	return fmt.Sprintf("%.1f kbit/s", float64(byteCount)*8/1000/elapsed.Seconds())
+}
+
+// rttStats formats min, average and 99th percentile of rtts
+func rttStats(rtts []time.Duration) string {
+	if len(rtts) == 0 {
+		return "-"
+	}
+	sorted := append([]time.Duration(nil), rtts...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var sum time.Duration
+	for _, rtt := range sorted {
+		sum += rtt
+	}
+	p99 := sorted[(len(sorted)*99+99)/100-1]
+	return fmt.Sprintf("min %.3f ms, avg %.3f ms, p99 %.3f ms", ms(sorted[0]), ms(sum/time.Duration(len(sorted))), ms(p99))
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func percent(count int, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(count) * 100 / float64(total)
+}
+
+// Echo sends everything read on connection back, without printing it, until reading fails. It's the peer for a benchmark over TCP.
+func Echo(connection net.Conn) {
+	byteArray := make([]byte, 65536)
+	for {
+		byteCount, err := connection.Read(byteArray)
+		if err != nil {
+			return
+		}
+		if _, err := connection.Write(byteArray[:byteCount]); err != nil {
+			return
+		}
+	}
+}
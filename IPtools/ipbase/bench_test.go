@@ -0,0 +1,130 @@
+package ipbase
+
+import (
+	"encoding/binary"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// benchPacket returns a benchmark packet as sent by Bench.Run
+func benchPacket(seq uint32, sentAt time.Duration, size int) []byte {
+	packet := make([]byte, size)
+	copy(packet, benchMagic)
+	binary.BigEndian.PutUint32(packet[4:], uint32(size))
+	binary.BigEndian.PutUint32(packet[8:], seq)
+	binary.BigEndian.PutUint64(packet[12:], uint64(sentAt))
+	return packet
+}
+
+// reads returns a read function returning the chunks one by one, then io.EOF
+func reads(chunks ...[]byte) func([]byte) (int, error) {
+	return func(buffer []byte) (int, error) {
+		if len(chunks) == 0 {
+			return 0, io.EOF
+		}
+		count := copy(buffer, chunks[0])
+		chunks = chunks[1:]
+		return count, nil
+	}
+}
+
+func newTestBench(t *testing.T) *Bench {
+	t.Helper()
+	bench, err := NewBench(32, 100, time.Second, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bench.start = time.Now()
+	return bench
+}
+
+func TestBenchReceiveStream(t *testing.T) {
+	p0, p1, p2 := benchPacket(0, 0, 32), benchPacket(1, 0, 32), benchPacket(2, 0, 40)
+	stream := string(p0) + "garbage" + "IPB1\xFF\xFF\xFF\xFF" + string(p2) + string(p1) + string(p1)
+
+	bench := newTestBench(t)
+	chunks := [][]byte{}
+	for i := 0; i < len(stream); i += 13 { // Packets split across reads
+		chunks = append(chunks, []byte(stream[i:min(i+13, len(stream))]))
+	}
+	if err := bench.receive(reads(chunks...), false); err != io.EOF {
+		t.Fatal(err)
+	}
+
+	if len(bench.received) != 3 || bench.reordered != 1 || bench.duplicates != 1 || bench.highest != 2 {
+		t.Errorf("received %v, %d reordered, %d duplicates, highest %d", bench.received, bench.reordered, bench.duplicates, bench.highest)
+	}
+	if bench.garbage != len("garbage")+8 {
+		t.Errorf("expected %d bytes of garbage, got %d", len("garbage")+8, bench.garbage)
+	}
+	if bench.receivedBytes != 32+40+32 || len(bench.rtts) != 3 {
+		t.Errorf("received %d bytes with %d RTTs", bench.receivedBytes, len(bench.rtts))
+	}
+}
+
+func TestBenchReceiveDatagrams(t *testing.T) {
+	bench := newTestBench(t)
+	bad := benchPacket(5, 0, 32)
+	binary.BigEndian.PutUint32(bad[4:], 40) // Size doesn't match the datagram
+	err := bench.receive(reads(benchPacket(1, 0, 32), []byte("IPB1"), bad, benchPacket(0, 0, 32)), true)
+	if err != io.EOF {
+		t.Fatal(err)
+	}
+	if len(bench.received) != 2 || bench.reordered != 1 || bench.garbage != 4+32 {
+		t.Errorf("received %v, %d reordered, %d bytes of garbage", bench.received, bench.reordered, bench.garbage)
+	}
+}
+
+func TestBenchEchoedRTT(t *testing.T) {
+	bench := newTestBench(t)
+	bench.echoed(benchPacket(0, 10*time.Millisecond, 32), 25*time.Millisecond)
+	if len(bench.rtts) != 1 || bench.rtts[0] != 15*time.Millisecond {
+		t.Errorf("unexpected RTTs %v", bench.rtts)
+	}
+	bench.sent = 2
+	if bench.allReceived() {
+		t.Error("one of two packets is not all")
+	}
+}
+
+func TestRTTStats(t *testing.T) {
+	if stats := rttStats(nil); stats != "-" {
+		t.Errorf("got %q", stats)
+	}
+	if stats := rttStats([]time.Duration{time.Millisecond}); stats != "min 1.000 ms, avg 1.000 ms, p99 1.000 ms" {
+		t.Errorf("got %q", stats)
+	}
+
+	rtts := []time.Duration{}
+	for i := 100; i >= 1; i-- {
+		rtts = append(rtts, time.Duration(i)*time.Millisecond)
+	}
+	if stats := rttStats(rtts); stats != "min 1.000 ms, avg 50.500 ms, p99 99.000 ms" {
+		t.Errorf("got %q", stats)
+	}
+	if rtts[0] != 100*time.Millisecond {
+		t.Error("the RTTs were sorted in place")
+	}
+
+	rtts = append(rtts, 101*time.Millisecond) // With 101 values, the 99th percentile is the 100th
+	if stats := rttStats(rtts); !strings.HasSuffix(stats, "p99 100.000 ms") {
+		t.Errorf("got %q", stats)
+	}
+}
+
+func TestNewBenchErrors(t *testing.T) {
+	for _, test := range []struct {
+		size int
+		rate float64
+	}{
+		{BenchHeaderSize - 1, 10},
+		{benchMaxSize + 1, 10},
+		{100, 0},
+	} {
+		if _, err := NewBench(test.size, test.rate, time.Second, ""); err == nil {
+			t.Errorf("size %d, rate %v: expected an error", test.size, test.rate)
+		}
+	}
+}
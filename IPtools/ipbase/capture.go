@@ -0,0 +1,101 @@
+package ipbase
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Capture writes the traffic seen by a tool to a file, one line per read or write:
+//
+//	2024-05-01T12:00:00.123456Z 1 C>T 48574323333D446F776E0A
+//
+// with the time, the connection number, the direction and the bytes in hex. A nil *Capture does nothing.
+type Capture struct {
+	file *os.File
+	mu   sync.Mutex
+}
+
+// CreateCapture creates (or truncates) a capture file
+func CreateCapture(filename string) (*Capture, error) {
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	return &Capture{file: file}, nil
+}
+
+// Write adds a line for data going in direction on connection number conn
+func (c *Capture) Write(conn int, direction string, data []byte) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(c.file, "%s %d %s %X\n", time.Now().UTC().Format(time.RFC3339Nano), conn, direction, data)
+}
+
+// Close closes the file
+func (c *Capture) Close() error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.file.Close()
+}
+
+// CaptureRecord is one line of a capture file
+type CaptureRecord struct {
+	Time      time.Time
+	Conn      int
+	Direction string
+	Data      []byte
+}
+
+// ReadCapture reads the records of a capture file
+func ReadCapture(filename string) ([]CaptureRecord, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	records := []CaptureRecord{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1024*1024)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 3 || len(fields) > 4 {
+			return nil, fmt.Errorf("%s: line %d: expected time, connection, direction and hex bytes", filename, lineNumber)
+		}
+
+		record := CaptureRecord{Direction: fields[2]}
+		if record.Time, err = time.Parse(time.RFC3339Nano, fields[0]); err != nil {
+			return nil, fmt.Errorf("%s: line %d: %w", filename, lineNumber, err)
+		}
+		if record.Conn, err = strconv.Atoi(fields[1]); err != nil {
+			return nil, fmt.Errorf("%s: line %d: bad connection %q", filename, lineNumber, fields[1])
+		}
+		if len(fields) == 4 {
+			if record.Data, err = hex.DecodeString(fields[3]); err != nil {
+				return nil, fmt.Errorf("%s: line %d: %w", filename, lineNumber, err)
+			}
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return records, nil
+}
@@ -0,0 +1,84 @@
+package ipbase
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCaptureRoundTrip(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "capture.txt")
+	capture, err := CreateCapture(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	capture.Write(1, "C>T", []byte("list\n"))
+	capture.Write(2, "T>C", []byte{0x00, 0xFF})
+	capture.Write(2, "T>C", nil)
+	if err := capture.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := ReadCapture(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("expected 3 records, got %v", records)
+	}
+	if records[0].Conn != 1 || records[0].Direction != "C>T" || string(records[0].Data) != "list\n" {
+		t.Errorf("unexpected record %+v", records[0])
+	}
+	if records[1].Conn != 2 || records[1].Direction != "T>C" || !bytes.Equal(records[1].Data, []byte{0x00, 0xFF}) {
+		t.Errorf("unexpected record %+v", records[1])
+	}
+	if len(records[2].Data) != 0 {
+		t.Errorf("expected no data, got %+v", records[2])
+	}
+	if records[0].Time.Before(start.Add(-time.Second)) || records[2].Time.Before(records[0].Time) {
+		t.Errorf("unexpected times %v, %v", records[0].Time, records[2].Time)
+	}
+}
+
+func TestReadCapture(t *testing.T) {
+	dir := t.TempDir()
+	write := func(content string) string {
+		filename := filepath.Join(dir, "capture.txt")
+		if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return filename
+	}
+
+	records, err := ReadCapture(write("\n2024-05-01T12:00:00.123456Z 3 RX 0a0B\n\n2024-05-01T12:00:01Z 3 TX\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].Conn != 3 || records[0].Direction != "RX" || !bytes.Equal(records[0].Data, []byte{0x0A, 0x0B}) {
+		t.Fatalf("unexpected records %+v", records)
+	}
+	if gap := records[1].Time.Sub(records[0].Time); gap != 876544*time.Microsecond {
+		t.Errorf("unexpected time between records: %v", gap)
+	}
+
+	for _, content := range []string{
+		"2024-05-01T12:00:00Z 1",
+		"2024-05-01T12:00:00Z 1 RX 00 01",
+		"12:00:00 1 RX 00",
+		"2024-05-01T12:00:00Z one RX 00",
+		"2024-05-01T12:00:00Z 1 RX 0",
+	} {
+		_, err := ReadCapture(write(content))
+		if err == nil || !strings.Contains(err.Error(), "line 1") {
+			t.Errorf("%q: expected an error on line 1, got %v", content, err)
+		}
+	}
+
+	if _, err := ReadCapture(filepath.Join(dir, "missing.txt")); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
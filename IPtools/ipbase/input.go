@@ -0,0 +1,200 @@
+package ipbase
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxHistory is the number of lines kept for !! and !N
+const maxHistory = 100
+
+// LoadMacros reads a macros file, one macro per line: A name followed by what to send, as if typed after !name:
+//
+//	# Comments start with #
+//	ping PING\r\n
+//	status {hex:02 10 00} STATUS\x03
+//
+// Escapes only work with ReaderConfig.Escapes (-esc), like when typed.
+func LoadMacros(filename string) (map[string]string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	macros := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimRight(scanner.Text(), "\r")
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		name := fields[0]
+		if _, err := strconv.Atoi(name); err == nil || name == "!" || name == "history" || name == "macros" {
+			return nil, fmt.Errorf("%s: line %d: %q can't be a macro name", filename, lineNumber, name)
+		}
+		if _, exists := macros[name]; exists {
+			return nil, fmt.Errorf("%s: line %d: macro %q is defined twice", filename, lineNumber, name)
+		}
+		macros[name] = strings.TrimLeft(strings.TrimSpace(line)[len(name):], " \t")
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return macros, nil
+}
+
+// input reads lines from stdin for the line readers. If macros are given (even none), it keeps a history and handles the ! commands:
+//
+//	!name     Sends a macro
+//	!!        Sends the last line again
+//	!N        Sends line N of the history again
+//	!history  Lists the history
+//	!macros   Lists the macros
+//
+// Without macros, every line is sent as typed, so ! can still be sent to a device.
+type input struct {
+	reader  *bufio.Reader
+	macros  map[string]string // Nil if the ! commands are off
+	history []string          // The last maxHistory lines, oldest first
+}
+
+func newInput(rConfig ReaderConfig) *input {
+	return &input{reader: bufio.NewReader(os.Stdin), macros: rConfig.Macros, history: make([]string, 0, maxHistory)}
+}
+
+// line returns the next line to send, with the line ending as typed, or false if it was a command with nothing to send
+func (in *input) line() (string, bool) {
+	text, err := in.reader.ReadString('\n')
+	if err != nil && text == "" {
+		// Stdin is closed, like when input is piped, so nothing more will be typed. The tool keeps running on the network
+		select {}
+	}
+	if in.macros == nil {
+		return text, true
+	}
+	if !strings.HasPrefix(text, "!") {
+		in.remember(text)
+		return text, true
+	}
+
+	command := strings.TrimSpace(text[1:])
+	switch {
+	case command == "!":
+		if len(in.history) == 0 {
+			fmt.Println("No history yet")
+			fmt.Println()
+			return "", false
+		}
+		text = in.history[len(in.history)-1]
+	case command == "history":
+		for n, line := range in.history {
+			fmt.Printf("%3d: %s\n", n+1, strings.TrimRight(line, "\r\n"))
+		}
+		fmt.Println()
+		return "", false
+	case command == "macros":
+		names := make([]string, 0, len(in.macros))
+		for name := range in.macros {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Printf("!%s: %s\n", name, in.macros[name])
+		}
+		fmt.Println()
+		return "", false
+	default:
+		if n, err := strconv.Atoi(command); err == nil {
+			if n < 1 || n > len(in.history) {
+				fmt.Printf("No line %d in the history\n\n", n)
+				return "", false
+			}
+			text = in.history[n-1]
+		} else if macro, exists := in.macros[command]; exists {
+			text = macro + "\n"
+		} else {
+			fmt.Printf("Unknown macro %q. Use !macros, !history, !! and !N\n\n", command)
+			return "", false
+		}
+	}
+	in.remember(text)
+	return text, true
+}
+
+func (in *input) remember(text string) {
+	if strings.TrimSpace(text) == "" {
+		return
+	}
+	if len(in.history) < maxHistory {
+		in.history = append(in.history, text)
+		return
+	}
+	copy(in.history, in.history[1:]) // Keeps the backing array, instead of letting the slice wander through new ones
+	in.history[maxHistory-1] = text
+}
+
+// unescape decodes the escapes \r, \n, \t, \xNN and {hex:...} in text. A backslash before any other character stands for the character itself.
+func unescape(text string) ([]byte, error) {
+	bytes := []byte{}
+	for i := 0; i < len(text); {
+		switch {
+		case text[i] == '\\':
+			decoded, next, err := escape(text, i)
+			if err != nil {
+				return nil, err
+			}
+			bytes = append(bytes, decoded...)
+			i = next
+		case strings.HasPrefix(text[i:], "{hex:"):
+			end := strings.Index(text[i:], "}")
+			if end < 0 {
+				return nil, fmt.Errorf("missing } after {hex:")
+			}
+			decoded, err := hex.DecodeString(strings.ReplaceAll(text[i+len("{hex:"):i+end], " ", ""))
+			if err != nil {
+				return nil, fmt.Errorf("bad hex in %s", text[i:i+end+1])
+			}
+			bytes = append(bytes, decoded...)
+			i += end + 1
+		default:
+			bytes = append(bytes, text[i])
+			i++
+		}
+	}
+	return bytes, nil
+}
+
+// escape decodes the escape starting with the backslash at text[i] and returns the bytes and the index after it
+func escape(text string, i int) ([]byte, int, error) {
+	if i+1 >= len(text) {
+		return nil, 0, fmt.Errorf("escape at the end of the line")
+	}
+	switch text[i+1] {
+	case 'r':
+		return []byte{'\r'}, i + 2, nil
+	case 'n':
+		return []byte{'\n'}, i + 2, nil
+	case 't':
+		return []byte{'\t'}, i + 2, nil
+	case 'x':
+		if i+4 > len(text) {
+			return nil, 0, fmt.Errorf("expected two hex digits after \\x")
+		}
+		value, err := hex.DecodeString(text[i+2 : i+4])
+		if err != nil {
+			return nil, 0, fmt.Errorf("bad escape \\x%s", text[i+2:i+4])
+		}
+		return value, i + 4, nil
+	}
+	return []byte{text[i+1]}, i + 2, nil
+}
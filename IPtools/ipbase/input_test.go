@@ -0,0 +1,88 @@
+package ipbase
+
+import (
+	"bufio"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// typed returns an input reading the given lines, with the ! commands on if macros is not nil
+func typed(macros map[string]string, lines ...string) *input {
+	in := newInput(ReaderConfig{Macros: macros})
+	in.reader = bufio.NewReader(strings.NewReader(strings.Join(lines, "")))
+	return in
+}
+
+// sent returns what is sent for each line read by in, with "-" for lines sending nothing
+func sent(in *input, count int) []string {
+	results := []string{}
+	for i := 0; i < count; i++ {
+		text, ok := in.line()
+		if !ok {
+			text = "-"
+		}
+		results = append(results, text)
+	}
+	return results
+}
+
+func TestInputWithoutMacros(t *testing.T) {
+	in := typed(nil, "PING\n", "!!\n", "!status\n")
+	got := sent(in, 3)
+	if strings.Join(got, "") != "PING\n!!\n!status\n" {
+		t.Errorf("expected lines as typed, got %q", got)
+	}
+	if len(in.history) != 0 {
+		t.Errorf("expected no history, got %q", in.history)
+	}
+}
+
+func TestInputCommands(t *testing.T) {
+	in := typed(map[string]string{"status": `STATUS\r\n`}, "PING\n", "!!\n", "!status\n", "!1\n", "!9\n", "!unknown\n", "!history\n", "\n")
+	got := sent(in, 8)
+	expected := []string{"PING\n", "PING\n", "STATUS\\r\\n\n", "PING\n", "-", "-", "-", "\n"}
+	if strings.Join(got, "|") != strings.Join(expected, "|") {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+	if len(in.history) != 4 {
+		t.Errorf("expected the sent lines in the history, got %q", in.history)
+	}
+}
+
+func TestInputHistoryLimit(t *testing.T) {
+	in := typed(map[string]string{})
+	for i := 1; i <= maxHistory+10; i++ {
+		in.remember(strconv.Itoa(i) + "\n")
+	}
+	if len(in.history) != maxHistory || cap(in.history) != maxHistory {
+		t.Fatalf("expected %d lines, got %d with capacity %d", maxHistory, len(in.history), cap(in.history))
+	}
+	if in.history[0] != "11\n" || in.history[maxHistory-1] != strconv.Itoa(maxHistory+10)+"\n" {
+		t.Errorf("expected the last lines, got %q ... %q", in.history[0], in.history[maxHistory-1])
+	}
+}
+
+func TestUnescape(t *testing.T) {
+	tests := []struct {
+		text     string
+		expected string
+	}{
+		{`PING\r\n`, "PING\r\n"},
+		{`\x02DATA\x03`, "\x02DATA\x03"},
+		{`{hex:02 10 00}STATUS`, "\x02\x10\x00STATUS"},
+		{`a\\b\{hex:00}`, `a\b{hex:00}`},
+	}
+	for _, test := range tests {
+		decoded, err := unescape(test.text)
+		if err != nil || string(decoded) != test.expected {
+			t.Errorf("%q: expected %q, got %q, %v", test.text, test.expected, decoded, err)
+		}
+	}
+
+	for _, bad := range []string{`end\`, `\x4`, `\xZZ`, `{hex:02`, `{hex:0}`} {
+		if _, err := unescape(bad); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
@@ -1,13 +1,15 @@
 package ipbase
 
 import (
-	"bufio"
 	"encoding/hex"
 	"fmt"
+	"io"
 	"net"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 type ReaderConfig struct {
@@ -15,127 +17,315 @@ type ReaderConfig struct {
 	Nolf bool
 	Crlf bool
 	Cr   bool
+	RWP  string // If "panel" or "system" (the peer), Raw Panel binary frames sent are decoded and printed
+
+	Escapes bool              // Text may contain \r, \n, \t, \xNN and {hex:...}
+	Macros  map[string]string // Sent for !name, see LoadMacros. Without macros, lines starting with ! are sent as typed
+}
+
+// TCPconnection is a client connected to ipserver
+type TCPconnection struct {
+	ID    int
+	Conn  net.Conn
+	Since time.Time
 }
+
+// TCPconnections is the set of connected clients, or UDP peers heard from (see UDPpeer). It's safe for concurrent use.
 type TCPconnections struct {
 	sync.RWMutex
+	Session *SessionLog // Records the traffic of the connections added, if set
 
-	connections []*net.Conn
+	lastID      int
+	connections []*TCPconnection
 }
 
-func (tcpconnections *TCPconnections) Add(c *net.Conn) {
+// Add adds a connection and returns its ID and the connection to use from now on, which records to the session log.
+// IDs count from 1 and are not reused.
+func (tcpconnections *TCPconnections) Add(c net.Conn) (int, net.Conn) {
 	tcpconnections.Lock()
 	defer tcpconnections.Unlock()
 
-	tcpconnections.connections = append(tcpconnections.connections, c)
+	tcpconnections.lastID++
+	c = tcpconnections.Session.Conn(c, tcpconnections.lastID)
+	tcpconnections.connections = append(tcpconnections.connections, &TCPconnection{ID: tcpconnections.lastID, Conn: c, Since: time.Now()})
+	return tcpconnections.lastID, c
 }
 
-func (tcpconnections *TCPconnections) Remove(c *net.Conn) {
+func (tcpconnections *TCPconnections) Remove(c net.Conn) {
 	tcpconnections.Lock()
 	defer tcpconnections.Unlock()
 
 	for s, cf := range tcpconnections.connections {
-		if cf == c {
-			fmt.Printf("Remove entry %d\n", s)
+		if cf.Conn == c {
 			tcpconnections.connections = append(tcpconnections.connections[:s], tcpconnections.connections[s+1:]...)
 			break
 		}
 	}
 }
 
-func Listener(connection net.Conn) {
+// Get returns the connection with the ID, or nil
+func (tcpconnections *TCPconnections) Get(id int) net.Conn {
+	tcpconnections.RLock()
+	defer tcpconnections.RUnlock()
+
+	for _, cf := range tcpconnections.connections {
+		if cf.ID == id {
+			return cf.Conn
+		}
+	}
+	return nil
+}
+
+// Find returns the ID and connection for a remote address, or 0 and nil
+func (tcpconnections *TCPconnections) Find(addr net.Addr) (int, net.Conn) {
+	tcpconnections.RLock()
+	defer tcpconnections.RUnlock()
+
+	for _, cf := range tcpconnections.connections {
+		if cf.Conn.RemoteAddr().String() == addr.String() {
+			return cf.ID, cf.Conn
+		}
+	}
+	return 0, nil
+}
+
+// Kick removes the connection with the ID and closes it. False is returned if there is none.
+func (tcpconnections *TCPconnections) Kick(id int) bool {
+	tcpconnections.Lock()
+	var kicked net.Conn
+	for s, cf := range tcpconnections.connections {
+		if cf.ID == id {
+			kicked = cf.Conn
+			tcpconnections.connections = append(tcpconnections.connections[:s], tcpconnections.connections[s+1:]...)
+			break
+		}
+	}
+	tcpconnections.Unlock()
+
+	if kicked == nil {
+		return false
+	}
+	kicked.Close()
+	return true
+}
+
+// List returns a copy of the connections, oldest first
+func (tcpconnections *TCPconnections) List() []TCPconnection {
+	tcpconnections.RLock()
+	defer tcpconnections.RUnlock()
+
+	list := make([]TCPconnection, 0, len(tcpconnections.connections))
+	for _, cf := range tcpconnections.connections {
+		list = append(list, *cf)
+	}
+	return list
+}
+
+// Write sends bytes to all connections. A failed connection doesn't stop the others, the first error is returned with its ID.
+func (tcpconnections *TCPconnections) Write(bytes []byte) error {
+	var firstErr error
+	for _, cf := range tcpconnections.List() {
+		if _, err := cf.Conn.Write(bytes); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("#%d: %w", cf.ID, err)
+		}
+	}
+	return firstErr
+}
+
+// Listener prints what is received on connection until reading fails, and returns the error. Raw Panel frames are decoded too if rwpDecoder is given, and answered by responder if given.
+func Listener(connection net.Conn, rwpDecoder *RWPDecoder, responder *Responder) error {
 	byteArray := make([]byte, 2000)
 	for {
 		byteCount, err := connection.Read(byteArray)
 		if err != nil {
-			fmt.Println("err != nil")
-			fmt.Println(err)
-			fmt.Println("")
-			return
+			return err
 		}
 
 		PrintoutBytes(byteArray, byteCount, 16, "RECV: ")
+		rwpDecoder.Print(byteArray[:byteCount])
+		responder.Received(byteArray[:byteCount], func(bytes []byte) error {
+			_, err := connection.Write(bytes)
+			return err
+		})
 	}
 }
 
-func ListenerUDP(connection *net.UDPConn) {
+// ListenerUDP prints what is received on connection, with the source as it may be anyone answering a broadcast or multicast.
+// It's recorded to session as connection 1.
+func ListenerUDP(connection *net.UDPConn, rwpDecoder *RWPDecoder, session *SessionLog) {
 	byteArray := make([]byte, 2000)
 	for {
-		byteCount, _, err := connection.ReadFromUDP(byteArray)
+		byteCount, addr, err := connection.ReadFromUDP(byteArray)
 		if err != nil {
 			fmt.Println(err)
 			fmt.Println("")
 			return
 		}
+		session.Record(1, "RECV", byteArray[:byteCount])
 
-		PrintoutBytes(byteArray, byteCount, 16, "RECV: ")
+		PrintoutBytes(byteArray, byteCount, 16, "RECV "+addr.String()+": ")
+		rwpDecoder.Print(byteArray[:byteCount])
 	}
 }
 
 // PrintoutBytes Public... upper case!
 func PrintoutBytes(byteArray []byte, byteCount int, setSize int, prefix string) {
+	FprintoutBytes(os.Stdout, byteArray, byteCount, setSize, prefix)
+}
+
+// FprintoutBytes is PrintoutBytes writing to w
+func FprintoutBytes(w io.Writer, byteArray []byte, byteCount int, setSize int, prefix string) {
 
 	for ptr := 0; ptr < byteCount; ptr += setSize {
 
-		fmt.Print(prefix)
+		fmt.Fprint(w, prefix)
 
 		for j := 0; j < setSize; j++ {
 			if j+ptr < byteCount {
-				fmt.Printf("%02X ", byteArray[j+ptr])
+				fmt.Fprintf(w, "%02X ", byteArray[j+ptr])
 			} else {
-				fmt.Printf("   ")
+				fmt.Fprintf(w, "   ")
 			}
 		}
 
 		substr := string(byteArray)[ptr:QInt(ptr+setSize < byteCount, ptr+setSize, byteCount)]
 
-		fmt.Println(" " + strings.ReplaceAll(substr, "\n", " "))
+		fmt.Fprintln(w, " "+strings.ReplaceAll(substr, "\n", " "))
 	}
-	fmt.Println()
+	fmt.Fprintln(w)
 }
 
-func Linereader(connection net.Conn, rConfig ReaderConfig) {
+// Linereader sends what is typed to connection
+func Linereader(connection io.Writer, rConfig ReaderConfig) {
+	rwpDecoder := NewRWPDecoder(rConfig.RWP, true)
+	in := newInput(rConfig)
 	for {
-		reader := bufio.NewReader(os.Stdin)
-		text, _ := reader.ReadString('\n')
+		text, ok := in.line()
+		if !ok {
+			continue
+		}
 
 		bytes := parseInput(text, rConfig)
 
 		PrintoutBytes(bytes, len(bytes), 16, "SENT: ")
-		connection.Write(bytes)
+		rwpDecoder.Print(bytes)
+		if _, err := connection.Write(bytes); err != nil {
+			fmt.Println(err)
+			fmt.Println("")
+		}
 	}
 }
 
+// LinereaderConnections sends what is typed to all connections. These commands are handled too:
+//
+//	/list              Lists the connections with their IDs
+//	/to <id> <payload> Sends the rest of the line to one connection
+//	/kick <id>         Closes a connection, or forgets a UDP peer
+//
+// A line starting with // is sent with one / less.
 func LinereaderConnections(connections *TCPconnections, rConfig ReaderConfig) {
+	rwpDecoder := NewRWPDecoder(rConfig.RWP, true)
+	in := newInput(rConfig)
 	for {
-		reader := bufio.NewReader(os.Stdin)
-		text, _ := reader.ReadString('\n')
+		text, ok := in.line()
+		if !ok {
+			continue
+		}
+
+		id, text, send := connectionCommand(text, connections)
+		if !send {
+			continue
+		}
 
 		bytes := parseInput(text, rConfig)
 
-		PrintoutBytes(bytes, len(bytes), 16, "SENT: ")
+		PrintoutBytes(bytes, len(bytes), 16, QStr(id == 0, "SENT: ", fmt.Sprintf("SENT #%d: ", id)))
+		rwpDecoder.Print(bytes)
 
-		for s, c := range connections.connections {
-			fmt.Printf("Print to connection %d\n", s)
-			(*c).Write(bytes)
+		var err error
+		if id == 0 {
+			err = connections.Write(bytes)
+		} else if c := connections.Get(id); c != nil {
+			if _, err = c.Write(bytes); err != nil {
+				err = fmt.Errorf("#%d: %w", id, err)
+			}
+		}
+		if err != nil {
+			fmt.Println(err)
+			fmt.Println("")
 		}
 	}
 }
 
-func LinereaderChannel(returnMessage chan []byte, rConfig ReaderConfig) {
-	for {
-		reader := bufio.NewReader(os.Stdin)
-		text, _ := reader.ReadString('\n')
-
-		bytes := parseInput(text, rConfig)
+// connectionCommand handles a command for LinereaderConnections. It returns the ID of the connection to send to (0 for all)
+// and what to send, or false if there is nothing to send.
+func connectionCommand(text string, connections *TCPconnections) (int, string, bool) {
+	if !strings.HasPrefix(text, "/") {
+		return 0, text, true
+	}
+	if strings.HasPrefix(text, "//") {
+		return 0, text[1:], true
+	}
 
-		PrintoutBytes(bytes, len(bytes), 16, "KEYB: ")
-		returnMessage <- bytes
+	fields := strings.Fields(text)
+	switch {
+	case fields[0] == "/list" && len(fields) == 1:
+		list := connections.List()
+		if len(list) == 0 {
+			fmt.Println("No connections")
+		}
+		for _, cf := range list {
+			fmt.Printf("#%d: %s, since %s\n", cf.ID, cf.Conn.RemoteAddr(), cf.Since.Format("15:04:05"))
+		}
+		fmt.Println()
+	case fields[0] == "/kick" && len(fields) == 2:
+		id, _ := strconv.Atoi(fields[1])
+		if !connections.Kick(id) {
+			fmt.Printf("No connection %s\n\n", fields[1])
+			break
+		}
+		fmt.Printf("#%d kicked\n\n", id)
+	case fields[0] == "/to" && len(fields) >= 2:
+		id, _ := strconv.Atoi(fields[1])
+		if connections.Get(id) == nil {
+			fmt.Printf("No connection %s\n\n", fields[1])
+			break
+		}
+		payload := strings.TrimPrefix(strings.TrimLeft(text[len("/to"):], " \t"), fields[1])
+		if strings.HasPrefix(payload, " ") {
+			payload = payload[1:]
+		}
+		return id, payload, true
+	default:
+		fmt.Println("Commands: /list, /to <id> <payload>, /kick <id>. Start a line with // to send a /")
+		fmt.Println()
 	}
+	return 0, "", false
 }
 
 func parseInput(text string, rConfig ReaderConfig) []byte {
 	var bytes []byte
 
+	if rConfig.Escapes && !rConfig.Hex {
+		if text == "" {
+			return nil
+		}
+		body, err := unescape(strings.TrimSuffix(strings.TrimSuffix(text, "\n"), "\r"))
+		if err != nil {
+			fmt.Println(err)
+			return nil
+		}
+		switch {
+		case rConfig.Nolf:
+			return body
+		case rConfig.Crlf:
+			return append(body, '\r', '\n')
+		case rConfig.Cr:
+			return append(body, '\r')
+		}
+		return append(body, '\n')
+	}
+
 	if rConfig.Hex {
 		_bytes, err := hex.DecodeString(strings.ReplaceAll(text[0:len(text)-1], " ", ""))
 		if err != nil {
@@ -0,0 +1,122 @@
+package ipbase
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// firstBackoff is the wait before the first attempt to reconnect. It doubles for each failed attempt.
+const firstBackoff = 500 * time.Millisecond
+
+// Redialer keeps a TCP connection to a peer, dialing it again when it's lost if reconnect is set.
+// Writes go to the current connection, and fail while there is none.
+type Redialer struct {
+	dial       func() (net.Conn, error)
+	reconnect  bool
+	maxBackoff time.Duration
+	session    *SessionLog
+
+	mu        sync.Mutex
+	conn      net.Conn
+	connCount int
+	connected chan struct{} // Closed at the first connection
+	done      chan struct{} // Closed when Run returns
+}
+
+// NewRedialer returns a Redialer connecting with dial. If reconnect is set, it waits up to maxBackoff between attempts.
+// The connections are recorded to session, if given.
+func NewRedialer(dial func() (net.Conn, error), reconnect bool, maxBackoff time.Duration, session *SessionLog) *Redialer {
+	return &Redialer{dial: dial, reconnect: reconnect, maxBackoff: maxBackoff, session: session, connected: make(chan struct{}), done: make(chan struct{})}
+}
+
+// Run connects and calls serve with each connection, which should read until the connection fails and return why.
+// The connections are numbered from 1 in the session log. Without reconnect, Run returns when the first connection fails or can't be made.
+func (r *Redialer) Run(serve func(net.Conn) error) {
+	defer close(r.done)
+
+	backoff := firstBackoff
+	for {
+		c, err := r.dial()
+		if err != nil {
+			event("Can't connect: %v", err)
+		} else {
+			backoff = firstBackoff
+
+			r.mu.Lock()
+			r.connCount++
+			c = r.session.Conn(c, r.connCount)
+			r.conn = c
+			if r.connCount == 1 {
+				close(r.connected)
+			}
+			r.mu.Unlock()
+
+			event("Connected to %s from %s", c.RemoteAddr(), c.LocalAddr())
+			err = serve(c)
+
+			r.mu.Lock()
+			r.conn = nil
+			r.mu.Unlock()
+			c.Close()
+			event("Disconnected from %s: %v", c.RemoteAddr(), err)
+		}
+
+		if !r.reconnect {
+			return
+		}
+		event("Reconnecting in %v...", backoff)
+		time.Sleep(backoff)
+		backoff = min(backoff*2, r.maxBackoff)
+	}
+}
+
+// Connected is closed at the first connection
+func (r *Redialer) Connected() <-chan struct{} {
+	return r.connected
+}
+
+// Done is closed when Run returns
+func (r *Redialer) Done() <-chan struct{} {
+	return r.done
+}
+
+func (r *Redialer) Write(b []byte) (int, error) {
+	r.mu.Lock()
+	c := r.conn
+	r.mu.Unlock()
+
+	if c == nil {
+		return 0, errors.New("not connected, nothing was sent")
+	}
+	return c.Write(b)
+}
+
+// SendKeepalives writes payload to w every interval, forever. A failed write is skipped, as the connection may be down for a while.
+func SendKeepalives(w io.Writer, payload []byte, interval time.Duration) {
+	for range time.Tick(interval) {
+		if _, err := w.Write(payload); err == nil {
+			PrintoutBytes(payload, len(payload), 16, "KEEPALIVE: ")
+		}
+	}
+}
+
+// KeepalivePayload decodes a keepalive payload given on the command line, with \r, \n, \t, \xNN and {hex:...} like typed text with -esc
+func KeepalivePayload(text string) ([]byte, error) {
+	payload, err := unescape(text)
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) == 0 {
+		return nil, errors.New("the keepalive payload is empty")
+	}
+	return payload, nil
+}
+
+// event prints a connection event with the time, so it stands out from the traffic
+func event(format string, a ...interface{}) {
+	fmt.Printf(time.Now().Format("15:04:05")+" "+format+"\n\n", a...)
+}
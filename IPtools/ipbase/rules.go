@@ -0,0 +1,418 @@
+package ipbase
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+/*
+Rules make ipserver answer like a device. Each rule starts with what it reacts to, followed by what it does:
+
+	# A line of text (without the line ending) matching a regular expression. The rest of the line is the expression,
+	# up to a # after white space, which starts a comment. Write [#] for such a # in the expression
+	on text ^GET (\d+)$
+	  send "VAL $1 42\r\n"     # Quoted text, hex bytes and $0-$9: The match and its groups
+	# A read (or datagram) matching a hex mask. ? is any hex digit, a * at the end allows more bytes. $1-$9 are the bytes with a ?
+	on hex 30 ?? 0? *
+	  delay 100ms              # Delays hold further answers on the connection
+	  send 31 $1 00
+	# A TCP client connecting
+	on connect
+	  send "WELCOME\r\n"
+	# Unsolicited messages, to all TCP connections or UDP peers heard from
+	every 5s
+	  send "TALLY 1 ON\r\n"
+
+The first rule matching is used. Quoted text takes the escapes \r, \n, \t, \xNN, \" and \\, and $$ for a $.
+With -udp, all datagrams are read in one loop, so a delay holds the answers to every UDP peer, not just the one it answers.
+*/
+
+// textComment is a comment after the expression of an "on text" rule
+var textComment = regexp.MustCompile(`(^|\s)#.*$`)
+
+// maxLineLength is where text received without a line ending is dropped, as it's probably binary
+const maxLineLength = 65536
+
+type ruleAction struct {
+	parts []responsePart
+	delay time.Duration
+}
+
+// responsePart is either literal bytes or a reference to a captured group
+type responsePart struct {
+	bytes []byte
+	group int // -1 for the bytes
+}
+
+// maskByte matches a received byte b if b&mask == value
+type maskByte struct {
+	value byte
+	mask  byte
+}
+
+type rule struct {
+	line    int
+	text    *regexp.Regexp
+	hex     []maskByte
+	more    bool // The hex mask allows more bytes
+	connect bool
+	period  time.Duration
+	groups  int // Highest group that can be referenced
+	actions []ruleAction
+}
+
+// Rules is a parsed rules file
+type Rules struct {
+	rules  []*rule
+	byText bool // There are text rules, so lines are collected
+}
+
+// LoadRules reads a rules file
+func LoadRules(filename string) (*Rules, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	rules, err := ParseRules(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return rules, nil
+}
+
+// ParseRules reads rules from r
+func ParseRules(r io.Reader) (*Rules, error) {
+	rules := &Rules{}
+
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		var err error
+		switch fields[0] {
+		case "on", "every":
+			var newRule *rule
+			newRule, err = parseTrigger(line, fields)
+			if err == nil {
+				newRule.line = lineNumber
+				rules.rules = append(rules.rules, newRule)
+				rules.byText = rules.byText || newRule.text != nil
+			}
+		case "send", "delay":
+			if len(rules.rules) == 0 {
+				err = fmt.Errorf("%s before the first rule", fields[0])
+				break
+			}
+			var action ruleAction
+			action, err = parseAction(line, rules.rules[len(rules.rules)-1])
+			if err == nil {
+				rules.rules[len(rules.rules)-1].actions = append(rules.rules[len(rules.rules)-1].actions, action)
+			}
+		default:
+			err = fmt.Errorf("unknown statement %q", fields[0])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+func parseTrigger(line string, fields []string) (*rule, error) {
+	newRule := &rule{groups: -1}
+	if fields[0] == "every" {
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("expected every <period>, like 5s")
+		}
+		period, err := time.ParseDuration(fields[1])
+		if err != nil || period <= 0 {
+			return nil, fmt.Errorf("bad period %q", fields[1])
+		}
+		newRule.period = period
+		return newRule, nil
+	}
+
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("expected on text|hex|connect")
+	}
+	switch fields[1] {
+	case "connect":
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("expected on connect")
+		}
+		newRule.connect = true
+	case "text":
+		expression := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(strings.TrimPrefix(line, "on")), "text"))
+		expression = strings.TrimSpace(textComment.ReplaceAllString(expression, ""))
+		if expression == "" {
+			return nil, fmt.Errorf("expected on text <regular expression>")
+		}
+		text, err := regexp.Compile(expression)
+		if err != nil {
+			return nil, err
+		}
+		newRule.text = text
+		newRule.groups = text.NumSubexp()
+	case "hex":
+		tokens, err := tokenize(line)
+		if err != nil {
+			return nil, err
+		}
+		mask := ""
+		for _, t := range tokens[2:] {
+			mask += t.text
+		}
+		if strings.HasSuffix(mask, "*") {
+			newRule.more = true
+			mask = mask[:len(mask)-1]
+		}
+		if mask == "" || len(mask)%2 != 0 {
+			return nil, fmt.Errorf("expected on hex <mask> with two digits per byte")
+		}
+		newRule.groups = 0
+		for i := 0; i < len(mask); i += 2 {
+			b, err := parseMaskByte(mask[i : i+2])
+			if err != nil {
+				return nil, err
+			}
+			if b.mask != 0xFF {
+				newRule.groups++
+			}
+			newRule.hex = append(newRule.hex, b)
+		}
+	default:
+		return nil, fmt.Errorf("unknown trigger %q, expected text, hex or connect", fields[1])
+	}
+	return newRule, nil
+}
+
+func parseMaskByte(digits string) (maskByte, error) {
+	b := maskByte{}
+	for i, digit := range digits {
+		shift := 4 * (1 - i)
+		if digit == '?' {
+			continue
+		}
+		value, err := hex.DecodeString("0" + string(digit))
+		if err != nil {
+			return b, fmt.Errorf("bad hex mask %q", digits)
+		}
+		b.value |= value[0] << shift
+		b.mask |= 0x0F << shift
+	}
+	return b, nil
+}
+
+func parseAction(line string, owner *rule) (ruleAction, error) {
+	tokens, err := tokenize(line)
+	if err != nil {
+		return ruleAction{}, err
+	}
+	args := tokens[1:]
+
+	if tokens[0].text == "delay" {
+		if len(args) != 1 || args[0].quoted {
+			return ruleAction{}, fmt.Errorf("expected delay <duration>, like 100ms")
+		}
+		delay, err := time.ParseDuration(args[0].text)
+		if err != nil || delay < 0 {
+			return ruleAction{}, fmt.Errorf("bad duration %q", args[0].text)
+		}
+		return ruleAction{delay: delay}, nil
+	}
+
+	if len(args) == 0 {
+		return ruleAction{}, fmt.Errorf("nothing to send")
+	}
+	parts := []responsePart{}
+	addGroup := func(digit byte) error {
+		group := int(digit - '0')
+		if group > owner.groups {
+			return fmt.Errorf("no group $%d in this rule", group)
+		}
+		parts = append(parts, responsePart{group: group})
+		return nil
+	}
+	for _, t := range args {
+		if !t.quoted {
+			if len(t.text) == 2 && t.text[0] == '$' && t.text[1] >= '0' && t.text[1] <= '9' {
+				if err := addGroup(t.text[1]); err != nil {
+					return ruleAction{}, err
+				}
+				continue
+			}
+			bytes, err := hex.DecodeString(t.text)
+			if err != nil {
+				return ruleAction{}, fmt.Errorf("bad hex %q", t.text)
+			}
+			parts = append(parts, responsePart{bytes: bytes, group: -1})
+			continue
+		}
+
+		literal := []byte{}
+		for i := 0; i < len(t.text); i++ {
+			if t.text[i] == '$' && i+1 < len(t.text) && t.text[i+1] == '$' {
+				literal = append(literal, '$')
+				i++
+			} else if t.text[i] == '$' && i+1 < len(t.text) && t.text[i+1] >= '0' && t.text[i+1] <= '9' {
+				parts = append(parts, responsePart{bytes: literal, group: -1})
+				literal = []byte{}
+				if err := addGroup(t.text[i+1]); err != nil {
+					return ruleAction{}, err
+				}
+				i++
+			} else {
+				literal = append(literal, t.text[i])
+			}
+		}
+		parts = append(parts, responsePart{bytes: literal, group: -1})
+	}
+	return ruleAction{parts: parts}, nil
+}
+
+// matchHex returns the whole data and the bytes matched by wildcards, or nil
+func (r *rule) matchHex(data []byte) [][]byte {
+	if len(data) < len(r.hex) || (!r.more && len(data) != len(r.hex)) {
+		return nil
+	}
+	groups := [][]byte{data}
+	for i, b := range r.hex {
+		if data[i]&b.mask != b.value {
+			return nil
+		}
+		if b.mask != 0xFF {
+			groups = append(groups, data[i:i+1])
+		}
+	}
+	return groups
+}
+
+// run sends the answers of the rule with write, filled in with groups
+func (r *rule) run(groups [][]byte, write func([]byte) error) {
+	for _, action := range r.actions {
+		if action.parts == nil {
+			time.Sleep(action.delay)
+			continue
+		}
+
+		answer := []byte{}
+		for _, part := range action.parts {
+			if part.group >= 0 {
+				answer = append(answer, groups[part.group]...)
+			} else {
+				answer = append(answer, part.bytes...)
+			}
+		}
+		PrintoutBytes(answer, len(answer), 16, "RULE: ")
+		if err := write(answer); err != nil {
+			fmt.Println(err)
+			fmt.Println("")
+			return
+		}
+	}
+}
+
+// StartPeriodic starts sending the messages of the "every" rules with write. A nil *Rules does nothing.
+func (rules *Rules) StartPeriodic(write func([]byte) error) {
+	if rules == nil {
+		return
+	}
+	for _, r := range rules.rules {
+		if r.period == 0 {
+			continue
+		}
+		go func(r *rule) {
+			ticker := time.NewTicker(r.period)
+			defer ticker.Stop()
+			for range ticker.C {
+				r.run(nil, write)
+			}
+		}(r)
+	}
+}
+
+// Responder applies rules to what is received on one connection. A nil *Responder does nothing.
+type Responder struct {
+	rules *Rules
+	line  []byte // Text received after the last line ending
+}
+
+// NewResponder returns a responder for a new connection, or nil for nil rules
+func (rules *Rules) NewResponder() *Responder {
+	if rules == nil {
+		return nil
+	}
+	return &Responder{rules: rules}
+}
+
+// Connected runs the first "on connect" rule
+func (r *Responder) Connected(write func([]byte) error) {
+	if r == nil {
+		return
+	}
+	for _, rule := range r.rules.rules {
+		if rule.connect {
+			rule.run(nil, write)
+			return
+		}
+	}
+}
+
+// Received applies the rules to data and sends the answers with write. Hex rules are matched against data, text rules against each line completed by it.
+func (r *Responder) Received(data []byte, write func([]byte) error) {
+	if r == nil {
+		return
+	}
+	for _, rule := range r.rules.rules {
+		if rule.hex == nil {
+			continue
+		}
+		if groups := rule.matchHex(data); groups != nil {
+			rule.run(groups, write)
+			return
+		}
+	}
+	if !r.rules.byText {
+		return
+	}
+
+	r.line = append(r.line, data...)
+	for {
+		end := bytes.IndexByte(r.line, '\n')
+		if end < 0 {
+			break
+		}
+		line := bytes.TrimSuffix(r.line[:end], []byte("\r"))
+		r.line = r.line[end+1:]
+		for _, rule := range r.rules.rules {
+			if rule.text == nil {
+				continue
+			}
+			if groups := rule.text.FindSubmatch(line); groups != nil {
+				rule.run(groups, write)
+				break
+			}
+		}
+	}
+	if len(r.line) > maxLineLength {
+		r.line = nil
+	}
+}
@@ -0,0 +1,123 @@
+package ipbase
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// respond feeds reads to a responder for rules and returns the answers written
+func respond(t *testing.T, rules string, reads ...string) []string {
+	t.Helper()
+	parsed, err := ParseRules(strings.NewReader(rules))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	answers := []string{}
+	write := func(answer []byte) error {
+		answers = append(answers, string(answer))
+		return nil
+	}
+	responder := parsed.NewResponder()
+	for _, read := range reads {
+		responder.Received([]byte(read), write)
+	}
+	return answers
+}
+
+func TestRulesText(t *testing.T) {
+	rules := `
+		on text ^GET (\d+) (\w+)$   # Comment
+		  send "VAL $1=$2 $$5\r\n"
+		on text ^PING$
+		  send "PONG\n"
+		on text ^NOTE [#](\d)$
+		  send "#$1"
+	`
+	answers := respond(t, rules, "GET 12 gain\r\nPI", "NG\nHELLO\n", "NOTE #3\n")
+	expected := []string{"VAL 12=gain $5\r\n", "PONG\n", "#3"}
+	if strings.Join(answers, "|") != strings.Join(expected, "|") {
+		t.Errorf("expected %q, got %q", expected, answers)
+	}
+}
+
+func TestRulesHex(t *testing.T) {
+	rules := `
+		on hex 30 ?? 0? *
+		  send 31 $1 $2 "$0"
+		on hex 40
+		  send 41
+	`
+	tests := []struct {
+		read   []byte
+		answer []byte
+	}{
+		{[]byte{0x30, 0xAB, 0x05}, []byte{0x31, 0xAB, 0x05, 0x30, 0xAB, 0x05}},
+		{[]byte{0x30, 0xAB, 0x05, 0xFF}, []byte{0x31, 0xAB, 0x05, 0x30, 0xAB, 0x05, 0xFF}},
+		{[]byte{0x30, 0xAB, 0x15}, nil}, // High digit of the third byte must be 0
+		{[]byte{0x30, 0xAB}, nil},       // Too short
+		{[]byte{0x40}, []byte{0x41}},
+		{[]byte{0x40, 0x00}, nil}, // No * in the mask
+	}
+	for _, test := range tests {
+		answers := respond(t, rules, string(test.read))
+		if test.answer == nil && len(answers) != 0 || test.answer != nil && (len(answers) != 1 || !bytes.Equal([]byte(answers[0]), test.answer)) {
+			t.Errorf("%X: expected %X, got %X", test.read, test.answer, answers)
+		}
+	}
+}
+
+func TestMatchHexGroups(t *testing.T) {
+	r := &rule{}
+	for _, digits := range []string{"30", "??", "0?", "?F"} {
+		b, err := parseMaskByte(digits)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.hex = append(r.hex, b)
+	}
+	groups := r.matchHex([]byte{0x30, 0x12, 0x03, 0x4F})
+	if len(groups) != 4 || groups[1][0] != 0x12 || groups[2][0] != 0x03 || groups[3][0] != 0x4F {
+		t.Errorf("unexpected groups %X", groups)
+	}
+	if groups := r.matchHex([]byte{0x30, 0x12, 0x03, 0x4E}); groups != nil {
+		t.Errorf("expected no match, got %X", groups)
+	}
+}
+
+func TestRulesLongLineDropped(t *testing.T) {
+	parsed, err := ParseRules(strings.NewReader("on text ^A$\n  send 01"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	responder := parsed.NewResponder()
+	responder.Received(bytes.Repeat([]byte{'x'}, maxLineLength+1), func([]byte) error { return nil })
+	if len(responder.line) != 0 {
+		t.Errorf("expected the line to be dropped, %d bytes kept", len(responder.line))
+	}
+}
+
+func TestParseRulesErrors(t *testing.T) {
+	for _, rules := range []string{
+		"send 01",
+		"on text",
+		"on text # Only a comment",
+		"on text ^(unclosed$",
+		"on hex 3",
+		"on hex 3G",
+		"on hex 30\n  send $1",
+		"on text ^(a)$\n  send \"$2\"",
+		"on connect now",
+		"on disconnect",
+		"every soon",
+		"every 0s",
+		"on connect\n  delay later",
+		"on connect\n  send",
+		"reply 01",
+	} {
+		if _, err := ParseRules(strings.NewReader(rules)); err == nil {
+			t.Errorf("expected an error for %q", rules)
+		}
+	}
+}
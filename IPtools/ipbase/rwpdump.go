@@ -0,0 +1,103 @@
+package ipbase
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	"rwptransport"
+)
+
+// RWPDecoder reassembles Raw Panel binary frames (4-byte little endian length header + protobuf payload) from a byte stream in one direction,
+// and decodes them as messages to a panel (rwp.InboundMessage) or from a panel (rwp.OutboundMessage).
+// A nil *RWPDecoder does nothing, so callers don't need to check if decoding is enabled.
+type RWPDecoder struct {
+	toPanel bool
+	buffer  []byte
+}
+
+// NewRWPDecoder returns a decoder for the bytes received from peer ("panel" or "system"), or sent to it if sent is true.
+// Nil is returned for an empty peer.
+func NewRWPDecoder(peer string, sent bool) *RWPDecoder {
+	if peer == "" {
+		return nil
+	}
+	return &RWPDecoder{toPanel: (peer == "panel") == sent}
+}
+
+// Decode adds bytes from the stream and returns a description of each frame completed by them.
+// A header announcing more than rwptransport.MaxPayloadLength means we are out of sync (or the peer talks ASCII), so the buffered bytes are dropped.
+func (d *RWPDecoder) Decode(data []byte) []string {
+	d.buffer = append(d.buffer, data...)
+
+	lines := []string{}
+	for len(d.buffer) >= 4 {
+		length := binary.LittleEndian.Uint32(d.buffer)
+		if length >= rwptransport.MaxPayloadLength {
+			lines = append(lines, fmt.Sprintf("Oversize frame: header %02X announces %d bytes%s, %d bytes dropped", d.buffer[:4], length, QStr(printable(d.buffer[:4]), " (looks like ASCII)", ""), len(d.buffer)))
+			d.buffer = nil
+			break
+		}
+		if len(d.buffer) < 4+int(length) {
+			break // Waiting for the rest of the payload
+		}
+		lines = append(lines, d.describe(d.buffer[4:4+length]))
+		d.buffer = d.buffer[4+length:]
+	}
+	if len(d.buffer) == 0 {
+		d.buffer = nil // Releases the backing array between frames
+	}
+	return lines
+}
+
+// Pending returns the number of bytes buffered for a frame that is not complete yet
+func (d *RWPDecoder) Pending() int {
+	return len(d.buffer)
+}
+
+// Print decodes data and prints the frames completed by it, to go beneath the hex dump from PrintoutBytes
+func (d *RWPDecoder) Print(data []byte) {
+	if d == nil {
+		return
+	}
+	lines := d.Decode(data)
+	if pending := d.Pending(); pending > 0 {
+		lines = append(lines, fmt.Sprintf("(%d bytes of an incomplete frame buffered)", pending))
+	}
+	for _, line := range lines {
+		fmt.Println("RWP:  " + line)
+	}
+	if len(lines) > 0 {
+		fmt.Println()
+	}
+}
+
+func (d *RWPDecoder) describe(payload []byte) string {
+	var msg proto.Message = &rwp.OutboundMessage{}
+	if d.toPanel {
+		msg = &rwp.InboundMessage{}
+	}
+	name := string(msg.ProtoReflect().Descriptor().Name())
+
+	if err := proto.Unmarshal(payload, msg); err != nil {
+		return fmt.Sprintf("Malformed %s (%d bytes): %v", name, len(payload), err)
+	}
+	jsonData, err := protojson.Marshal(msg)
+	if err != nil {
+		return fmt.Sprintf("%s (%d bytes): %v", name, len(payload), err)
+	}
+	return fmt.Sprintf("%s (%d bytes): %s", name, len(payload), jsonData)
+}
+
+func printable(data []byte) bool {
+	for _, b := range data {
+		if (b < 0x20 || b > 0x7E) && b != '\n' && b != '\r' && b != '\t' {
+			return false
+		}
+	}
+	return true
+}
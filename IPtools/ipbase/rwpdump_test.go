@@ -0,0 +1,89 @@
+package ipbase
+
+import (
+	"strings"
+	"testing"
+
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+
+	"google.golang.org/protobuf/proto"
+
+	"rwptransport"
+)
+
+func TestNewRWPDecoder(t *testing.T) {
+	if NewRWPDecoder("", false) != nil {
+		t.Error("expected no decoder without a peer")
+	}
+	for _, test := range []struct {
+		peer    string
+		sent    bool
+		toPanel bool
+	}{
+		{"panel", true, true},
+		{"panel", false, false},
+		{"system", true, false},
+		{"system", false, true},
+	} {
+		if d := NewRWPDecoder(test.peer, test.sent); d.toPanel != test.toPanel {
+			t.Errorf("%s, sent=%v: toPanel is %v", test.peer, test.sent, d.toPanel)
+		}
+	}
+}
+
+func TestRWPDecoderReassembly(t *testing.T) {
+	pbdata, _ := proto.Marshal(&rwp.OutboundMessage{Events: []*rwp.HWCEvent{{HWCID: 7}}})
+	frame := rwptransport.EncodeFrame(pbdata)
+	stream := append(append([]byte{}, frame...), frame...)
+
+	d := NewRWPDecoder("panel", false)
+	var lines []string
+	for _, chunk := range [][]byte{stream[:2], stream[2:5], stream[5 : len(frame)+1], stream[len(frame)+1:]} { // Split in the header, the payload and between frames
+		lines = append(lines, d.Decode(chunk)...)
+	}
+	if len(lines) != 2 {
+		t.Fatalf("expected two frames, got %q", lines)
+	}
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "OutboundMessage (") || !strings.Contains(line, "7") {
+			t.Errorf("unexpected description %q", line)
+		}
+	}
+	if d.Pending() != 0 {
+		t.Errorf("%d bytes left pending", d.Pending())
+	}
+
+	if lines := d.Decode(frame[:len(frame)-1]); len(lines) != 0 || d.Pending() != len(frame)-1 {
+		t.Errorf("expected an incomplete frame, got %q with %d bytes pending", lines, d.Pending())
+	}
+}
+
+func TestRWPDecoderOversize(t *testing.T) {
+	d := NewRWPDecoder("system", false)
+	lines := d.Decode([]byte("list\n"))
+	if len(lines) != 1 || !strings.HasPrefix(lines[0], "Oversize frame") || !strings.Contains(lines[0], "looks like ASCII") || !strings.Contains(lines[0], "5 bytes dropped") {
+		t.Fatalf("unexpected lines %q", lines)
+	}
+	if d.Pending() != 0 {
+		t.Errorf("%d bytes left pending", d.Pending())
+	}
+
+	lines = d.Decode([]byte{0xFF, 0xFF, 0xFF, 0xFF, 1})
+	if len(lines) != 1 || strings.Contains(lines[0], "ASCII") {
+		t.Errorf("unexpected lines %q", lines)
+	}
+
+	// In sync again afterwards:
+	pbdata, _ := proto.Marshal(&rwp.InboundMessage{Command: &rwp.Command{ActivatePanel: true}})
+	if lines = d.Decode(rwptransport.EncodeFrame(pbdata)); len(lines) != 1 || !strings.HasPrefix(lines[0], "InboundMessage (") {
+		t.Errorf("unexpected lines %q", lines)
+	}
+}
+
+func TestRWPDecoderMalformed(t *testing.T) {
+	d := NewRWPDecoder("panel", true)
+	lines := d.Decode(rwptransport.EncodeFrame([]byte{0xFF, 0xFF}))
+	if len(lines) != 1 || !strings.HasPrefix(lines[0], "Malformed InboundMessage (2 bytes)") {
+		t.Errorf("unexpected lines %q", lines)
+	}
+}
@@ -0,0 +1,472 @@
+package ipbase
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+Scripts send packets on a connection, one statement per line:
+
+	# Comments start with #
+	length u8             # Length prefix before each packet: none (default), u8, u16le or u16be. It counts the payload and the checksum
+	checksum sum8         # Checksum after each packet: none (default), sum8, xor, crc16, crc16ccitt or crc32
+	set addr 01           # Variable holding bytes, given like a packet
+	loop 10               # Repeats the statements up to "end" 10 times, or forever without a count (then it needs a delay)
+	  send 30 {addr} 0000 "AB\r" FF   # Hex bytes (with or without spaces), variables and quoted text with \r, \n, \t, \xNN, \" and \\
+	  inc addr            # Counts a variable up as a big endian number, wrapping around. By 1 or the step given
+	  delay 20ms
+	end
+
+The checksum covers the length prefix and the payload, or the payload only with "checksum <algorithm> payload".
+sum8 and xor are one byte. crc16 is CRC-16/Modbus, appended low byte first. crc16ccitt (polynomial 0x1021, initial 0xFFFF)
+and crc32 (IEEE) are appended high byte first.
+*/
+
+var lengthSizes = map[string]int{"none": 0, "u8": 1, "u16le": 2, "u16be": 2}
+
+var checksumSizes = map[string]int{"none": 0, "sum8": 1, "xor": 1, "crc16": 2, "crc16ccitt": 2, "crc32": 4}
+
+type statementKind int
+
+const (
+	sendStatement statementKind = iota
+	setStatement
+	incStatement
+	delayStatement
+	lengthStatement
+	checksumStatement
+	loopStatement
+)
+
+type statement struct {
+	line        int
+	kind        statementKind
+	name        string       // Variable for set and inc, style for length and checksum
+	parts       []packetPart // Bytes for send and set
+	step        uint64
+	delay       time.Duration
+	count       int  // Loop: Zero repeats forever
+	payloadOnly bool // Checksum: The length prefix is not covered
+	body        []*statement
+}
+
+// packetPart is either literal bytes or the name of a variable
+type packetPart struct {
+	bytes    []byte
+	variable string
+}
+
+type token struct {
+	text   string
+	quoted bool
+}
+
+// Script is a parsed script file
+type Script struct {
+	statements []*statement
+}
+
+// LoadScript reads a script file
+func LoadScript(filename string) (*Script, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	script, err := ParseScript(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return script, nil
+}
+
+// ParseScript reads script statements from r
+func ParseScript(r io.Reader) (*Script, error) {
+	stack := []*statement{{kind: loopStatement, count: 1}} // Open loops, the script itself at the bottom
+	variables := make(map[string]bool)
+
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		tokens, err := tokenize(scanner.Text())
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+		if len(tokens) == 0 {
+			continue
+		}
+
+		if tokens[0].text == "end" && !tokens[0].quoted {
+			if len(stack) == 1 {
+				return nil, fmt.Errorf("line %d: end without loop", lineNumber)
+			}
+			loop := stack[len(stack)-1]
+			if loop.count == 0 && !hasDelay(loop.body) {
+				return nil, fmt.Errorf("line %d: loop without count or any delay would flood the peer", loop.line)
+			}
+			stack = stack[:len(stack)-1]
+			stack[len(stack)-1].body = append(stack[len(stack)-1].body, loop)
+			continue
+		}
+
+		stmt, err := parseStatement(tokens, variables)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+		stmt.line = lineNumber
+		if stmt.kind == loopStatement {
+			stack = append(stack, stmt)
+		} else {
+			stack[len(stack)-1].body = append(stack[len(stack)-1].body, stmt)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(stack) > 1 {
+		return nil, fmt.Errorf("line %d: loop has no end", stack[len(stack)-1].line)
+	}
+	return &Script{statements: stack[0].body}, nil
+}
+
+// hasDelay tells if statements (or loops in them) wait at some point
+func hasDelay(statements []*statement) bool {
+	for _, stmt := range statements {
+		if (stmt.kind == delayStatement && stmt.delay > 0) || (stmt.kind == loopStatement && hasDelay(stmt.body)) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseStatement(tokens []token, variables map[string]bool) (*statement, error) {
+	keyword, args := tokens[0].text, tokens[1:]
+	for _, arg := range args {
+		if arg.quoted && keyword != "send" && keyword != "set" {
+			return nil, fmt.Errorf("%s doesn't take text", keyword)
+		}
+	}
+
+	var err error
+	stmt := &statement{}
+	switch keyword {
+	case "send":
+		stmt.kind = sendStatement
+		if len(args) == 0 {
+			return nil, fmt.Errorf("nothing to send")
+		}
+		stmt.parts, err = parseParts(args, variables)
+	case "set":
+		stmt.kind = setStatement
+		if len(args) < 2 {
+			return nil, fmt.Errorf("expected set <variable> <bytes>")
+		}
+		stmt.name = args[0].text
+		stmt.parts, err = parseParts(args[1:], variables)
+		variables[stmt.name] = true
+	case "inc":
+		stmt.kind = incStatement
+		stmt.step = 1
+		if len(args) < 1 || len(args) > 2 {
+			return nil, fmt.Errorf("expected inc <variable> [step]")
+		}
+		stmt.name = args[0].text
+		if !variables[stmt.name] {
+			return nil, fmt.Errorf("unknown variable %q", stmt.name)
+		}
+		if len(args) == 2 {
+			if stmt.step, err = strconv.ParseUint(args[1].text, 0, 64); err != nil {
+				return nil, fmt.Errorf("bad step %q", args[1].text)
+			}
+		}
+	case "delay":
+		stmt.kind = delayStatement
+		if len(args) != 1 {
+			return nil, fmt.Errorf("expected delay <duration>, like 20ms")
+		}
+		if stmt.delay, err = time.ParseDuration(args[0].text); err != nil || stmt.delay < 0 {
+			return nil, fmt.Errorf("bad duration %q", args[0].text)
+		}
+	case "length":
+		stmt.kind = lengthStatement
+		if len(args) != 1 {
+			return nil, fmt.Errorf("expected length none|u8|u16le|u16be")
+		}
+		stmt.name = args[0].text
+		if _, known := lengthSizes[stmt.name]; !known {
+			return nil, fmt.Errorf("unknown length prefix %q, expected none, u8, u16le or u16be", stmt.name)
+		}
+	case "checksum":
+		stmt.kind = checksumStatement
+		if len(args) < 1 || len(args) > 2 || (len(args) == 2 && args[1].text != "payload") {
+			return nil, fmt.Errorf("expected checksum <algorithm> [payload]")
+		}
+		stmt.name = args[0].text
+		stmt.payloadOnly = len(args) == 2
+		if _, known := checksumSizes[stmt.name]; !known {
+			return nil, fmt.Errorf("unknown checksum %q, expected none, sum8, xor, crc16, crc16ccitt or crc32", stmt.name)
+		}
+	case "loop":
+		stmt.kind = loopStatement
+		if len(args) > 1 {
+			return nil, fmt.Errorf("expected loop [count]")
+		}
+		if len(args) == 1 {
+			if stmt.count, err = strconv.Atoi(args[0].text); err != nil || stmt.count <= 0 {
+				return nil, fmt.Errorf("bad loop count %q", args[0].text)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown statement %q", keyword)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return stmt, nil
+}
+
+// tokenize splits a line at white space and drops the comment. Quoted text may contain spaces and the escapes \r, \n, \t, \xNN, \" and \\.
+func tokenize(line string) ([]token, error) {
+	tokens := []token{}
+	for i := 0; i < len(line); {
+		switch line[i] {
+		case ' ', '\t', '\r':
+			i++
+		case '#':
+			return tokens, nil
+		case '"':
+			text := []byte{}
+			for i++; ; i++ {
+				if i >= len(line) {
+					return nil, fmt.Errorf("missing closing quote")
+				}
+				if line[i] == '"' {
+					i++
+					break
+				}
+				if line[i] != '\\' {
+					text = append(text, line[i])
+					continue
+				}
+				decoded, next, err := escape(line, i)
+				if err != nil {
+					return nil, err
+				}
+				text = append(text, decoded...)
+				i = next - 1
+			}
+			tokens = append(tokens, token{text: string(text), quoted: true})
+		default:
+			start := i
+			for i < len(line) && !strings.ContainsRune(" \t\r#\"", rune(line[i])) {
+				i++
+			}
+			tokens = append(tokens, token{text: line[start:i]})
+		}
+	}
+	return tokens, nil
+}
+
+// parseParts reads hex bytes, {variable} references (also inside hex, like 30{addr}00) and quoted text
+func parseParts(tokens []token, variables map[string]bool) ([]packetPart, error) {
+	parts := []packetPart{}
+	for _, t := range tokens {
+		if t.quoted {
+			parts = append(parts, packetPart{bytes: []byte(t.text)})
+			continue
+		}
+		for text := t.text; text != ""; {
+			open := strings.Index(text, "{")
+			hexText := text
+			if open >= 0 {
+				hexText = text[:open]
+			}
+			if hexText != "" {
+				bytes, err := hex.DecodeString(hexText)
+				if err != nil {
+					return nil, fmt.Errorf("bad hex %q", hexText)
+				}
+				parts = append(parts, packetPart{bytes: bytes})
+			}
+			if open < 0 {
+				break
+			}
+
+			length := strings.Index(text[open:], "}")
+			if length < 0 {
+				return nil, fmt.Errorf("missing } in %q", t.text)
+			}
+			name := text[open+1 : open+length]
+			if !variables[name] {
+				return nil, fmt.Errorf("unknown variable %q", name)
+			}
+			parts = append(parts, packetPart{variable: name})
+			text = text[open+length+1:]
+		}
+	}
+	return parts, nil
+}
+
+// scriptRun is the state of a script while it runs
+type scriptRun struct {
+	connection  io.Writer
+	rwpDecoder  *RWPDecoder
+	variables   map[string][]byte
+	length      string
+	checksum    string
+	payloadOnly bool
+}
+
+// Run sends the packets of the script on connection and prints them. Raw Panel frames are decoded too if rwpDecoder is given.
+// It returns when the script ends or a write fails.
+func (s *Script) Run(connection io.Writer, rwpDecoder *RWPDecoder) error {
+	run := &scriptRun{
+		connection: connection,
+		rwpDecoder: rwpDecoder,
+		variables:  make(map[string][]byte),
+		length:     "none",
+		checksum:   "none",
+	}
+	return run.exec(s.statements)
+}
+
+func (run *scriptRun) exec(statements []*statement) error {
+	for _, stmt := range statements {
+		switch stmt.kind {
+		case sendStatement:
+			packet := run.packet(run.resolve(stmt.parts))
+			PrintoutBytes(packet, len(packet), 16, "SENT: ")
+			run.rwpDecoder.Print(packet)
+			if _, err := run.connection.Write(packet); err != nil {
+				return fmt.Errorf("line %d: %w", stmt.line, err)
+			}
+		case setStatement:
+			run.variables[stmt.name] = run.resolve(stmt.parts)
+		case incStatement:
+			run.variables[stmt.name] = increment(run.variables[stmt.name], stmt.step)
+		case delayStatement:
+			time.Sleep(stmt.delay)
+		case lengthStatement:
+			run.length = stmt.name
+		case checksumStatement:
+			run.checksum = stmt.name
+			run.payloadOnly = stmt.payloadOnly
+		case loopStatement:
+			for i := 0; stmt.count == 0 || i < stmt.count; i++ {
+				if err := run.exec(stmt.body); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// resolve joins the parts with the current values of variables
+func (run *scriptRun) resolve(parts []packetPart) []byte {
+	bytes := []byte{}
+	for _, part := range parts {
+		if part.variable != "" {
+			bytes = append(bytes, run.variables[part.variable]...)
+		} else {
+			bytes = append(bytes, part.bytes...)
+		}
+	}
+	return bytes
+}
+
+// packet adds the length prefix and checksum to payload
+func (run *scriptRun) packet(payload []byte) []byte {
+	size := len(payload) + checksumSizes[run.checksum]
+
+	packet := []byte{}
+	switch run.length {
+	case "u8":
+		packet = append(packet, byte(size))
+	case "u16le":
+		packet = binary.LittleEndian.AppendUint16(packet, uint16(size))
+	case "u16be":
+		packet = binary.BigEndian.AppendUint16(packet, uint16(size))
+	}
+	start := len(packet)
+	if !run.payloadOnly {
+		start = 0
+	}
+	packet = append(packet, payload...)
+
+	data := packet[start:]
+	switch run.checksum {
+	case "sum8":
+		sum := byte(0)
+		for _, b := range data {
+			sum += b
+		}
+		packet = append(packet, sum)
+	case "xor":
+		xor := byte(0)
+		for _, b := range data {
+			xor ^= b
+		}
+		packet = append(packet, xor)
+	case "crc16":
+		packet = binary.LittleEndian.AppendUint16(packet, crc16Modbus(data))
+	case "crc16ccitt":
+		packet = binary.BigEndian.AppendUint16(packet, crc16CCITT(data))
+	case "crc32":
+		packet = binary.BigEndian.AppendUint32(packet, crc32.ChecksumIEEE(data))
+	}
+	return packet
+}
+
+func crc16Modbus(data []byte) uint16 {
+	crc := uint16(0xFFFF)
+	for _, b := range data {
+		crc ^= uint16(b)
+		for i := 0; i < 8; i++ {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0xA001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return crc
+}
+
+func crc16CCITT(data []byte) uint16 {
+	crc := uint16(0xFFFF)
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// increment adds step to value as a big endian number of the same size, wrapping around
+func increment(value []byte, step uint64) []byte {
+	value = append([]byte(nil), value...)
+	carry := step
+	for i := len(value) - 1; i >= 0 && carry > 0; i-- {
+		sum := uint64(value[i]) + carry
+		value[i] = byte(sum)
+		carry = sum >> 8
+	}
+	return value
+}
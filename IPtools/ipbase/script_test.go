@@ -0,0 +1,146 @@
+package ipbase
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		line   string
+		tokens []token
+	}{
+		{"", []token{}},
+		{"  # Only a comment", []token{}},
+		{"send 30 {addr}\t00 # Comment", []token{{text: "send"}, {text: "30"}, {text: "{addr}"}, {text: "00"}}},
+		{`send "A B"FF`, []token{{text: "send"}, {text: "A B", quoted: true}, {text: "FF"}}},
+		{`send "say \"#1\" \\"`, []token{{text: "send"}, {text: `say "#1" \`, quoted: true}}},
+		{"delay 20ms#no space", []token{{text: "delay"}, {text: "20ms"}}},
+	}
+	for _, test := range tests {
+		tokens, err := tokenize(test.line)
+		if err != nil || !reflect.DeepEqual(tokens, test.tokens) {
+			t.Errorf("%q: got %v, %v", test.line, tokens, err)
+		}
+	}
+
+	if _, err := tokenize(`send "open`); err == nil {
+		t.Error("expected an error for a missing quote")
+	}
+}
+
+func TestParseScriptErrors(t *testing.T) {
+	tests := []struct {
+		script string
+		line   string // Expected in the error
+	}{
+		{"loop\n  send 01\nend", "line 1"},                    // Would flood the peer
+		{"loop\n  loop 2\n    send 01\n  end\nend", "line 1"}, // No delay in the nested loop either
+		{"loop\n  delay 0s\n  send 01\nend", "line 1"},
+		{"send 01\nend", "line 2"},
+		{"loop 3\nsend 01", "line 1"},
+		{"loop 0\nend", "line 1"},
+		{"inc addr", "line 1"},
+		{"send {addr}", "line 1"},
+		{"send 0", "line 1"},
+		{"set addr\n", "line 1"},
+		{"delay soon", "line 1"},
+		{"length u32", "line 1"},
+		{"checksum md5", "line 1"},
+		{"checksum sum8 all", "line 1"},
+		{`delay "1s"`, "line 1"},
+		{"jump 1", "line 1"},
+	}
+	for _, test := range tests {
+		_, err := ParseScript(strings.NewReader(test.script))
+		if err == nil || !strings.Contains(err.Error(), test.line) {
+			t.Errorf("%q: expected an error on %s, got %v", test.script, test.line, err)
+		}
+	}
+
+	valid := "loop\n  loop 2\n    send 01\n  end\n  delay 10ms\nend"
+	if _, err := ParseScript(strings.NewReader(valid)); err != nil {
+		t.Errorf("a delay after a nested loop is enough: %v", err)
+	}
+}
+
+// runScript runs script and returns the packets it sent
+func runScript(t *testing.T, script string) [][]byte {
+	t.Helper()
+	parsed, err := ParseScript(strings.NewReader(script))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, server := net.Pipe()
+	packets := make(chan [][]byte)
+	go func() {
+		received := [][]byte{}
+		buffer := make([]byte, 100)
+		for {
+			count, err := server.Read(buffer)
+			if err == io.EOF {
+				packets <- received
+				return
+			}
+			received = append(received, append([]byte(nil), buffer[:count]...))
+		}
+	}()
+	if err := parsed.Run(client, nil); err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+	return <-packets
+}
+
+func TestScriptRun(t *testing.T) {
+	packets := runScript(t, `
+		set addr 00FE
+		loop 3
+			send 30{addr} "A"
+			inc addr
+		end
+		inc addr 0x10
+		send {addr}
+	`)
+	expected := [][]byte{{0x30, 0x00, 0xFE, 'A'}, {0x30, 0x00, 0xFF, 'A'}, {0x30, 0x01, 0x00, 'A'}, {0x01, 0x11}}
+	if !reflect.DeepEqual(packets, expected) {
+		t.Errorf("expected %X, got %X", expected, packets)
+	}
+}
+
+func TestScriptFraming(t *testing.T) {
+	check := []byte("123456789")
+	tests := []struct {
+		framing  string
+		expected []byte
+	}{
+		{"length u8", append([]byte{9}, check...)},
+		{"length u16be", append([]byte{0, 9}, check...)},
+		{"checksum crc16", append(append([]byte{}, check...), 0x37, 0x4B)},
+		{"checksum crc16ccitt", append(append([]byte{}, check...), 0x29, 0xB1)},
+		{"checksum crc32", append(append([]byte{}, check...), 0xCB, 0xF4, 0x39, 0x26)},
+		{"length u16le\nchecksum sum8", append(append([]byte{10, 0}, check...), 10+0xDD)}, // The sum covers the prefix
+		{"length u8\nchecksum xor payload", append(append([]byte{10}, check...), 0x31)},
+	}
+	for _, test := range tests {
+		packets := runScript(t, test.framing+"\nsend \"123456789\"")
+		if len(packets) != 1 || !bytes.Equal(packets[0], test.expected) {
+			t.Errorf("%q: expected %X, got %X", test.framing, test.expected, packets)
+		}
+	}
+}
+
+func TestIncrement(t *testing.T) {
+	if value := increment([]byte{0xFF, 0xFF}, 2); !bytes.Equal(value, []byte{0x00, 0x01}) {
+		t.Errorf("expected wrap around, got %X", value)
+	}
+	original := []byte{0x01}
+	if increment(original, 1); original[0] != 0x01 {
+		t.Error("the value was modified in place")
+	}
+}
@@ -0,0 +1,97 @@
+package ipbase
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// SessionLog writes a transcript (hex dumps with time, connection and direction) and a capture (see Capture) of the traffic.
+// Connections wrapped with Conn record by themselves, UDP sockets are recorded with Record. A nil *SessionLog does nothing.
+type SessionLog struct {
+	mu      sync.Mutex
+	log     *os.File
+	capture *Capture
+}
+
+// OpenSessionLog creates the transcript and capture files given. Nil is returned if both are empty.
+func OpenSessionLog(logFilename string, captureFilename string) (*SessionLog, error) {
+	if logFilename == "" && captureFilename == "" {
+		return nil, nil
+	}
+
+	session := &SessionLog{}
+	if logFilename != "" {
+		log, err := os.Create(logFilename)
+		if err != nil {
+			return nil, err
+		}
+		session.log = log
+	}
+	if captureFilename != "" {
+		capture, err := CreateCapture(captureFilename)
+		if err != nil {
+			session.Close()
+			return nil, err
+		}
+		session.capture = capture
+	}
+	return session, nil
+}
+
+// Record adds data going in direction ("SENT" or "RECV") on connection number conn
+func (s *SessionLog) Record(conn int, direction string, data []byte) {
+	if s == nil {
+		return
+	}
+	s.capture.Write(conn, direction, data)
+
+	if s.log != nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		FprintoutBytes(s.log, data, len(data), 16, time.Now().Format("2006-01-02 15:04:05.000")+" #"+strconv.Itoa(conn)+" "+direction+": ")
+	}
+}
+
+// Close closes the files
+func (s *SessionLog) Close() {
+	if s == nil {
+		return
+	}
+	if s.log != nil {
+		s.log.Close()
+	}
+	s.capture.Close()
+}
+
+// Conn returns a connection recording what is read and written on c as connection number conn. A nil *SessionLog returns c.
+func (s *SessionLog) Conn(c net.Conn, conn int) net.Conn {
+	if s == nil {
+		return c
+	}
+	return &recordedConn{Conn: c, session: s, conn: conn}
+}
+
+type recordedConn struct {
+	net.Conn
+	session *SessionLog
+	conn    int
+}
+
+func (c *recordedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.session.Record(c.conn, "RECV", b[:n])
+	}
+	return n, err
+}
+
+func (c *recordedConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		c.session.Record(c.conn, "SENT", b[:n])
+	}
+	return n, err
+}
@@ -0,0 +1,134 @@
+package ipbase
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"time"
+)
+
+// handshakeTimeout is how long a TLS peer has to complete the handshake
+const handshakeTimeout = 10 * time.Second
+
+// ClientTLSConfig returns the TLS configuration for ipclient. The server certificate is verified against the system CAs,
+// or caFile if given, unless insecure is set. certFile and keyFile give a client certificate, if the server asks for one.
+func ClientTLSConfig(serverName string, caFile string, insecure bool, certFile string, keyFile string) (*tls.Config, error) {
+	config := &tls.Config{ServerName: serverName, InsecureSkipVerify: insecure}
+
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no PEM certificates found", caFile)
+		}
+	}
+
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return nil, fmt.Errorf("a client certificate needs both a certificate and a key file")
+		}
+		certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+	return config, nil
+}
+
+// ServerTLSConfig returns the TLS configuration for ipserver with the certificate and key files given,
+// or a self-signed certificate generated for the occasion if both are empty. Clients are asked for a certificate so it can be printed, but it's not verified.
+func ServerTLSConfig(certFile string, keyFile string) (*tls.Config, error) {
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return nil, fmt.Errorf("a server certificate needs both a certificate and a key file")
+		}
+		certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		return &tls.Config{Certificates: []tls.Certificate{certificate}, ClientAuth: tls.RequestClientCert}, nil
+	}
+
+	certificate, err := selfSignedCertificate()
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("TLS: Self-signed certificate generated, SHA-256 fingerprint %X\n\n", sha256.Sum256(certificate.Certificate[0]))
+	return &tls.Config{Certificates: []tls.Certificate{certificate}, ClientAuth: tls.RequestClientCert}, nil
+}
+
+func selfSignedCertificate() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	hostname, _ := os.Hostname()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "ipserver"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(1, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	if hostname != "" {
+		template.DNSNames = append(template.DNSNames, hostname)
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+// HandshakeTLS completes the handshake if c is a TLS connection and prints the details. Other connections are left alone.
+func HandshakeTLS(c net.Conn) error {
+	tlsConn, ok := c.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), handshakeTimeout)
+	defer cancel()
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return fmt.Errorf("TLS handshake with %s: %w", c.RemoteAddr(), err)
+	}
+
+	state := tlsConn.ConnectionState()
+	fmt.Printf("TLS with %s: %s, %s", c.RemoteAddr(), tls.VersionName(state.Version), tls.CipherSuiteName(state.CipherSuite))
+	if state.NegotiatedProtocol != "" {
+		fmt.Printf(", ALPN %s", state.NegotiatedProtocol)
+	}
+	fmt.Println()
+	if len(state.PeerCertificates) == 0 {
+		fmt.Println("TLS: No peer certificate")
+	}
+	for n, certificate := range state.PeerCertificates {
+		fmt.Printf("TLS: Peer certificate %d: %s, issued by %s, valid %s to %s\n", n, certificate.Subject, certificate.Issuer,
+			certificate.NotBefore.Format("2006-01-02"), certificate.NotAfter.Format("2006-01-02"))
+	}
+	fmt.Println()
+	return nil
+}
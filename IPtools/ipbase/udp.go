@@ -0,0 +1,62 @@
+package ipbase
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"golang.org/x/net/ipv4"
+)
+
+// UDPpeer is a net.Conn for one peer on a shared, unconnected UDP socket, so it can be kept in TCPconnections:
+// What is written is sent to the peer. Reading isn't supported, as the datagrams from all peers arrive on the socket,
+// and closing does nothing, as the socket is shared.
+type UDPpeer struct {
+	*net.UDPConn
+	addr *net.UDPAddr
+}
+
+// NewUDPPeer returns a connection to addr through c
+func NewUDPPeer(c *net.UDPConn, addr *net.UDPAddr) *UDPpeer {
+	return &UDPpeer{UDPConn: c, addr: addr}
+}
+
+func (p *UDPpeer) Read(b []byte) (int, error) {
+	return 0, errors.New("datagrams from UDP peers are read from the shared socket")
+}
+
+func (p *UDPpeer) Write(b []byte) (int, error) {
+	return p.UDPConn.WriteToUDP(b, p.addr)
+}
+
+func (p *UDPpeer) RemoteAddr() net.Addr {
+	return p.addr
+}
+
+func (p *UDPpeer) Close() error {
+	return nil
+}
+
+// JoinGroups joins c to the IPv4 multicast groups given, comma separated, on the network interface with the name given or the default one
+func JoinGroups(c *net.UDPConn, groups string, ifaceName string) error {
+	var iface *net.Interface
+	if ifaceName != "" {
+		var err error
+		if iface, err = net.InterfaceByName(ifaceName); err != nil {
+			return err
+		}
+	}
+
+	packetConn := ipv4.NewPacketConn(c)
+	for _, group := range strings.Split(groups, ",") {
+		ip := net.ParseIP(strings.TrimSpace(group))
+		if ip == nil || ip.To4() == nil || !ip.IsMulticast() {
+			return fmt.Errorf("%q is not an IPv4 multicast group", group)
+		}
+		if err := packetConn.JoinGroup(iface, &net.UDPAddr{IP: ip}); err != nil {
+			return fmt.Errorf("joining %s: %w", ip, err)
+		}
+	}
+	return nil
+}
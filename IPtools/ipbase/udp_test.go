@@ -0,0 +1,72 @@
+package ipbase
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestUDPpeers(t *testing.T) {
+	server, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	client, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	clientAddr := client.LocalAddr().(*net.UDPAddr)
+
+	peers := &TCPconnections{}
+	if id, _ := peers.Find(clientAddr); id != 0 {
+		t.Fatalf("found peer %d before adding it", id)
+	}
+	id, peer := peers.Add(NewUDPPeer(server, clientAddr))
+	if found, _ := peers.Find(clientAddr); found != id {
+		t.Fatalf("expected peer %d, found %d", id, found)
+	}
+
+	// Writing to the peer sends a datagram to its address:
+	if err := peers.Write([]byte("TALLY\n")); err != nil {
+		t.Fatal(err)
+	}
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	buffer := make([]byte, 100)
+	count, from, err := client.ReadFromUDP(buffer)
+	if err != nil || string(buffer[:count]) != "TALLY\n" || from.String() != server.LocalAddr().String() {
+		t.Fatalf("got %q from %v, %v", buffer[:count], from, err)
+	}
+	if _, err := peer.Read(buffer); err == nil {
+		t.Error("expected reading from a peer to fail")
+	}
+
+	// Kicking forgets the peer, but leaves the shared socket open:
+	if !peers.Kick(id) || peers.Kick(id) {
+		t.Fatal("expected the peer to be kicked once")
+	}
+	if found, _ := peers.Find(clientAddr); found != 0 {
+		t.Errorf("peer %d still there after the kick", found)
+	}
+	if _, err := server.WriteToUDP([]byte("x"), clientAddr); err != nil {
+		t.Errorf("the shared socket was closed: %v", err)
+	}
+}
+
+func TestJoinGroupsErrors(t *testing.T) {
+	c, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	for _, groups := range []string{"192.168.1.1", "239.1.1.1,nonsense", "ff02::1"} {
+		if err := JoinGroups(c, groups, ""); err == nil {
+			t.Errorf("%q: expected an error", groups)
+		}
+	}
+	if err := JoinGroups(c, "239.1.1.1", "no-such-interface"); err == nil {
+		t.Error("expected an error for an unknown interface")
+	}
+}
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"crypto/tls"
 	"flag"
 	"fmt"
+	"io"
 	"net"
 	"strconv"
 	"time"
@@ -17,12 +19,33 @@ func main() {
 	noNLArgPtr := flag.Bool("nolf", false, "Strips the newline character from the output")
 	crlfArgPtr := flag.Bool("crlf", false, "Uses CR-LF as line ending in output")
 	crArgPtr := flag.Bool("cr", false, "Uses CR as line ending in output")
-	udpArgPtr := flag.Bool("udp", false, "Sends UDP instead of TCP")
+	escArgPtr := flag.Bool("esc", false, "Decodes \\r, \\n, \\t, \\xNN and {hex:...} in typed text, so text and binary can be mixed. A backslash before any other character stands for the character, like \\! for a line starting with !")
+	macrosArgPtr := flag.String("macros", "", "Reads macros from this file, one per line with a name and what to send. Send them with !name. !! and !N send lines of the history again, !history lists it. Without it, lines starting with ! are sent as typed")
+	rwpArgPtr := flag.String("rwp", "", "Decodes Raw Panel binary frames beneath the hex dump. Tell if the peer is a 'panel' or a 'system'")
+	udpArgPtr := flag.Bool("udp", false, "Sends UDP instead of TCP. The host may be a broadcast or multicast address, answers are printed with their source")
+	tlsArgPtr := flag.Bool("tls", false, "Connects with TLS")
+	serverNameArgPtr := flag.String("servername", "", "TLS: Name to verify the server certificate against. The host by default")
+	caArgPtr := flag.String("ca", "", "TLS: Verifies the server certificate against the CA certificates in this PEM file instead of the system ones")
+	insecureArgPtr := flag.Bool("insecure", false, "TLS: Accepts any server certificate")
+	certArgPtr := flag.String("cert", "", "TLS: Client certificate PEM file, if the server asks for one")
+	keyArgPtr := flag.String("key", "", "TLS: Key PEM file for the client certificate")
+	scriptArgPtr := flag.String("script", "", "Sends the packets of this script file, with length prefix, checksum, variables, delays and loops. See ipbase/script.go for the format")
+	logArgPtr := flag.String("log", "", "Writes a transcript of what is sent and received to this file, with time, connection and direction")
+	captureArgPtr := flag.String("capture", "", "Writes what is sent and received to this file, one line per read or write with time, connection, direction and hex bytes. Can be sent again with ipreplay")
+	reconnectArgPtr := flag.Bool("reconnect", false, "TCP: Connects again when the connection is lost or can't be made, waiting longer after each failed attempt")
+	backoffArgPtr := flag.Duration("backoff", 30*time.Second, "TCP: Longest wait between attempts to reconnect")
+	tcpKeepaliveArgPtr := flag.Duration("tcpkeepalive", 15*time.Second, "TCP: Interval of TCP keepalive probes, so a dead peer is noticed. 0 turns them off")
+	keepaliveArgPtr := flag.Duration("keepalive", 0, "Sends -keepalivedata this often, for peers that drop quiet connections")
+	keepaliveDataArgPtr := flag.String("keepalivedata", "", "Keepalive payload, with \\r, \\n, \\t, \\xNN and {hex:...} like typed text with -esc")
+	benchArgPtr := flag.Duration("bench", 0, "Benchmarks the network for this long against ipserver -echo, sending sequence numbered packets and measuring throughput, RTT, loss and reordering")
+	rateArgPtr := flag.Float64("rate", 100, "Bench: Packets sent per second")
+	sizeArgPtr := flag.Int("size", 64, fmt.Sprintf("Bench: Size of each packet in bytes, at least %d", ipbase.BenchHeaderSize))
+	csvArgPtr := flag.String("csv", "", "Bench: Writes a line per packet to this CSV file, with times, RTT and whether it was lost or reordered")
 	flag.Parse()
 
 	arguments := flag.Args()
 	if len(arguments) == 0 {
-		fmt.Println("usage: ipclient [-hex -udp] [host] [port]")
+		fmt.Println("usage: ipclient [-hex -esc -macros file -udp -tls -servername name -ca file -insecure -cert file -key file -rwp panel|system -script file -log file -capture file -reconnect -backoff 30s -tcpkeepalive 15s -keepalive 10s -keepalivedata data -bench 10s -rate N -size N -csv file] [host] [port]")
 		fmt.Println("help:  ipclient -h")
 		fmt.Println("")
 		return
@@ -45,6 +68,86 @@ func main() {
 
 	CONNECT := host + ":" + strconv.Itoa(portArg)
 
+	if *rwpArgPtr != "" && *rwpArgPtr != "panel" && *rwpArgPtr != "system" {
+		fmt.Println("rwp must be 'panel' or 'system'")
+		fmt.Println("")
+		return
+	}
+
+	var script *ipbase.Script
+	if *scriptArgPtr != "" {
+		script, err = ipbase.LoadScript(*scriptArgPtr)
+		if err != nil {
+			fmt.Println(err)
+			fmt.Println("")
+			return
+		}
+	}
+
+	var bench *ipbase.Bench
+	if *benchArgPtr > 0 {
+		if *scriptArgPtr != "" {
+			fmt.Println("A script can't be sent while benchmarking")
+			fmt.Println("")
+			return
+		}
+		bench, err = ipbase.NewBench(*sizeArgPtr, *rateArgPtr, *benchArgPtr, *csvArgPtr)
+		if err != nil {
+			fmt.Println(err)
+			fmt.Println("")
+			return
+		}
+	}
+
+	var keepalive []byte
+	if *keepaliveArgPtr > 0 {
+		keepalive, err = ipbase.KeepalivePayload(*keepaliveDataArgPtr)
+		if err != nil {
+			fmt.Println(err)
+			fmt.Println("")
+			return
+		}
+	}
+
+	if *reconnectArgPtr && (*udpArgPtr || bench != nil) {
+		fmt.Println("Reconnecting is for TCP only, and not while benchmarking")
+		fmt.Println("")
+		return
+	}
+
+	var tlsConfig *tls.Config
+	if *tlsArgPtr {
+		if *udpArgPtr {
+			fmt.Println("TLS is for TCP only")
+			fmt.Println("")
+			return
+		}
+		tlsConfig, err = ipbase.ClientTLSConfig(ipbase.QStr(*serverNameArgPtr != "", *serverNameArgPtr, host), *caArgPtr, *insecureArgPtr, *certArgPtr, *keyArgPtr)
+		if err != nil {
+			fmt.Println(err)
+			fmt.Println("")
+			return
+		}
+	}
+
+	var macros map[string]string
+	if *macrosArgPtr != "" {
+		macros, err = ipbase.LoadMacros(*macrosArgPtr)
+		if err != nil {
+			fmt.Println(err)
+			fmt.Println("")
+			return
+		}
+	}
+
+	session, err := ipbase.OpenSessionLog(*logArgPtr, *captureArgPtr)
+	if err != nil {
+		fmt.Println(err)
+		fmt.Println("")
+		return
+	}
+	defer session.Close()
+
 	// Welcome message!
 	fmt.Println("Welcome to ipclient! Made by Kasper Skaarhoj 2020")
 	fmt.Println("Configuration:")
@@ -52,56 +155,142 @@ func main() {
 	fmt.Println("  nolf: ", *noNLArgPtr)
 	fmt.Println("  crlf: ", *crlfArgPtr)
 	fmt.Println("  cr: ", *crArgPtr)
+	fmt.Println("  esc:  ", *escArgPtr)
+	if *macrosArgPtr != "" {
+		fmt.Println("  macros:", *macrosArgPtr)
+	}
 	fmt.Println("  udp:  ", *udpArgPtr)
+	if *tlsArgPtr {
+		fmt.Println("  tls:  ", ipbase.QStr(*insecureArgPtr, "insecure", "server name "+tlsConfig.ServerName)+ipbase.QStr(*caArgPtr != "", ", CA "+*caArgPtr, "")+ipbase.QStr(*certArgPtr != "", ", client certificate "+*certArgPtr, ""))
+	}
+	if *rwpArgPtr != "" {
+		fmt.Println("  rwp:  ", *rwpArgPtr)
+	}
+	if *scriptArgPtr != "" {
+		fmt.Println("  script:", *scriptArgPtr)
+	}
+	if *logArgPtr != "" {
+		fmt.Println("  log:  ", *logArgPtr)
+	}
+	if *captureArgPtr != "" {
+		fmt.Println("  capture:", *captureArgPtr)
+	}
+	if *reconnectArgPtr {
+		fmt.Println("  reconnect:", "backoff up to", *backoffArgPtr)
+	}
+	if !*udpArgPtr {
+		fmt.Println("  tcpkeepalive:", ipbase.QStr(*tcpKeepaliveArgPtr > 0, tcpKeepaliveArgPtr.String(), "off"))
+	}
+	if keepalive != nil {
+		fmt.Printf("  keepalive: %v, %X\n", *keepaliveArgPtr, keepalive)
+	}
+	if bench != nil {
+		fmt.Println("  bench:", *benchArgPtr, "at", *rateArgPtr, "packets/s of", *sizeArgPtr, "bytes")
+		if *csvArgPtr != "" {
+			fmt.Println("  csv:  ", *csvArgPtr)
+		}
+	}
 	fmt.Println("  host: ", host)
 	fmt.Println("  port: ", portArg)
-	fmt.Println("Ready to send " + ipbase.QStr(*udpArgPtr, "UDP", "TCP") + " messages to " + CONNECT + " and receive values back...\n")
+	fmt.Println("Ready to send " + ipbase.QStr(*udpArgPtr, "UDP", "TCP") + ipbase.QStr(*tlsArgPtr, "/TLS", "") + " messages to " + CONNECT + " and receive values back...\n")
 
-	var c net.Conn
+	var c io.Writer
+	var done <-chan struct{}
 	if *udpArgPtr {
 		s, err := net.ResolveUDPAddr("udp4", CONNECT)
-		c, err = net.DialUDP("udp4", nil, s)
 		if err != nil {
 			fmt.Println(err)
 			return
 		}
-		defer c.Close()
-
-		// Looking for input from network:
-		go ipbase.ListenerUDP(c.(*net.UDPConn))
-	} else {
-		c, err = net.Dial("tcp", CONNECT)
+		// Not connected to the address, so answers to broadcasts and multicasts are received from whoever sends them
+		u, err := net.ListenUDP("udp4", nil)
 		if err != nil {
 			fmt.Println(err)
 			return
 		}
+		defer u.Close()
+		c = session.Conn(ipbase.NewUDPPeer(u, s), 1) // Records what is sent, ListenerUDP records what is received
+
+		if bench != nil {
+			if err := bench.Run(c, func(b []byte) (int, error) {
+				n, _, err := u.ReadFromUDP(b)
+				if n > 0 {
+					session.Record(1, "RECV", b[:n])
+				}
+				return n, err
+			}, true); err != nil {
+				fmt.Println(err)
+			}
+			return
+		}
 
 		// Looking for input from network:
-		go ipbase.Listener(c)
+		go ipbase.ListenerUDP(u, ipbase.NewRWPDecoder(*rwpArgPtr, false), session)
+	} else {
+		dialer := &net.Dialer{KeepAlive: *tcpKeepaliveArgPtr}
+		if *tcpKeepaliveArgPtr <= 0 {
+			dialer.KeepAlive = -1 // Zero would be Go's default
+		}
+		dial := func() (net.Conn, error) {
+			c, err := dialer.Dial("tcp", CONNECT)
+			if err != nil {
+				return nil, err
+			}
+			if tlsConfig != nil {
+				c = tls.Client(c, tlsConfig)
+				if err := ipbase.HandshakeTLS(c); err != nil {
+					c.Close()
+					return nil, err
+				}
+			}
+			return c, nil
+		}
+
+		if bench != nil {
+			conn, err := dial()
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+			conn = session.Conn(conn, 1)
+			if err := bench.Run(conn, conn.Read, false); err != nil {
+				fmt.Println(err)
+			}
+			conn.Close()
+			return
+		}
+
+		// Looking for input from network, on each connection made:
+		redialer := ipbase.NewRedialer(dial, *reconnectArgPtr, *backoffArgPtr, session)
+		go redialer.Run(func(c net.Conn) error {
+			return ipbase.Listener(c, ipbase.NewRWPDecoder(*rwpArgPtr, false), nil) // Each connection has its own stream of frames
+		})
+		select {
+		case <-redialer.Connected():
+		case <-redialer.Done():
+			return
+		}
+		c = redialer
+		done = redialer.Done()
 	}
 
 	// Looking for text input to send:
-	rConfig := ipbase.ReaderConfig{Hex: *hexArgPtr, Nolf: *noNLArgPtr, Crlf: *crlfArgPtr, Cr: *crArgPtr}
+	rConfig := ipbase.ReaderConfig{Hex: *hexArgPtr, Nolf: *noNLArgPtr, Crlf: *crlfArgPtr, Cr: *crArgPtr, RWP: *rwpArgPtr, Escapes: *escArgPtr, Macros: macros}
 	go ipbase.Linereader(c, rConfig)
 
-	// Eternal loop:
-	for {
-		time.Sleep(time.Millisecond * 20)
-
-		//packet := []byte{0xF0, 0, 0}
-		//packet := []byte{0xB0, 0, 0, 0, 0, 4}
-		packet := []byte{0x30, 0, 0, 0, 0, 0, 0xFF}
-		//packet := []byte{0x20, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00}
+	if keepalive != nil {
+		go ipbase.SendKeepalives(c, keepalive, *keepaliveArgPtr)
+	}
 
-		checksum := byte(0)
-		for _, v := range packet {
-			checksum += v
+	// Sending the script, if any:
+	if script != nil {
+		if err := script.Run(c, ipbase.NewRWPDecoder(*rwpArgPtr, true)); err != nil {
+			fmt.Println(err)
 		}
-		checksum += byte(len(packet)) + 1
-
-		packet = append(packet, checksum)
-		packet = append([]byte{byte(len(packet))}, packet...)
-		//ipbase.PrintoutBytes(packet, len(packet), 16, "packet: ")
-		c.Write(packet)
+		fmt.Println("Script done")
+		fmt.Println("")
 	}
+
+	// Running until the TCP connection is lost for good. Forever with UDP, where done is nil:
+	<-done
 }
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	ipbase "iptools/ipbase"
+)
+
+// Directions, as printed and captured: Client to target and target to client
+const (
+	toTarget = "C>T"
+	toClient = "T>C"
+)
+
+// printMutex keeps the dumps of concurrent connections from interleaving
+var printMutex sync.Mutex
+
+// dump prints data going in direction on connection number conn, with a timestamp
+func dump(conn int, direction string, data []byte, rwpDecoder *ipbase.RWPDecoder, capture *ipbase.Capture) {
+	capture.Write(conn, direction, data)
+
+	printMutex.Lock()
+	defer printMutex.Unlock()
+	ipbase.PrintoutBytes(data, len(data), 16, time.Now().Format("15:04:05.000")+" #"+strconv.Itoa(conn)+" "+direction+": ")
+	rwpDecoder.Print(data)
+}
+
+// pipe copies from one connection to the other until either fails, dumping everything
+func pipe(conn int, direction string, from net.Conn, to net.Conn, rwpDecoder *ipbase.RWPDecoder, capture *ipbase.Capture) {
+	byteArray := make([]byte, 2000)
+	for {
+		byteCount, err := from.Read(byteArray)
+		if byteCount > 0 {
+			dump(conn, direction, byteArray[:byteCount], rwpDecoder, capture)
+			if _, err := to.Write(byteArray[:byteCount]); err != nil {
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// proxyTCP forwards one client connection to the target until either side closes
+func proxyTCP(conn int, client net.Conn, target string, rwpPeer string, capture *ipbase.Capture) {
+	defer client.Close()
+
+	server, err := net.Dial("tcp", target)
+	if err != nil {
+		fmt.Printf("#%d: %v\n\n", conn, err)
+		return
+	}
+	defer server.Close()
+	fmt.Printf("#%d: %s connected, forwarding to %s\n\n", conn, client.RemoteAddr(), target)
+
+	done := make(chan struct{}, 2)
+	go func() {
+		pipe(conn, toTarget, client, server, ipbase.NewRWPDecoder(rwpPeer, true), capture)
+		done <- struct{}{}
+	}()
+	go func() {
+		pipe(conn, toClient, server, client, ipbase.NewRWPDecoder(rwpPeer, false), capture)
+		done <- struct{}{}
+	}()
+	<-done // When one side is closed, the other one is closed too by the deferred calls
+
+	fmt.Printf("#%d: %s disconnected\n\n", conn, client.RemoteAddr())
+}
+
+// udpSession is the socket towards the target for one client of a UDP proxy
+type udpSession struct {
+	conn       int
+	target     *net.UDPConn
+	fromTarget *ipbase.RWPDecoder
+	toTarget   *ipbase.RWPDecoder
+	lastActive time.Time // Last datagram in either direction
+}
+
+// proxyUDP forwards datagrams from each client address to the target through a socket of its own, so answers can be sent back to the right client.
+// A session without datagrams in either direction for the idle time is closed, and the next datagram from the client starts a new one.
+func proxyUDP(listener *net.UDPConn, target string, idle time.Duration, rwpPeer string, capture *ipbase.Capture) {
+	targetAddr, err := net.ResolveUDPAddr("udp4", target)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	sessions := make(map[string]*udpSession)
+	var sessionsMutex sync.Mutex // Guards sessions and their lastActive
+	count := 0
+	byteArray := make([]byte, 65536)
+	for {
+		byteCount, clientAddr, err := listener.ReadFromUDP(byteArray)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		sessionsMutex.Lock()
+		session := sessions[clientAddr.String()]
+		if session == nil {
+			targetConn, err := net.DialUDP("udp4", nil, targetAddr)
+			if err != nil {
+				sessionsMutex.Unlock()
+				fmt.Println(err)
+				continue
+			}
+			count++
+			session = &udpSession{
+				conn:       count,
+				target:     targetConn,
+				fromTarget: ipbase.NewRWPDecoder(rwpPeer, false),
+				toTarget:   ipbase.NewRWPDecoder(rwpPeer, true),
+			}
+			sessions[clientAddr.String()] = session
+			fmt.Printf("#%d: datagrams from %s are forwarded to %s\n\n", session.conn, clientAddr, target)
+
+			go func(session *udpSession, clientAddr *net.UDPAddr) {
+				answer := make([]byte, 65536)
+				for {
+					session.target.SetReadDeadline(time.Now().Add(idle))
+					byteCount, err := session.target.Read(answer)
+					if err != nil {
+						sessionsMutex.Lock()
+						if errors.Is(err, os.ErrDeadlineExceeded) && time.Since(session.lastActive) < idle {
+							sessionsMutex.Unlock()
+							continue // The client is still sending
+						}
+						delete(sessions, clientAddr.String()) // Under the same lock as the check, so no datagram is written to the closed socket
+						sessionsMutex.Unlock()
+						session.target.Close()
+
+						if errors.Is(err, os.ErrDeadlineExceeded) {
+							fmt.Printf("#%d: no datagrams for %v, session closed\n\n", session.conn, idle)
+						} else {
+							fmt.Printf("#%d: %v\n\n", session.conn, err)
+						}
+						return
+					}
+					sessionsMutex.Lock()
+					session.lastActive = time.Now()
+					sessionsMutex.Unlock()
+					dump(session.conn, toClient, answer[:byteCount], session.fromTarget, capture)
+					listener.WriteToUDP(answer[:byteCount], clientAddr)
+				}
+			}(session, clientAddr)
+		}
+		session.lastActive = time.Now() // Set before the lock is released, so the session is not closed before the datagram is written
+		sessionsMutex.Unlock()
+
+		dump(session.conn, toTarget, byteArray[:byteCount], session.toTarget, capture)
+		session.target.Write(byteArray[:byteCount])
+	}
+}
+
+func main() {
+
+	// Setting up and parsing command line parameters
+	udpArgPtr := flag.Bool("udp", false, "Forwards UDP datagrams instead of TCP connections")
+	rwpArgPtr := flag.String("rwp", "", "Decodes Raw Panel binary frames beneath the hex dump. Tell if the target is a 'panel' or a 'system'")
+	captureArgPtr := flag.String("capture", "", "Writes all traffic to this file, one line per read with time, connection, direction and hex bytes")
+	udpIdleArgPtr := flag.Duration("udpIdle", time.Minute, "Closes the socket towards the target for a UDP client after this long without datagrams")
+	flag.Parse()
+
+	arguments := flag.Args()
+	if len(arguments) < 2 {
+		fmt.Println("usage: ipproxy [-udp -udpIdle 1m -rwp panel|system -capture file] [port] [target host:port]")
+		fmt.Println("help:  ipproxy -h")
+		fmt.Println("")
+		return
+	}
+
+	portArg, err := strconv.Atoi(arguments[0])
+	if err != nil {
+		fmt.Println("Port was not an integer")
+		fmt.Println("")
+		return
+	}
+	target := arguments[1]
+	if _, _, err := net.SplitHostPort(target); err != nil {
+		fmt.Println("Target must be host:port")
+		fmt.Println("")
+		return
+	}
+	if *rwpArgPtr != "" && *rwpArgPtr != "panel" && *rwpArgPtr != "system" {
+		fmt.Println("rwp must be 'panel' or 'system'")
+		fmt.Println("")
+		return
+	}
+
+	var capture *ipbase.Capture
+	if *captureArgPtr != "" {
+		capture, err = ipbase.CreateCapture(*captureArgPtr)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		defer capture.Close()
+	}
+
+	// Welcome message!
+	fmt.Println("Welcome to ipproxy!")
+	fmt.Println("Configuration:")
+	fmt.Println("  udp:    ", *udpArgPtr)
+	if *udpArgPtr {
+		fmt.Println("  udpIdle:", *udpIdleArgPtr)
+	}
+	fmt.Println("  port:   ", portArg)
+	fmt.Println("  target: ", target)
+	if *rwpArgPtr != "" {
+		fmt.Println("  rwp:    ", *rwpArgPtr)
+	}
+	if *captureArgPtr != "" {
+		fmt.Println("  capture:", *captureArgPtr)
+	}
+	fmt.Println("Ready to accept " + ipbase.QStr(*udpArgPtr, "UDP", "TCP") + " traffic on port " + arguments[0] + " and forward it to " + target + " (" + toTarget + ") and back (" + toClient + ")...\n")
+
+	// Set up server:
+	PORT := ":" + arguments[0]
+
+	if *udpArgPtr {
+		s, err := net.ResolveUDPAddr("udp4", PORT)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		c, err := net.ListenUDP("udp4", s)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		defer c.Close()
+
+		proxyUDP(c, target, *udpIdleArgPtr, *rwpArgPtr, capture)
+		return
+	}
+
+	l, err := net.Listen("tcp", PORT)
+	if err != nil {
+		fmt.Println(err)
+		fmt.Println("")
+		return
+	}
+	defer l.Close()
+
+	for conn := 1; ; conn++ {
+		c, err := l.Accept()
+		if err != nil {
+			fmt.Println(err)
+			fmt.Println("")
+			return
+		}
+		go proxyTCP(conn, c, target, *rwpArgPtr, capture)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	ipbase "iptools/ipbase"
+)
+
+func main() {
+
+	// Setting up and parsing command line parameters
+	udpArgPtr := flag.Bool("udp", false, "Sends UDP instead of TCP")
+	directionArgPtr := flag.String("direction", "SENT", "Replays the records in this direction: SENT or RECV for ipclient and ipserver captures, C>T or T>C for ipproxy captures")
+	connArgPtr := flag.Int("conn", 0, "Replays only the records of this connection number. All connections if 0")
+	waitArgPtr := flag.Duration("wait", time.Second, "Time to wait for answers after the last record before quitting")
+	rwpArgPtr := flag.String("rwp", "", "Decodes Raw Panel binary frames beneath the hex dump. Tell if the peer is a 'panel' or a 'system'")
+	flag.Parse()
+
+	arguments := flag.Args()
+	if len(arguments) < 3 {
+		fmt.Println("usage: ipreplay [-udp -direction SENT -conn N -wait 1s -rwp panel|system] [capture file] [host] [port]")
+		fmt.Println("help:  ipreplay -h")
+		fmt.Println("")
+		return
+	}
+
+	portArg, err := strconv.Atoi(arguments[2])
+	if err != nil {
+		fmt.Println("Port was not an integer")
+		fmt.Println("")
+		return
+	}
+	CONNECT := net.JoinHostPort(arguments[1], strconv.Itoa(portArg))
+
+	if *rwpArgPtr != "" && *rwpArgPtr != "panel" && *rwpArgPtr != "system" {
+		fmt.Println("rwp must be 'panel' or 'system'")
+		fmt.Println("")
+		return
+	}
+
+	records, err := ipbase.ReadCapture(arguments[0])
+	if err != nil {
+		fmt.Println(err)
+		fmt.Println("")
+		return
+	}
+	replay := []ipbase.CaptureRecord{}
+	directions := map[string]bool{}
+	for _, record := range records {
+		directions[record.Direction] = true
+		if record.Direction == *directionArgPtr && (*connArgPtr == 0 || record.Conn == *connArgPtr) && len(record.Data) > 0 {
+			replay = append(replay, record)
+		}
+	}
+	if len(replay) == 0 {
+		found := []string{}
+		for direction := range directions {
+			found = append(found, direction)
+		}
+		sort.Strings(found)
+		fmt.Printf("Nothing to replay in direction %s. The capture has: %s\n", *directionArgPtr, strings.Join(found, ", "))
+		fmt.Println("")
+		return
+	}
+
+	// Welcome message!
+	fmt.Println("Welcome to ipreplay!")
+	fmt.Println("Configuration:")
+	fmt.Println("  capture:  ", arguments[0])
+	fmt.Println("  direction:", *directionArgPtr)
+	if *connArgPtr != 0 {
+		fmt.Println("  conn:     ", *connArgPtr)
+	}
+	fmt.Println("  udp:      ", *udpArgPtr)
+	if *rwpArgPtr != "" {
+		fmt.Println("  rwp:      ", *rwpArgPtr)
+	}
+	fmt.Println("  host:     ", arguments[1])
+	fmt.Println("  port:     ", portArg)
+	fmt.Printf("Replaying %d records over %v to %s...\n\n", len(replay), replay[len(replay)-1].Time.Sub(replay[0].Time).Round(time.Millisecond), CONNECT)
+
+	var c net.Conn
+	if *udpArgPtr {
+		s, err := net.ResolveUDPAddr("udp4", CONNECT)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		c, err = net.DialUDP("udp4", nil, s)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		go ipbase.ListenerUDP(c.(*net.UDPConn), ipbase.NewRWPDecoder(*rwpArgPtr, false), nil)
+	} else {
+		c, err = net.Dial("tcp", CONNECT)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		go func() {
+			if err := ipbase.Listener(c, ipbase.NewRWPDecoder(*rwpArgPtr, false), nil); err != nil {
+				fmt.Println(err)
+				fmt.Println("")
+			}
+		}()
+	}
+	defer c.Close()
+
+	// Sending with the original timing, relative to the first record:
+	rwpDecoder := ipbase.NewRWPDecoder(*rwpArgPtr, true)
+	start := time.Now()
+	for _, record := range replay {
+		time.Sleep(time.Until(start.Add(record.Time.Sub(replay[0].Time))))
+
+		ipbase.PrintoutBytes(record.Data, len(record.Data), 16, "SENT: ")
+		rwpDecoder.Print(record.Data)
+		if _, err := c.Write(record.Data); err != nil {
+			fmt.Println(err)
+			fmt.Println("")
+			return
+		}
+	}
+
+	fmt.Println("Replay done")
+	fmt.Println("")
+	time.Sleep(*waitArgPtr)
+}
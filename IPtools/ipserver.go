@@ -1,6 +1,7 @@
 package main
 
 import (
+	"crypto/tls"
 	"flag"
 	"fmt"
 	"net"
@@ -15,12 +16,24 @@ func main() {
 	hexArgPtr := flag.Bool("hex", false, "Parses input as hex like 'DE AD BE EF' or 'DEADBEEF' (and ignores line ending)")
 	noNLArgPtr := flag.Bool("nolf", false, "Strips the newline character from the output")
 	crlfArgPtr := flag.Bool("crlf", false, "Uses CR-LF as line ending in output")
+	escArgPtr := flag.Bool("esc", false, "Decodes \\r, \\n, \\t, \\xNN and {hex:...} in typed text, so text and binary can be mixed. A backslash before any other character stands for the character, like \\! for a line starting with !")
+	macrosArgPtr := flag.String("macros", "", "Reads macros from this file, one per line with a name and what to send. Send them with !name. !! and !N send lines of the history again, !history lists it. Without it, lines starting with ! are sent as typed")
+	rwpArgPtr := flag.String("rwp", "", "Decodes Raw Panel binary frames beneath the hex dump. Tell if the peer is a 'panel' or a 'system'")
 	udpArgPtr := flag.Bool("udp", false, "Listens for UDP instead of TCP")
+	tlsArgPtr := flag.Bool("tls", false, "Accepts TLS connections, with a self-signed certificate unless -cert and -key are given")
+	certArgPtr := flag.String("cert", "", "TLS: Server certificate PEM file")
+	keyArgPtr := flag.String("key", "", "TLS: Key PEM file for the server certificate")
+	joinArgPtr := flag.String("join", "", "UDP: Joins these multicast groups, comma separated, like 239.255.0.1")
+	ifaceArgPtr := flag.String("iface", "", "UDP: Network interface to join multicast groups on. The default one if not given")
+	rulesArgPtr := flag.String("rules", "", "Answers what is received according to this rules file, to emulate a device. See ipbase/rules.go for the format")
+	echoArgPtr := flag.Bool("echo", false, "Sends everything received back to the sender without printing it, as the peer for ipclient -bench")
+	logArgPtr := flag.String("log", "", "Writes a transcript of what is sent and received to this file, with time, connection and direction")
+	captureArgPtr := flag.String("capture", "", "Writes what is sent and received to this file, one line per read or write with time, connection, direction and hex bytes. Can be sent again with ipreplay")
 	flag.Parse()
 
 	arguments := flag.Args()
 	if len(arguments) == 0 {
-		fmt.Println("usage: ipserver [-hex -udp] [port]")
+		fmt.Println("usage: ipserver [-hex -esc -macros file -udp -join groups -iface name -tls -cert file -key file -rwp panel|system -rules file -echo -log file -capture file] [port]")
 		fmt.Println("help:  ipserver -h")
 		fmt.Println("")
 		return
@@ -33,15 +46,92 @@ func main() {
 		return
 	}
 
+	if *rwpArgPtr != "" && *rwpArgPtr != "panel" && *rwpArgPtr != "system" {
+		fmt.Println("rwp must be 'panel' or 'system'")
+		fmt.Println("")
+		return
+	}
+
+	var rules *ipbase.Rules
+	if *rulesArgPtr != "" {
+		rules, err = ipbase.LoadRules(*rulesArgPtr)
+		if err != nil {
+			fmt.Println(err)
+			fmt.Println("")
+			return
+		}
+	}
+
+	if *echoArgPtr && rules != nil {
+		fmt.Println("Rules can't be used with -echo")
+		fmt.Println("")
+		return
+	}
+
+	if *tlsArgPtr && *udpArgPtr {
+		fmt.Println("TLS is for TCP only")
+		fmt.Println("")
+		return
+	}
+	if *joinArgPtr != "" && !*udpArgPtr {
+		fmt.Println("Multicast groups can only be joined with -udp")
+		fmt.Println("")
+		return
+	}
+
+	var macros map[string]string
+	if *macrosArgPtr != "" {
+		macros, err = ipbase.LoadMacros(*macrosArgPtr)
+		if err != nil {
+			fmt.Println(err)
+			fmt.Println("")
+			return
+		}
+	}
+
+	session, err := ipbase.OpenSessionLog(*logArgPtr, *captureArgPtr)
+	if err != nil {
+		fmt.Println(err)
+		fmt.Println("")
+		return
+	}
+	defer session.Close()
+
 	// Welcome message!
 	fmt.Println("Welcome to ipserver! Made by Kasper Skaarhoj 2020")
 	fmt.Println("Configuration:")
 	fmt.Println("  hex:  ", *hexArgPtr)
 	fmt.Println("  nolf: ", *noNLArgPtr)
 	fmt.Println("  crlf: ", *crlfArgPtr)
+	fmt.Println("  esc:  ", *escArgPtr)
+	if *macrosArgPtr != "" {
+		fmt.Println("  macros:", *macrosArgPtr)
+	}
 	fmt.Println("  udp:  ", *udpArgPtr)
+	if *joinArgPtr != "" {
+		fmt.Println("  join: ", *joinArgPtr+ipbase.QStr(*ifaceArgPtr != "", " on "+*ifaceArgPtr, ""))
+	}
+	if *tlsArgPtr {
+		fmt.Println("  tls:  ", ipbase.QStr(*certArgPtr != "", *certArgPtr, "self-signed"))
+	}
+	if *rwpArgPtr != "" {
+		fmt.Println("  rwp:  ", *rwpArgPtr)
+	}
+	if *rulesArgPtr != "" {
+		fmt.Println("  rules:", *rulesArgPtr)
+	}
+	if *echoArgPtr {
+		fmt.Println("  echo: ", *echoArgPtr)
+	}
+	if *logArgPtr != "" {
+		fmt.Println("  log:  ", *logArgPtr)
+	}
+	if *captureArgPtr != "" {
+		fmt.Println("  capture:", *captureArgPtr)
+	}
 	fmt.Println("  port: ", portArg)
-	fmt.Println("Ready to accept "+ipbase.QStr(*udpArgPtr, "UDP", "TCP")+" connections on port", int(portArg), "and send values back...\n")
+	fmt.Println("Ready to accept "+ipbase.QStr(*udpArgPtr, "UDP", "TCP")+ipbase.QStr(*tlsArgPtr, "/TLS", "")+" connections on port", int(portArg), "and send values back...")
+	fmt.Println("")
 
 	// Set up server:
 	PORT := ":" + arguments[0]
@@ -62,44 +152,73 @@ func main() {
 
 		defer c.Close()
 
-		returnMessage := make(chan []byte, 2)
+		if *joinArgPtr != "" {
+			if err := ipbase.JoinGroups(c, *joinArgPtr, *ifaceArgPtr); err != nil {
+				fmt.Println(err)
+				fmt.Println("")
+				return
+			}
+		}
+
+		// Everyone heard from is a peer, which lines typed and periodic messages are sent to:
+		peers := ipbase.TCPconnections{Session: session}
+		fmt.Println("Lines typed are sent to all peers heard from. Use /list, /to <id> <payload> and /kick <id> for single ones.")
+		fmt.Println("")
+
+		rConfig := ipbase.ReaderConfig{Hex: *hexArgPtr, Nolf: *noNLArgPtr, Crlf: *crlfArgPtr, RWP: *rwpArgPtr, Escapes: *escArgPtr, Macros: macros}
+		go ipbase.LinereaderConnections(&peers, rConfig)
+		rules.StartPeriodic(peers.Write)
 
 		// Looking for input from network:
-		go func() {
-			byteArray := make([]byte, 2000)
-			for {
-				byteCount, addr, err := c.ReadFromUDP(byteArray)
-				if err != nil {
-					fmt.Println(err)
-					fmt.Println("")
-					return
-				}
+		type peerStream struct {
+			rwpDecoder *ipbase.RWPDecoder
+			responder  *ipbase.Responder
+		}
+		streams := make(map[int]*peerStream) // Each peer has its own stream of frames and lines
 
-				ipbase.PrintoutBytes(byteArray, byteCount, 16, "RECV: ")
+		byteArray := make([]byte, 65536)
+		for {
+			byteCount, addr, err := c.ReadFromUDP(byteArray)
+			if err != nil {
+				fmt.Println(err)
+				fmt.Println("")
+				return
+			}
 
-				select {
-				case msg := <-returnMessage:
-					ipbase.PrintoutBytes(msg, len(msg), 16, "SENT: ")
-					_, err = c.WriteToUDP(msg, addr)
-					if err != nil {
-						fmt.Println(err)
-						return
+			id, peer := peers.Find(addr)
+			if peer == nil {
+				for streamID := range streams {
+					if peers.Get(streamID) == nil { // Kicked, it gets a new ID and stream if heard from again
+						delete(streams, streamID)
 					}
-				default:
 				}
+				id, peer = peers.Add(ipbase.NewUDPPeer(c, addr))
+				streams[id] = &peerStream{rwpDecoder: ipbase.NewRWPDecoder(*rwpArgPtr, false), responder: rules.NewResponder()}
+				fmt.Printf("#%d: %s heard from\n\n", id, addr)
 			}
-		}()
 
-		// Duplicated from ipbase - a shame, but the one in ipbase writes to a Conn object, not a channel, so I'm not sure how to harmonize that.
-		rConfig := ipbase.ReaderConfig{Hex: *hexArgPtr, Nolf: *noNLArgPtr, Crlf: *crlfArgPtr}
-		go ipbase.LinereaderChannel(returnMessage, rConfig)
+			session.Record(id, "RECV", byteArray[:byteCount])
+			if *echoArgPtr {
+				peer.Write(byteArray[:byteCount])
+				continue
+			}
+			ipbase.PrintoutBytes(byteArray, byteCount, 16, fmt.Sprintf("RECV #%d: ", id))
+			streams[id].rwpDecoder.Print(byteArray[:byteCount])
+			streams[id].responder.Received(byteArray[:byteCount], func(bytes []byte) error {
+				_, err := peer.Write(bytes)
+				return err
+			})
+		}
 	} else {
 
-		connections := ipbase.TCPconnections{}
+		connections := ipbase.TCPconnections{Session: session}
+		fmt.Println("Lines typed are sent to all connections. Use /list, /to <id> <payload> and /kick <id> for single ones.")
+		fmt.Println("")
 
 		// Looking for text input to send:
-		rConfig := ipbase.ReaderConfig{Hex: *hexArgPtr, Nolf: *noNLArgPtr, Crlf: *crlfArgPtr}
+		rConfig := ipbase.ReaderConfig{Hex: *hexArgPtr, Nolf: *noNLArgPtr, Crlf: *crlfArgPtr, RWP: *rwpArgPtr, Escapes: *escArgPtr, Macros: macros}
 		go ipbase.LinereaderConnections(&connections, rConfig)
+		rules.StartPeriodic(connections.Write)
 
 		l, err := net.Listen("tcp", PORT)
 		if err != nil {
@@ -109,6 +228,16 @@ func main() {
 		}
 		defer l.Close()
 
+		if *tlsArgPtr {
+			tlsConfig, err := ipbase.ServerTLSConfig(*certArgPtr, *keyArgPtr)
+			if err != nil {
+				fmt.Println(err)
+				fmt.Println("")
+				return
+			}
+			l = tls.NewListener(l, tlsConfig)
+		}
+
 		for {
 			c, err := l.Accept()
 			if err != nil {
@@ -117,16 +246,34 @@ func main() {
 				return
 			}
 
-			// Looking for input from network:
-			connections.Add(&c)
 			go func() {
-				ipbase.Listener(c)
-				connections.Remove(&c)
+				if err := ipbase.HandshakeTLS(c); err != nil {
+					fmt.Println(err)
+					fmt.Println("")
+					c.Close()
+					return
+				}
+
+				// Looking for input from network:
+				id, c := connections.Add(c)
+				fmt.Printf("#%d: %s connected\n\n", id, c.RemoteAddr())
+
+				if *echoArgPtr {
+					ipbase.Echo(c)
+					connections.Remove(c)
+					fmt.Printf("#%d: %s disconnected\n\n", id, c.RemoteAddr())
+					return
+				}
+
+				responder := rules.NewResponder()
+				responder.Connected(func(bytes []byte) error {
+					_, err := c.Write(bytes)
+					return err
+				})
+				err := ipbase.Listener(c, ipbase.NewRWPDecoder(*rwpArgPtr, false), responder) // Each connection has its own stream of frames and lines
+				connections.Remove(c)
+				fmt.Printf("#%d: %s disconnected: %v\n\n", id, c.RemoteAddr(), err)
 			}()
 		}
 	}
-
-	// Eternal loop:
-	for {
-	}
 }
@@ -0,0 +1,94 @@
+package rpserver
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// EventType is the kind of an Event
+type EventType int
+
+const (
+	Down  EventType = iota // A button is pressed
+	Up                     // A button is released
+	Press                  // A button is pressed and released, from panels which don't tell the two apart
+	Enc                    // An encoder is turned, Value is the pulses (negative counter clockwise)
+	Abs                    // A fader or potentiometer is moved, Value is the position 0-1000
+	Speed                  // A joystick or jog is moved, Value is the speed (negative backwards)
+)
+
+var eventTypeNames = map[EventType]string{Down: "Down", Up: "Up", Press: "Press", Enc: "Enc", Abs: "Abs", Speed: "Speed"}
+
+func (t EventType) String() string {
+	if name, exists := eventTypeNames[t]; exists {
+		return name
+	}
+	return "EventType(" + strconv.Itoa(int(t)) + ")"
+}
+
+// Event is an event from a panel in ASCII protocol syntax, like "HWC#12=Down", "HWC#12.2=Up" (an edge of a four-way button) or "HWC#4=Enc:-2"
+type Event struct {
+	HWC   uint32
+	Edge  uint32 // 0 if the line has no edge
+	Type  EventType
+	Value int // For Enc, Abs and Speed
+}
+
+var eventRegex = regexp.MustCompile(`^HWC#([0-9]+)(?:\.([0-9]+))?=(Down|Up|Press|Enc|Abs|Speed)(?::(-?[0-9]+))?$`)
+
+// ParseEvent parses a line from a panel as an Event. Enc, Abs and Speed need a value, the button events must not have one.
+func ParseEvent(line string) (Event, error) {
+	match := eventRegex.FindStringSubmatch(line)
+	if match == nil {
+		return Event{}, fmt.Errorf("not an event: %q", line)
+	}
+
+	event := Event{}
+	hwc, err := strconv.ParseUint(match[1], 10, 32)
+	if err != nil {
+		return Event{}, fmt.Errorf("HWC out of range: %q", line)
+	}
+	event.HWC = uint32(hwc)
+	if match[2] != "" {
+		edge, err := strconv.ParseUint(match[2], 10, 32)
+		if err != nil {
+			return Event{}, fmt.Errorf("edge out of range: %q", line)
+		}
+		event.Edge = uint32(edge)
+	}
+	for eventType, name := range eventTypeNames {
+		if name == match[3] {
+			event.Type = eventType
+		}
+	}
+
+	switch event.Type {
+	case Enc, Abs, Speed:
+		if match[4] == "" {
+			return Event{}, fmt.Errorf("%s event without a value: %q", event.Type, line)
+		}
+		if event.Value, err = strconv.Atoi(match[4]); err != nil {
+			return Event{}, fmt.Errorf("value out of range: %q", line)
+		}
+	default:
+		if match[4] != "" {
+			return Event{}, fmt.Errorf("%s event with a value: %q", event.Type, line)
+		}
+	}
+	return event, nil
+}
+
+// String returns the event in ASCII protocol syntax
+func (e Event) String() string {
+	line := "HWC#" + strconv.FormatUint(uint64(e.HWC), 10)
+	if e.Edge > 0 {
+		line += "." + strconv.FormatUint(uint64(e.Edge), 10)
+	}
+	line += "=" + e.Type.String()
+	switch e.Type {
+	case Enc, Abs, Speed:
+		line += ":" + strconv.Itoa(e.Value)
+	}
+	return line
+}
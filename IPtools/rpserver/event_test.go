@@ -0,0 +1,33 @@
+package rpserver
+
+import "testing"
+
+func TestParseEvent(t *testing.T) {
+	valid := map[string]Event{
+		"HWC#12=Down":     {HWC: 12, Type: Down},
+		"HWC#12.2=Up":     {HWC: 12, Edge: 2, Type: Up},
+		"HWC#3=Press":     {HWC: 3, Type: Press},
+		"HWC#4=Enc:-2":    {HWC: 4, Type: Enc, Value: -2},
+		"HWC#5=Abs:1000":  {HWC: 5, Type: Abs, Value: 1000},
+		"HWC#6=Speed:-20": {HWC: 6, Type: Speed, Value: -20},
+	}
+	for line, expected := range valid {
+		event, err := ParseEvent(line)
+		if err != nil {
+			t.Errorf("%s: %v", line, err)
+			continue
+		}
+		if event != expected {
+			t.Errorf("%s: expected %+v, got %+v", line, expected, event)
+		}
+		if event.String() != line {
+			t.Errorf("%s: printed as %s", line, event.String())
+		}
+	}
+
+	for _, line := range []string{"HWC#12=Enc", "HWC#12=Down:1", "HWC#12=Left", "HWC#=Down", "HWC#99999999999=Down", "map=1:2"} {
+		if event, err := ParseEvent(line); err == nil {
+			t.Errorf("%s: expected an error, got %+v", line, event)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+/*
+Package rpserver is a small framework for systems which Raw Panels in client mode connect to with the ASCII protocol.
+
+The Server accepts the panels and answers the protocol itself: It activates each panel on "list", asks for the topology,
+answers "ping" and queues what is sent while a panel has said BSY until it says RDY. What a system does is up to a Handler:
+
+	type system struct {
+		rpserver.BaseHandler
+	}
+
+	func (system) OnEvent(s *rpserver.Session, event rpserver.Event) {
+		if event.Type == rpserver.Down {
+			s.Send(fmt.Sprintf("HWC#%d=4", event.HWC))
+		}
+	}
+
+	rpserver.NewServer(system{}).ListenAndServe(":9923")
+*/
+package rpserver
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	helpers "github.com/SKAARHOJ/rawpanel-lib"
+)
+
+// Handler is called for what happens on the sessions of a Server. The calls for a session come from its own goroutine, in order.
+type Handler interface {
+	OnConnect(s *Session)
+	OnTopology(s *Session, topology helpers.Topology)
+	OnEvent(s *Session, event Event)
+	OnDisconnect(s *Session, err error) // err is nil if the panel disconnected
+}
+
+// BaseHandler does nothing. Embed it in a Handler to implement only the calls needed.
+type BaseHandler struct{}
+
+func (BaseHandler) OnConnect(s *Session)                             {}
+func (BaseHandler) OnTopology(s *Session, topology helpers.Topology) {}
+func (BaseHandler) OnEvent(s *Session, event Event)                  {}
+func (BaseHandler) OnDisconnect(s *Session, err error)               {}
+
+// Server accepts panels and runs a Session for each
+type Server struct {
+	handler Handler
+	quiet   atomic.Bool
+
+	mu       sync.Mutex
+	lastID   int
+	sessions map[int]*Session
+}
+
+// NewServer returns a Server calling handler. The lines sent and received are printed until SetQuiet.
+func NewServer(handler Handler) *Server {
+	return &Server{handler: handler, sessions: make(map[int]*Session)}
+}
+
+// ListenAndServe listens for panels on the TCP address addr, like ":9923"
+func (server *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp4", addr)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	return server.Serve(l)
+}
+
+// Serve accepts panels on l until it fails
+func (server *Server) Serve(l net.Listener) error {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go server.serveConn(c)
+	}
+}
+
+func (server *Server) serveConn(c net.Conn) {
+	server.mu.Lock()
+	server.lastID++
+	s := newSession(server.lastID, server, c)
+	server.sessions[s.ID] = s
+	count := len(server.sessions)
+	server.mu.Unlock()
+
+	fmt.Printf("System: New Connection from: %s (connections: %d)\n", c.RemoteAddr(), count)
+	server.handler.OnConnect(s)
+	err := s.run(server.handler)
+	c.Close()
+
+	server.mu.Lock()
+	delete(server.sessions, s.ID)
+	count = len(server.sessions)
+	server.mu.Unlock()
+
+	if err != nil {
+		fmt.Printf("System: %s disconnected: %v (connections: %d)\n", c.RemoteAddr(), err, count)
+	} else {
+		fmt.Printf("System: %s disconnected (connections: %d)\n", c.RemoteAddr(), count)
+	}
+	server.handler.OnDisconnect(s, err)
+}
+
+// Sessions returns the connected panels, oldest first
+func (server *Server) Sessions() []*Session {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	sessions := make([]*Session, 0, len(server.sessions))
+	for _, s := range server.sessions {
+		sessions = append(sessions, s)
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].ID < sessions[j].ID })
+	return sessions
+}
+
+// Broadcast sends lines to all connected panels
+func (server *Server) Broadcast(lines ...string) {
+	for _, s := range server.Sessions() {
+		s.Send(lines...)
+	}
+}
+
+// SetQuiet stops (or starts again) printing the lines sent and received
+func (server *Server) SetQuiet(quiet bool) {
+	server.quiet.Store(quiet)
+}
+
+// Quiet tells if the lines sent and received are not printed
+func (server *Server) Quiet() bool {
+	return server.quiet.Load()
+}
+
+// print prints a line sent ("< ") or received ("> ") on a session, unless quiet
+func (server *Server) print(s *Session, direction string, line string) {
+	if !server.Quiet() {
+		fmt.Printf("%-21v%s%s\n", s.RemoteAddr().String(), direction, line)
+	}
+}
@@ -0,0 +1,155 @@
+package rpserver
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	helpers "github.com/SKAARHOJ/rawpanel-lib"
+)
+
+var mapRegex = regexp.MustCompile(`^map=([0-9]+):([0-9]+)$`)
+
+// Session is a panel connected to a Server
+type Session struct {
+	ID     int // Counts from 1 for each Server
+	server *Server
+	conn   net.Conn
+
+	// Set by the session's own goroutine, before the handler is called:
+	Topology helpers.Topology // The last topology sent by the panel
+	HWCMap   map[int]int      // The HWC numbers the panel reported with "map=panel:server" lines
+
+	mu          sync.Mutex
+	busy        bool
+	queue       []string
+	initialized bool
+}
+
+func newSession(id int, server *Server, conn net.Conn) *Session {
+	return &Session{ID: id, server: server, conn: conn, HWCMap: make(map[int]int)}
+}
+
+// RemoteAddr is the address of the panel
+func (s *Session) RemoteAddr() net.Addr {
+	return s.conn.RemoteAddr()
+}
+
+// Send sends lines in ASCII protocol syntax to the panel. While the panel has said BSY, the lines are queued until it says RDY.
+// Send is safe to call from any goroutine.
+func (s *Session) Send(lines ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.busy {
+		s.queue = append(s.queue, lines...)
+		return nil
+	}
+	return s.write(lines)
+}
+
+// Busy tells if the panel has said BSY and not RDY since
+func (s *Session) Busy() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.busy
+}
+
+// Close disconnects the panel
+func (s *Session) Close() error {
+	return s.conn.Close()
+}
+
+// reply sends protocol answers at once, even while the panel is busy: They answer what it just sent, and a queued ack would look like a lost ping
+func (s *Session) reply(lines ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.write(lines)
+}
+
+// write sends lines, s.mu must be held
+func (s *Session) write(lines []string) error {
+	for _, line := range lines {
+		s.server.print(s, "< ", line)
+		if _, err := s.conn.Write([]byte(line + "\n")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setBusy handles BSY and RDY. The lines queued while busy are sent on RDY.
+func (s *Session) setBusy(busy bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.busy = busy
+	if busy || len(s.queue) == 0 {
+		return nil
+	}
+	queue := s.queue
+	s.queue = nil
+	return s.write(queue)
+}
+
+// run reads lines from the panel until the connection fails, and returns nil if the panel disconnected
+func (s *Session) run(handler Handler) error {
+	reader := bufio.NewReader(s.conn) // One reader for the connection, so nothing buffered is lost between lines (like the JSON of the topology)
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		line = strings.TrimSpace(line)
+		s.server.print(s, "> ", line)
+
+		if err := s.handle(line, handler); err != nil {
+			return err
+		}
+	}
+}
+
+// handle answers the protocol lines itself and passes topology and events on to handler. Only what the handler sends is queued while busy.
+func (s *Session) handle(line string, handler Handler) error {
+	switch {
+	case line == "list":
+		lines := []string{"", "ActivePanel=1", "list"}
+		if !s.initialized {
+			lines = append(lines, "PanelTopology?")
+			s.initialized = true
+		}
+		return s.reply(lines...)
+	case line == "ping":
+		return s.reply("ack")
+	case line == "ack":
+	case line == "BSY":
+		return s.setBusy(true)
+	case line == "RDY":
+		return s.setBusy(false)
+	case strings.HasPrefix(line, "_panelTopology_HWC="):
+		s.Topology = helpers.ParseTopology(strings.TrimPrefix(line, "_panelTopology_HWC="))
+		handler.OnTopology(s, s.Topology)
+	case mapRegex.MatchString(line):
+		match := mapRegex.FindStringSubmatch(line)
+		panelHWC, _ := strconv.Atoi(match[1])
+		serverHWC, _ := strconv.Atoi(match[2])
+		s.HWCMap[panelHWC] = serverHWC
+	case strings.HasPrefix(line, "HWC#"):
+		event, err := ParseEvent(line)
+		if err != nil {
+			fmt.Println(err)
+			break
+		}
+		handler.OnEvent(s, event)
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package rpserver
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	helpers "github.com/SKAARHOJ/rawpanel-lib"
+)
+
+type recordingHandler struct {
+	BaseHandler
+	topology chan helpers.Topology
+	events   chan Event
+}
+
+func (h *recordingHandler) OnTopology(s *Session, topology helpers.Topology) { h.topology <- topology }
+func (h *recordingHandler) OnEvent(s *Session, event Event)                  { h.events <- event }
+
+func TestSession(t *testing.T) {
+	handler := &recordingHandler{topology: make(chan helpers.Topology, 1), events: make(chan Event, 1)}
+	server := NewServer(handler)
+	server.SetQuiet(true)
+
+	panel, system := net.Pipe()
+	defer panel.Close()
+	go server.serveConn(system)
+	reader := bufio.NewReader(panel)
+
+	send := func(line string) {
+		t.Helper()
+		panel.SetWriteDeadline(time.Now().Add(time.Second))
+		if _, err := panel.Write([]byte(line + "\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expect := func(lines ...string) {
+		t.Helper()
+		for _, expected := range lines {
+			panel.SetReadDeadline(time.Now().Add(time.Second))
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatalf("expected %q: %v", expected, err)
+			}
+			if strings.TrimSuffix(line, "\n") != expected {
+				t.Fatalf("expected %q, got %q", expected, line)
+			}
+		}
+	}
+
+	send("list")
+	expect("", "ActivePanel=1", "list", "PanelTopology?")
+	send("list")
+	expect("", "ActivePanel=1", "list")
+
+	send(`_panelTopology_HWC={"HWc":[{"id":1,"type":2}],"typeIndex":{"2":{"w":10}}}`)
+	if topology := <-handler.topology; len(topology.HWc) != 1 || topology.HWc[0].Id != 1 || topology.TypeIndex[2].W != 10 {
+		t.Errorf("unexpected topology %+v", topology)
+	}
+
+	send("HWC#7=Enc:3")
+	if event := <-handler.events; event != (Event{HWC: 7, Type: Enc, Value: 3}) {
+		t.Errorf("unexpected event %+v", event)
+	}
+
+	// Lines sent while busy wait for RDY, protocol answers don't:
+	send("BSY")
+	sessions := server.Sessions()
+	if len(sessions) != 1 {
+		t.Fatalf("expected one session, got %d", len(sessions))
+	}
+	for !sessions[0].Busy() {
+		time.Sleep(time.Millisecond)
+	}
+	if err := sessions[0].Send("HWC#1=4", "HWC#2=4"); err != nil {
+		t.Fatal(err)
+	}
+	send("ping")
+	expect("ack")
+	send("RDY")
+	expect("HWC#1=4", "HWC#2=4")
+}
@@ -0,0 +1,18 @@
+#!/bin/sh
+
+GOOS=darwin GOARCH=arm64 go build -o binaries/RawPanelReplay.Mac-arm64-m1
+GOOS=darwin GOARCH=amd64 go build -o binaries/RawPanelReplay.Mac-x86-intel
+GOOS=windows GOARCH=amd64 go build -o binaries/RawPanelReplay.Win-amd64.exe
+GOOS=windows GOARCH=386 go build -o binaries/RawPanelReplay.Win-386.exe
+GOOS=linux GOARCH=amd64 go build -o binaries/RawPanelReplay.Linux-amd64
+GOOS=linux GOARCH=386 go build -o binaries/RawPanelReplay.Linux-386
+
+cd binaries
+
+zip RawPanelReplay.Mac.zip RawPanelReplay.Mac-arm64-m1 RawPanelReplay.Mac-x86-intel 
+zip RawPanelReplay.Win.zip RawPanelReplay.Win-amd64.exe RawPanelReplay.Win-386.exe 
+zip RawPanelReplay.Linux.zip RawPanelReplay.Linux-amd64 RawPanelReplay.Linux-386
+
+rm RawPanelReplay.Win-amd64.exe RawPanelReplay.Win-386.exe RawPanelReplay.Linux-amd64 RawPanelReplay.Linux-386 RawPanelReplay.Mac-arm64-m1 RawPanelReplay.Mac-x86-intel
+
+cd ..
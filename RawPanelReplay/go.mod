@@ -0,0 +1,24 @@
+module RawPanelReplay
+
+go 1.21
+
+require (
+	github.com/SKAARHOJ/rawpanel-lib v1.4.0
+	google.golang.org/protobuf v1.36.3
+	rwptransport v0.0.0
+)
+
+require (
+	github.com/SKAARHOJ/ibeam-lib-utils v1.0.0 // indirect
+	github.com/antchfx/xpath v1.2.4 // indirect
+	github.com/mattn/go-colorable v0.1.13 // indirect
+	github.com/mattn/go-isatty v0.0.20 // indirect
+	github.com/s00500/env_logger v0.1.29 // indirect
+	github.com/sirupsen/logrus v1.9.3 // indirect
+	github.com/subchen/go-xmldom v1.1.2 // indirect
+	go.uber.org/atomic v1.11.0 // indirect
+	golang.org/x/exp v0.0.0-20230728194245-b0cb94b80691 // indirect
+	golang.org/x/sys v0.20.0 // indirect
+)
+
+replace rwptransport => ../rwptransport
@@ -0,0 +1,202 @@
+/*
+Raw Panel Replay
+Plays a session log (written by the -sessionLog option of the connectors and test tools) back against a panel or a system.
+
+Against a panel, the messages the system sent ("in") are replayed and this tool acts as the system.
+Against a system in server mode, the messages the panel sent ("out") are replayed and this tool acts as the panel.
+Timing is kept as in the log, optionally accelerated with -speed.
+
+Distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+without even the implied warranty of MERCHANTABILITY or FITNESS FOR A
+PARTICULAR PURPOSE. MIT License
+*/
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	helpers "github.com/SKAARHOJ/rawpanel-lib"
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+
+	"google.golang.org/protobuf/proto"
+
+	"rwptransport"
+)
+
+// flowOnly returns true for messages that are nothing but a ping, ack or similar. Those belong to the original connection and are not replayed by default.
+func flowOnly(msg proto.Message) bool {
+	clone := proto.Clone(msg)
+	switch m := clone.(type) {
+	case *rwp.InboundMessage:
+		if m.FlowMessage == 0 {
+			return false
+		}
+		m.FlowMessage = 0
+	case *rwp.OutboundMessage:
+		if m.FlowMessage == 0 {
+			return false
+		}
+		m.FlowMessage = 0
+	}
+	return proto.Size(clone) == 0
+}
+
+// wait sleeps until the entry is due, relative to the first replayed entry. Returns false if ctx is done first.
+func wait(ctx context.Context, entryTime time.Time, firstEntry time.Time, start time.Time, speed float64) bool {
+	if speed <= 0 {
+		return ctx.Err() == nil
+	}
+	due := start.Add(time.Duration(float64(entryTime.Sub(firstEntry)) / speed))
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(time.Until(due)):
+		return true
+	}
+}
+
+func main() {
+
+	// Setting up and parsing command line parameters
+	target := flag.String("to", "panel", "What to replay against: 'panel' (replays messages sent to the panel) or 'system' (replays messages sent by the panel)")
+	binary := flag.Bool("binary", false, "Connects in binary mode (-binary=false for ASCII). If not given, the encoding of a panel is detected, systems use ASCII")
+	speed := flag.Float64("speed", 1, "Replay speed: 1 is the original timing, 10 is ten times faster, 0 sends everything as fast as possible")
+	peer := flag.String("peer", "", "Only replay messages exchanged with this address, for logs with several panels")
+	flow := flag.Bool("flow", false, "Also replay pings, acks and other flow messages")
+	verbose := flag.Bool("verbose", false, "Show replayed messages and the replies")
+	flag.Parse()
+
+	arguments := flag.Args()
+	if len(arguments) < 2 || (*target != "panel" && *target != "system") {
+		fmt.Println("usage: RawPanelReplay [-to panel|system -speed 1 -peer IP:port] [session log] [IP:port]")
+		fmt.Println("help:  RawPanelReplay -h")
+		fmt.Println("")
+		return
+	}
+
+	file, err := os.Open(arguments[0])
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	defer file.Close()
+	reader := rwptransport.NewLogReader(file)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var connectedOnce sync.Once
+	connected := make(chan struct{})
+	config := rwptransport.Config{
+		Encoding:       rwptransport.EncodingFromFlag(*binary),
+		DetectEncoding: *target == "panel" && !rwptransport.FlagPassed("binary"),
+		OnEvent: func(e rwptransport.Event) {
+			switch e.Type {
+			case rwptransport.Connected:
+				fmt.Println("Connected to " + *target + " on " + e.Addr + " (" + e.Encoding.String() + ")")
+				connectedOnce.Do(func() { close(connected) })
+			case rwptransport.Disconnected:
+				if ctx.Err() == nil {
+					fmt.Println("Disconnected from "+*target+", messages are lost until reconnected:", e.Err)
+				}
+			case rwptransport.DialFailed:
+				fmt.Println(e.Err)
+			case rwptransport.ReadError:
+				fmt.Println(e.Err)
+			}
+		},
+	}
+
+	// Set up the connection and a function to send a log entry on it:
+	var send func(entry *rwptransport.LogEntry) bool
+	if *target == "panel" {
+		toPanel := make(chan []*rwp.InboundMessage, 10)
+		fromPanel := make(chan []*rwp.OutboundMessage, 10)
+		go rwptransport.DialPanel(ctx, arguments[1], toPanel, fromPanel, config)
+		go func() {
+			for msgs := range fromPanel {
+				if *verbose {
+					for _, line := range helpers.OutboundMessagesToRawPanelASCIIstrings(msgs) {
+						fmt.Println("Panel -> Replay: " + line)
+					}
+				}
+			}
+		}()
+		send = func(entry *rwptransport.LogEntry) bool {
+			if entry.Inbound == nil || (!*flow && flowOnly(entry.Inbound)) {
+				return false
+			}
+			if *verbose {
+				for _, line := range helpers.InboundMessagesToRawPanelASCIIstrings([]*rwp.InboundMessage{entry.Inbound}) {
+					fmt.Println("Replay -> Panel: " + line)
+				}
+			}
+			toPanel <- []*rwp.InboundMessage{entry.Inbound}
+			return true
+		}
+	} else {
+		toSystem := make(chan []*rwp.OutboundMessage, 10)
+		fromSystem := make(chan []*rwp.InboundMessage, 10)
+		go rwptransport.DialSystem(ctx, arguments[1], toSystem, fromSystem, config)
+		go func() {
+			for msgs := range fromSystem {
+				if *verbose {
+					for _, line := range helpers.InboundMessagesToRawPanelASCIIstrings(msgs) {
+						fmt.Println("System -> Replay: " + line)
+					}
+				}
+			}
+		}()
+		send = func(entry *rwptransport.LogEntry) bool {
+			if entry.Outbound == nil || (!*flow && flowOnly(entry.Outbound)) {
+				return false
+			}
+			if *verbose {
+				for _, line := range helpers.OutboundMessagesToRawPanelASCIIstrings([]*rwp.OutboundMessage{entry.Outbound}) {
+					fmt.Println("Replay -> System: " + line)
+				}
+			}
+			toSystem <- []*rwp.OutboundMessage{entry.Outbound}
+			return true
+		}
+	}
+
+	fmt.Println("Trying to connect to " + *target + " on " + arguments[1] + "...")
+	<-connected
+
+	var start, firstEntry time.Time
+	count := 0
+	for {
+		entry, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if *peer != "" && entry.Peer != *peer {
+			continue
+		}
+
+		if firstEntry.IsZero() {
+			firstEntry = entry.Time
+			start = time.Now()
+		}
+		if !wait(ctx, entry.Time, firstEntry, start, *speed) {
+			break
+		}
+		if send(entry) {
+			count++
+		}
+	}
+
+	time.Sleep(time.Second) // Let the last messages get out and replies come in
+	fmt.Printf("Replayed %d messages in %v\n", count, time.Since(start).Round(time.Millisecond))
+}
@@ -0,0 +1,18 @@
+#!/bin/sh
+
+GOOS=darwin GOARCH=arm64 go build -o binaries/RawPanelSimulator.Mac-arm64-m1
+GOOS=darwin GOARCH=amd64 go build -o binaries/RawPanelSimulator.Mac-x86-intel
+GOOS=windows GOARCH=amd64 go build -o binaries/RawPanelSimulator.Win-amd64.exe
+GOOS=windows GOARCH=386 go build -o binaries/RawPanelSimulator.Win-386.exe
+GOOS=linux GOARCH=amd64 go build -o binaries/RawPanelSimulator.Linux-amd64
+GOOS=linux GOARCH=386 go build -o binaries/RawPanelSimulator.Linux-386
+
+cd binaries
+
+zip RawPanelSimulator.Mac.zip RawPanelSimulator.Mac-arm64-m1 RawPanelSimulator.Mac-x86-intel 
+zip RawPanelSimulator.Win.zip RawPanelSimulator.Win-amd64.exe RawPanelSimulator.Win-386.exe 
+zip RawPanelSimulator.Linux.zip RawPanelSimulator.Linux-amd64 RawPanelSimulator.Linux-386
+
+rm RawPanelSimulator.Win-amd64.exe RawPanelSimulator.Win-386.exe RawPanelSimulator.Linux-amd64 RawPanelSimulator.Linux-386 RawPanelSimulator.Mac-arm64-m1 RawPanelSimulator.Mac-x86-intel
+
+cd ..
@@ -0,0 +1,275 @@
+/*
+Raw Panel Simulator
+Pretends to be a Raw Panel in server mode, built from a topology JSON file, so the other tools can be run without hardware.
+Accepts ASCII and binary systems (detected per connection) and any number of them at the same time.
+
+Answers ActivatePanel, SendPanelInfo, SendPanelTopology, ReportHWCavailability and pings, keeps the state of each HWC
+and sends events from a script file (see script.go) or typed on the console.
+
+Distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+without even the implied warranty of MERCHANTABILITY or FITNESS FOR A
+PARTICULAR PURPOSE. MIT License
+*/
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	helpers "github.com/SKAARHOJ/rawpanel-lib"
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+	log "github.com/s00500/env_logger"
+
+	"rwptransport"
+)
+
+// Panel centric view:
+// Inbound TCP commands - from external system to SKAARHOJ panel
+// Outbound TCP commands - from panel to external system
+
+// session is one connected system
+type session struct {
+	addr     string
+	ctx      context.Context
+	toSystem chan []*rwp.OutboundMessage
+	verbose  bool
+}
+
+func (s *session) send(msgs []*rwp.OutboundMessage) {
+	if s.verbose {
+		for _, line := range helpers.OutboundMessagesToRawPanelASCIIstrings(msgs) {
+			log.Println("Panel -> " + s.addr + ": " + line)
+		}
+	}
+	select {
+	case s.toSystem <- msgs:
+	case <-s.ctx.Done():
+	}
+}
+
+type simulator struct {
+	panel   *Panel
+	script  []scriptStep
+	verbose bool
+
+	activeSessions map[*session]bool // Systems that have activated the panel receive events
+	mu             sync.Mutex
+}
+
+// serve runs a connection from a system until it disconnects
+func (sim *simulator) serve(conn net.Conn) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := &session{
+		addr:     conn.RemoteAddr().String(),
+		ctx:      ctx,
+		toSystem: make(chan []*rwp.OutboundMessage, 10),
+		verbose:  sim.verbose,
+	}
+	fromSystem := make(chan []*rwp.InboundMessage, 10)
+
+	config := rwptransport.Config{
+		DetectEncoding: true,
+		OnEvent: func(e rwptransport.Event) {
+			switch e.Type {
+			case rwptransport.Connected:
+				log.Infof("System %s connected using %s encoding", e.Addr, e.Encoding)
+			case rwptransport.Disconnected:
+				log.Infof("System %s disconnected", e.Addr)
+			case rwptransport.ReadError:
+				log.Warnln("System "+e.Addr+":", e.Err)
+			}
+		},
+	}
+	go func() {
+		rwptransport.ServeSystem(ctx, conn, s.toSystem, fromSystem, config)
+		cancel()
+	}()
+
+	defer func() {
+		sim.mu.Lock()
+		delete(sim.activeSessions, s)
+		sim.mu.Unlock()
+	}()
+
+	heartBeat := time.NewTicker(time.Hour)
+	heartBeat.Stop()
+	defer heartBeat.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-heartBeat.C:
+			s.send([]*rwp.OutboundMessage{{FlowMessage: rwp.OutboundMessage_PING}})
+		case msgs := <-fromSystem:
+			if sim.verbose {
+				for _, line := range helpers.InboundMessagesToRawPanelASCIIstrings(msgs) {
+					log.Println(s.addr + " -> Panel: " + line)
+				}
+			}
+			for _, msg := range msgs {
+				sim.handleMessage(s, msg, heartBeat)
+			}
+		}
+	}
+}
+
+// handleMessage does what a panel would do with a message from a system
+func (sim *simulator) handleMessage(s *session, msg *rwp.InboundMessage, heartBeat *time.Ticker) {
+	if msg.FlowMessage == rwp.InboundMessage_PING {
+		s.send([]*rwp.OutboundMessage{{FlowMessage: rwp.OutboundMessage_ACK}})
+	}
+
+	if msg.Command != nil {
+		if msg.Command.ActivatePanel {
+			sim.mu.Lock()
+			alreadyActive := sim.activeSessions[s]
+			sim.activeSessions[s] = true
+			sim.mu.Unlock()
+
+			if !alreadyActive && len(sim.script) > 0 {
+				log.Infoln("Playing event script to " + s.addr)
+				go playScript(s.ctx, sim.script, s.send)
+			}
+		}
+		if msg.Command.SetHeartBeatTimer != nil {
+			if msg.Command.SetHeartBeatTimer.Value > 0 {
+				heartBeat.Reset(time.Duration(msg.Command.SetHeartBeatTimer.Value) * time.Millisecond)
+			} else {
+				heartBeat.Stop()
+			}
+		}
+		if replies := sim.panel.HandleCommand(msg.Command); len(replies) > 0 {
+			s.send(replies)
+		}
+	}
+
+	for _, state := range msg.States {
+		if unknown := sim.panel.ApplyState(state); len(unknown) > 0 {
+			log.Warnf("%s: State for HWCs not in topology: %v", s.addr, unknown)
+		}
+		if !sim.verbose {
+			logState(s.addr, state)
+		}
+	}
+}
+
+// broadcast sends events to all systems which have activated the panel
+func (sim *simulator) broadcast(msgs []*rwp.OutboundMessage) int {
+	sim.mu.Lock()
+	sessions := make([]*session, 0, len(sim.activeSessions))
+	for s := range sim.activeSessions {
+		sessions = append(sessions, s)
+	}
+	sim.mu.Unlock()
+
+	for _, s := range sessions {
+		s.send(msgs)
+	}
+	return len(sessions)
+}
+
+// console reads events and commands typed by the user
+func (sim *simulator) console() {
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch line {
+		case "":
+		case "state":
+			for _, stateLine := range sim.panel.DumpState() {
+				fmt.Println(stateLine)
+			}
+		case "help":
+			fmt.Println("Type an event in ASCII protocol syntax (eg. HWC#1=Press, HWC#2=Enc:-1, HWC#3=Abs:500, HWC#4=Speed:-200)")
+			fmt.Println("or 'state' to print the current state of all HWCs")
+		default:
+			events, err := sim.panel.ParseEvents(line)
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+			fmt.Printf("Sent to %d system(s)\n", sim.broadcast(events))
+		}
+	}
+}
+
+func main() {
+
+	// Setting up and parsing command line parameters
+	port := flag.Int("port", 9923, "Port to accept system connections on")
+	scriptFile := flag.String("script", "", "File with events to play to each system once it has activated the panel")
+	model := flag.String("model", "SIM", "Model reported in PanelInfo")
+	serial := flag.String("serial", "00000000", "Serial number reported in PanelInfo")
+	verbose := flag.Bool("verbose", false, "Show all messages to and from systems")
+	flag.Parse()
+
+	arguments := flag.Args()
+	if len(arguments) == 0 {
+		fmt.Println("usage: RawPanelSimulator [-port 9923 -script events.txt] [topology.json]")
+		fmt.Println("help:  RawPanelSimulator -h")
+		fmt.Println("")
+		return
+	}
+
+	panel, err := NewPanel(arguments[0], &rwp.PanelInfo{
+		Model:     *model,
+		Serial:    *serial,
+		Platform:  "simulator",
+		PanelType: rwp.PanelInfo_EMULATION,
+	})
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	panel.Info.Name = panel.Topology.Title
+
+	sim := &simulator{
+		panel:          panel,
+		verbose:        *verbose,
+		activeSessions: make(map[*session]bool),
+	}
+	if *scriptFile != "" {
+		sim.script, err = loadScript(*scriptFile, panel)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	l, err := net.Listen("tcp", fmt.Sprintf(":%d", *port))
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	defer l.Close()
+
+	// Welcome message!
+	fmt.Println("Welcome to Raw Panel Simulator!")
+	fmt.Println("Configuration:")
+	fmt.Println("  topology: ", arguments[0], "with", len(panel.Availability()), "HWCs")
+	fmt.Println("  script:   ", *scriptFile, "with", len(sim.script), "steps")
+	fmt.Println("Ready to accept TCP connections from systems on port", *port)
+	fmt.Println("Type 'help' for console commands")
+	fmt.Println("")
+
+	go sim.console()
+
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		go sim.serve(c)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	helpers "github.com/SKAARHOJ/rawpanel-lib"
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+	"github.com/SKAARHOJ/rawpanel-lib/topology"
+	log "github.com/s00500/env_logger"
+
+	"google.golang.org/protobuf/proto"
+
+	"rwptransport"
+)
+
+// Panel is the simulated hardware: Its topology and the state of each HWC as set by the connected systems.
+// Like a real panel, the state is shared between all system connections.
+type Panel struct {
+	Info         *rwp.PanelInfo
+	TopologyJSON string
+	Topology     *topology.Topology
+
+	states *rwptransport.StateCache
+}
+
+// NewPanel loads the topology from a JSON file in the format sent by panels in PanelTopology.Json
+func NewPanel(topologyFile string, info *rwp.PanelInfo) (*Panel, error) {
+	jsonData, err := os.ReadFile(topologyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	topo := &topology.Topology{}
+	if err := json.Unmarshal(jsonData, topo); err != nil {
+		return nil, fmt.Errorf("parsing topology %s: %w", topologyFile, err)
+	}
+	if len(topo.HWc) == 0 {
+		return nil, fmt.Errorf("topology %s has no hardware components", topologyFile)
+	}
+
+	// Line breaks would break the topology up in ASCII mode:
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, jsonData); err != nil {
+		return nil, err
+	}
+
+	return &Panel{
+		Info:         info,
+		TopologyJSON: compact.String(),
+		Topology:     topo,
+		states:       rwptransport.NewStateCache(),
+	}, nil
+}
+
+// HasHWC returns true if the HWC is in the topology and enabled (type non-zero)
+func (panel *Panel) HasHWC(hwc uint32) bool {
+	for _, HWcDef := range panel.Topology.HWc {
+		if HWcDef.Id == hwc {
+			return HWcDef.Type != 0
+		}
+	}
+	return false
+}
+
+// Availability returns the HWCavailability map reported in response to ReportHWCavailability. All enabled HWCs are mapped to themselves.
+func (panel *Panel) Availability() map[uint32]uint32 {
+	availability := make(map[uint32]uint32)
+	for _, HWcDef := range panel.Topology.HWc {
+		if HWcDef.Type != 0 {
+			availability[HWcDef.Id] = HWcDef.Id
+		}
+	}
+	return availability
+}
+
+// HandleCommand returns the replies a real panel would give to a command and applies clear commands to the state
+func (panel *Panel) HandleCommand(cmd *rwp.Command) []*rwp.OutboundMessage {
+	replies := []*rwp.OutboundMessage{}
+
+	if cmd.SendPanelInfo {
+		replies = append(replies, &rwp.OutboundMessage{PanelInfo: panel.Info})
+	}
+	if cmd.SendPanelTopology {
+		replies = append(replies, &rwp.OutboundMessage{PanelTopology: &rwp.PanelTopology{Json: panel.TopologyJSON}})
+	}
+	if cmd.ReportHWCavailability {
+		replies = append(replies, &rwp.OutboundMessage{HWCavailability: panel.Availability()})
+	}
+
+	if cmd.ClearAll || cmd.ClearLEDs || cmd.ClearDisplays {
+		panel.states.Update([]*rwp.InboundMessage{{Command: cmd}})
+	}
+
+	return replies
+}
+
+// ApplyState stores the parts of a state message which are set for each of the HWCs it addresses. Returns the HWCs not found in the topology.
+func (panel *Panel) ApplyState(state *rwp.HWCState) (unknown []uint32) {
+	known := make([]uint32, 0, len(state.HWCIDs))
+	for _, hwc := range state.HWCIDs {
+		if panel.HasHWC(hwc) {
+			known = append(known, hwc)
+		} else {
+			unknown = append(unknown, hwc)
+		}
+	}
+	if len(known) > 0 {
+		state = proto.Clone(state).(*rwp.HWCState)
+		state.HWCIDs = known
+		panel.states.Update([]*rwp.InboundMessage{{States: []*rwp.HWCState{state}}})
+	}
+	return unknown
+}
+
+// DumpState returns the current state of all HWCs in ASCII protocol syntax, sorted by HWC
+func (panel *Panel) DumpState() []string {
+	lines := []string{}
+	for _, msg := range panel.states.Messages() {
+		lines = append(lines, helpers.InboundMessagesToRawPanelASCIIstrings([]*rwp.InboundMessage{msg})...)
+	}
+	return lines
+}
+
+// ParseEvents converts a line in ASCII protocol syntax (eg. "HWC#12=Down" or "HWC#4=Enc:-2") to event messages.
+// Events for HWCs which are not in the topology are rejected.
+func (panel *Panel) ParseEvents(line string) ([]*rwp.OutboundMessage, error) {
+	msgs := helpers.RawPanelASCIIstringsToOutboundMessages([]string{line})
+	if len(msgs) == 0 || len(msgs[0].Events) == 0 {
+		return nil, fmt.Errorf("not an event: %q", line)
+	}
+	for _, msg := range msgs {
+		for _, event := range msg.Events {
+			if !panel.HasHWC(event.HWCID) {
+				return nil, fmt.Errorf("HWC %d is not in the topology: %q", event.HWCID, line)
+			}
+		}
+	}
+	return msgs, nil
+}
+
+// logState prints a state message in ASCII syntax, which is easier to read than JSON
+func logState(addr string, state *rwp.HWCState) {
+	for _, line := range helpers.InboundMessagesToRawPanelASCIIstrings([]*rwp.InboundMessage{{States: []*rwp.HWCState{state}}}) {
+		log.Infoln(addr + ": " + line)
+	}
+}
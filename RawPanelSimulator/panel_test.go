@@ -0,0 +1,83 @@
+package main
+
+import (
+	"testing"
+
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+)
+
+func newTestPanel(t *testing.T) *Panel {
+	t.Helper()
+	panel, err := NewPanel("example_topology.json", &rwp.PanelInfo{Model: "Simulator", Serial: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return panel
+}
+
+func TestPanelHandleCommand(t *testing.T) {
+	panel := newTestPanel(t)
+
+	replies := panel.HandleCommand(&rwp.Command{SendPanelInfo: true, SendPanelTopology: true, ReportHWCavailability: true})
+	if len(replies) != 3 {
+		t.Fatalf("expected 3 replies, got %v", replies)
+	}
+	if replies[0].PanelInfo.Model != "Simulator" {
+		t.Errorf("unexpected panel info %v", replies[0].PanelInfo)
+	}
+	if replies[1].PanelTopology.Json != panel.TopologyJSON {
+		t.Errorf("unexpected topology %v", replies[1].PanelTopology)
+	}
+	if replies[2].HWCavailability[5] != 5 {
+		t.Errorf("unexpected availability %v", replies[2].HWCavailability)
+	}
+	if replies := panel.HandleCommand(&rwp.Command{ActivatePanel: true}); len(replies) != 0 {
+		t.Errorf("expected no replies, got %v", replies)
+	}
+}
+
+func TestPanelClear(t *testing.T) {
+	panel := newTestPanel(t)
+	panel.ApplyState(&rwp.HWCState{HWCIDs: []uint32{1, 2}, HWCMode: &rwp.HWCMode{State: rwp.HWCMode_ON}})
+	panel.ApplyState(&rwp.HWCState{HWCIDs: []uint32{1}, HWCText: &rwp.HWCText{Title: "One"}})
+
+	// Clearing LEDs leaves the text of HWC 1 and nothing of HWC 2:
+	panel.HandleCommand(&rwp.Command{ClearLEDs: true})
+	if states := panel.states.States(); len(states) != 1 || states[0].HWCIDs[0] != 1 || states[0].HWCMode != nil || states[0].HWCText == nil {
+		t.Fatalf("unexpected states after ClearLEDs: %v", states)
+	}
+	panel.HandleCommand(&rwp.Command{ClearDisplays: true})
+	if states := panel.states.States(); len(states) != 0 {
+		t.Fatalf("expected no states after ClearDisplays, got %v", states)
+	}
+
+	panel.ApplyState(&rwp.HWCState{HWCIDs: []uint32{3}, HWCMode: &rwp.HWCMode{State: rwp.HWCMode_ON}, HWCText: &rwp.HWCText{Title: "Three"}})
+	panel.HandleCommand(&rwp.Command{ClearAll: true})
+	if lines := panel.DumpState(); len(lines) != 0 {
+		t.Fatalf("expected nothing to dump after ClearAll, got %v", lines)
+	}
+}
+
+func TestPanelDumpState(t *testing.T) {
+	panel := newTestPanel(t)
+	if lines := panel.DumpState(); len(lines) != 0 {
+		t.Fatalf("expected nothing to dump, got %v", lines)
+	}
+
+	unknown := panel.ApplyState(&rwp.HWCState{HWCIDs: []uint32{3, 99, 1}, HWCMode: &rwp.HWCMode{State: rwp.HWCMode_ON}})
+	if len(unknown) != 1 || unknown[0] != 99 {
+		t.Errorf("expected HWC 99 to be unknown, got %v", unknown)
+	}
+	panel.ApplyState(&rwp.HWCState{HWCIDs: []uint32{1}, HWCMode: &rwp.HWCMode{State: rwp.HWCMode_DIMMED}})
+
+	expected := []string{"HWC#1=5", "HWC#3=4"}
+	lines := panel.DumpState()
+	if len(lines) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, lines)
+	}
+	for i := range expected {
+		if lines[i] != expected[i] {
+			t.Errorf("line %d: expected %q, got %q", i, expected[i], lines[i])
+		}
+	}
+}
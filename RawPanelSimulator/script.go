@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+)
+
+/*
+Event scripts are text files with one step per line:
+
+	# Comments and blank lines are ignored
+	HWC#1=Down       Any event in ASCII protocol syntax: Down, Up, Press, Enc:x, Abs:x, Speed:x, also with edges (HWC#1.4=Down)
+	wait 500ms       Pause, in Go duration syntax
+	loop             Start over from the top
+
+The script is played to a system once it has activated the panel.
+*/
+
+// scriptStep is either a set of events to send, a pause or a jump back to the top
+type scriptStep struct {
+	events []*rwp.OutboundMessage
+	wait   time.Duration
+	loop   bool
+}
+
+// loadScript reads and validates an event script against the panel topology
+func loadScript(filename string, panel *Panel) ([]scriptStep, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	steps := []scriptStep{}
+	scanner := bufio.NewScanner(file)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		switch {
+		case line == "loop":
+			steps = append(steps, scriptStep{loop: true})
+		case strings.HasPrefix(line, "wait "):
+			wait, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(line, "wait ")))
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", filename, lineNumber, err)
+			}
+			steps = append(steps, scriptStep{wait: wait})
+		default:
+			events, err := panel.ParseEvents(line)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", filename, lineNumber, err)
+			}
+			steps = append(steps, scriptStep{events: events})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	// A loop without any pause would flood the system:
+	hasWait, hasLoop := false, false
+	for _, step := range steps {
+		hasWait = hasWait || step.wait > 0
+		hasLoop = hasLoop || step.loop
+	}
+	if hasLoop && !hasWait {
+		return nil, fmt.Errorf("%s: loop without any wait", filename)
+	}
+	return steps, nil
+}
+
+// playScript sends the events of the script until it ends or ctx is done
+func playScript(ctx context.Context, steps []scriptStep, send func([]*rwp.OutboundMessage)) {
+	for i := 0; i < len(steps); i++ {
+		step := steps[i]
+		switch {
+		case step.loop:
+			i = -1
+			if ctx.Err() != nil {
+				return
+			}
+		case step.wait > 0:
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(step.wait):
+			}
+		default:
+			send(step.events)
+		}
+	}
+}
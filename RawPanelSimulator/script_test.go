@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+)
+
+func writeScript(t *testing.T, lines ...string) string {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), "script.txt")
+	if err := os.WriteFile(filename, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestLoadScript(t *testing.T) {
+	panel := newTestPanel(t)
+
+	steps, err := loadScript("example_script.txt", panel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !steps[len(steps)-1].loop {
+		t.Errorf("expected the example to end with a loop, got %+v", steps[len(steps)-1])
+	}
+
+	steps, err = loadScript(writeScript(t, "# Comment", "", "  HWC#5=Enc:-2  ", "wait 250ms", "loop"), panel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(steps) != 3 {
+		t.Fatalf("expected 3 steps, got %+v", steps)
+	}
+	if events := steps[0].events; len(events) != 1 || events[0].Events[0].HWCID != 5 || events[0].Events[0].Pulsed.GetValue() != -2 {
+		t.Errorf("unexpected events %v", events)
+	}
+	if steps[1].wait != 250*time.Millisecond || !steps[2].loop {
+		t.Errorf("unexpected steps %+v", steps[1:])
+	}
+
+	invalid := map[string][]string{
+		"unknown HWC":       {"HWC#99=Down"},
+		"not an event":      {"HWC#1=4"},
+		"bad duration":      {"wait soon"},
+		"loop without wait": {"HWC#1=Press", "loop"},
+	}
+	for name, lines := range invalid {
+		if _, err := loadScript(writeScript(t, lines...), panel); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	if _, err := loadScript(filepath.Join(t.TempDir(), "missing.txt"), panel); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
+
+func TestPlayScript(t *testing.T) {
+	steps := []scriptStep{
+		{events: []*rwp.OutboundMessage{{Events: []*rwp.HWCEvent{{HWCID: 1}}}}},
+		{wait: time.Millisecond},
+		{loop: true},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	sent := 0
+	playScript(ctx, steps, func(msgs []*rwp.OutboundMessage) {
+		sent++
+		if sent == 3 {
+			cancel()
+		}
+	})
+	if sent != 3 {
+		t.Errorf("expected the script to loop until cancelled after 3 sends, got %d", sent)
+	}
+}
@@ -0,0 +1,18 @@
+#!/bin/sh
+
+GOOS=darwin GOARCH=arm64 go build -o binaries/RwpRouter.Mac-arm64-m1
+GOOS=darwin GOARCH=amd64 go build -o binaries/RwpRouter.Mac-x86-intel
+GOOS=windows GOARCH=amd64 go build -o binaries/RwpRouter.Win-amd64.exe
+GOOS=windows GOARCH=386 go build -o binaries/RwpRouter.Win-386.exe
+GOOS=linux GOARCH=amd64 go build -o binaries/RwpRouter.Linux-amd64
+GOOS=linux GOARCH=386 go build -o binaries/RwpRouter.Linux-386
+
+cd binaries
+
+zip RwpRouter.Mac.zip RwpRouter.Mac-arm64-m1 RwpRouter.Mac-x86-intel 
+zip RwpRouter.Win.zip RwpRouter.Win-amd64.exe RwpRouter.Win-386.exe 
+zip RwpRouter.Linux.zip RwpRouter.Linux-amd64 RwpRouter.Linux-386
+
+rm RwpRouter.Win-amd64.exe RwpRouter.Win-386.exe RwpRouter.Linux-amd64 RwpRouter.Linux-386 RwpRouter.Mac-arm64-m1 RwpRouter.Mac-x86-intel
+
+cd ..
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"rwptransport"
+)
+
+// Kinds of endpoints
+const (
+	Dial      = "rwp+tcp"    // Connects to a panel or system in server mode
+	Listen    = "rwp+listen" // Accepts a panel or system in client mode
+	WebSocket = "ws"         // Connects to a Raw Panel WebSocket server (ws:// or wss://)
+)
+
+// endpoint is one side of the router, parsed from a URI such as rwp+tcp://192.168.10.99:9923?enc=binary
+type endpoint struct {
+	uri      string
+	kind     string
+	addr     string // host:port for TCP endpoints, the full URL for WebSocket endpoints
+	encoding rwptransport.Encoding
+	detect   bool // Detect the encoding on each connection instead
+}
+
+// parseEndpoint parses the URI of the panel or system side.
+// The encoding is given with ?enc=binary, ascii or auto. The default is auto, except for systems in server mode, which don't talk first and default to ascii.
+func parseEndpoint(uri string, panel bool) (*endpoint, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	ep := &endpoint{uri: uri}
+	switch u.Scheme {
+	case Dial, Listen:
+		ep.kind = u.Scheme
+		ep.addr = u.Host
+		if u.Port() == "" {
+			ep.addr = net.JoinHostPort(u.Hostname(), "9923")
+		}
+		if ep.kind == Dial && u.Hostname() == "" {
+			return nil, fmt.Errorf("%s: host missing", uri)
+		}
+	case "ws", "wss":
+		if panel {
+			return nil, fmt.Errorf("%s: WebSocket endpoints are only supported on the system side, a Raw Panel WebSocket server takes the role of a system", uri)
+		}
+		ep.kind = WebSocket
+		ep.addr = uri
+		return ep, nil
+	default:
+		return nil, fmt.Errorf("%s: unknown scheme %q, expected rwp+tcp, rwp+listen, ws or wss", uri, u.Scheme)
+	}
+
+	enc := strings.ToLower(u.Query().Get("enc"))
+	if enc == "" {
+		enc = "auto"
+		if !panel && ep.kind == Dial {
+			enc = "ascii"
+		}
+	}
+	switch enc {
+	case "ascii":
+		ep.encoding = rwptransport.ASCII
+	case "binary":
+		ep.encoding = rwptransport.Binary
+	case "auto":
+		if !panel && ep.kind == Dial {
+			return nil, fmt.Errorf("%s: the encoding of a system in server mode can't be detected, use enc=ascii or enc=binary", uri)
+		}
+		ep.detect = true
+	default:
+		return nil, fmt.Errorf("%s: unknown encoding %q, expected ascii, binary or auto", uri, enc)
+	}
+	return ep, nil
+}
+
+// serverMode tells if the peer is in server mode, so the router connects to it
+func (ep *endpoint) serverMode() bool {
+	return ep.kind != Listen
+}
+
+func (ep *endpoint) String() string {
+	switch {
+	case ep.kind == WebSocket:
+		return ep.addr + " (WebSocket, JSON)"
+	case ep.detect:
+		return ep.kind + " " + ep.addr + " (auto detect)"
+	}
+	return ep.kind + " " + ep.addr + " (" + ep.encoding.String() + ")"
+}
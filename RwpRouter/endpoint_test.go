@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+
+	"rwptransport"
+)
+
+func TestParseEndpoint(t *testing.T) {
+	tests := []struct {
+		uri      string
+		panel    bool
+		kind     string
+		addr     string
+		encoding rwptransport.Encoding
+		detect   bool
+	}{
+		{"rwp+tcp://192.168.10.99", true, Dial, "192.168.10.99:9923", rwptransport.ASCII, true},
+		{"rwp+tcp://192.168.10.99:9924?enc=binary", true, Dial, "192.168.10.99:9924", rwptransport.Binary, false},
+		{"rwp+tcp://localhost:9923", false, Dial, "localhost:9923", rwptransport.ASCII, false},
+		{"rwp+listen://:9924?enc=auto", false, Listen, ":9924", rwptransport.ASCII, true},
+		{"wss://example.com/rwp", false, WebSocket, "wss://example.com/rwp", rwptransport.ASCII, false},
+	}
+	for _, test := range tests {
+		ep, err := parseEndpoint(test.uri, test.panel)
+		if err != nil {
+			t.Errorf("%s: %v", test.uri, err)
+			continue
+		}
+		if ep.kind != test.kind || ep.addr != test.addr || ep.encoding != test.encoding || ep.detect != test.detect {
+			t.Errorf("%s: unexpected endpoint %+v", test.uri, ep)
+		}
+	}
+
+	for _, bad := range []struct {
+		uri   string
+		panel bool
+	}{
+		{"ws://localhost:8080/ws", true}, // No WebSocket panels
+		{"rwp+tcp://localhost?enc=auto", false},
+		{"rwp+tcp://:9923", true},
+		{"rwp+tcp://localhost?enc=json", true},
+		{"http://localhost", true},
+	} {
+		if _, err := parseEndpoint(bad.uri, bad.panel); err == nil {
+			t.Errorf("%s: expected an error", bad.uri)
+		}
+	}
+}
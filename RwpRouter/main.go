@@ -0,0 +1,260 @@
+/*
+Raw Panel Router
+
+Connects a panel to a system over any combination of transports. Each side is given as a URI:
+- rwp+tcp://host:port?enc=ascii|binary|auto connects to a panel or system in server mode (port 9923 if not given)
+- rwp+listen://:port?enc=ascii|binary|auto accepts a panel or system in client mode, one at a time
+- ws://host:port/path or wss://... connects to a Raw Panel WebSocket server (system side only), see RwpWebsocketServer
+
+Messages are translated into the intermediate Raw Panel protobuf format and forwarded, and the router takes care of the handshakes
+which the tools for each pair of transports (ServerPanel2ServerSystem, ServerPanel2ClientSystem, RwpWebsocketBridge) do today:
+- Both sides are pinged by the router, pings from either side are answered and acks are not forwarded
+- A system in server mode is sent "list" when connected, as a panel in client mode would do
+- A panel in client mode sending "list" gets ActivePanel=1 back, unless the system is in server mode and answers it itself
+- A panel in server mode is asked for "map" (ReportHWCavailability) after being activated, as it only sends it on its own in client mode
+- For WebSocket servers, RawPanelSupport in the panel info is rewritten to JSON over ASCII, and the connection is only kept while the panel is connected
+
+Messages are forwarded as they are: Unlike RwpWebsocketBridge with -processors, the router does not run the rawpanel-processors on the states
+from the system, so it stays a transport and doesn't pull in their dependencies. Use RwpWebsocketBridge where processors are needed.
+
+With -eventFilter, events from the panel are dropped, deadbanded, rate limited or coalesced before they reach the system (see rwptransport/filter.go).
+
+Distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+without even the implied warranty of MERCHANTABILITY or FITNESS FOR A
+PARTICULAR PURPOSE. MIT License
+*/
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+
+	"rwptransport"
+)
+
+// Prints connection events of one side of the router
+func printEvents(side string) func(rwptransport.Event) {
+	return func(e rwptransport.Event) {
+		switch e.Type {
+		case rwptransport.Connected:
+			fmt.Println("Success - Connected to " + strings.ToLower(side) + " on " + e.Addr + " (" + e.Encoding.String() + ")")
+		case rwptransport.Disconnected:
+			if e.Err != nil {
+				fmt.Println(side+": "+e.Addr+" disconnected:", e.Err)
+			} else {
+				fmt.Println(side + ": " + e.Addr + " disconnected")
+			}
+		case rwptransport.DialFailed:
+			fmt.Println(e.Err)
+			fmt.Println("Trying to connect to " + strings.ToLower(side) + " on " + e.Addr + "...")
+		case rwptransport.ReadError:
+			fmt.Println(side+":", e.Err)
+		}
+	}
+}
+
+// Prints what is written to one side of the router
+func printWrites(prefix string) func([]byte, rwptransport.Encoding) {
+	return func(data []byte, encoding rwptransport.Encoding) {
+		if encoding == rwptransport.Binary {
+			fmt.Println(prefix, data)
+		} else {
+			fmt.Println(prefix + strings.TrimSpace(string(data)))
+		}
+	}
+}
+
+// runPanel runs the panel side until ctx is done. A listener for panels in client mode is opened by the caller.
+func runPanel(ctx context.Context, ep *endpoint, l net.Listener, toPanel chan []*rwp.InboundMessage, fromPanel chan []*rwp.OutboundMessage, config rwptransport.Config) {
+	config.Encoding = ep.encoding
+	config.DetectEncoding = ep.detect
+	config.PingPeriod = 500 * time.Millisecond
+	config.OnWrite = printWrites("System -> Panel: ")
+
+	switch ep.kind {
+	case Dial:
+		fmt.Println("Trying to connect to panel on " + ep.addr + "...")
+		rwptransport.DialPanel(ctx, ep.addr, toPanel, fromPanel, config)
+	case Listen:
+		fmt.Println("Waiting for a panel to connect on " + ep.addr + "...")
+		if err := rwptransport.ListenPanel(ctx, l, toPanel, fromPanel, config); err != nil {
+			fmt.Println("Panel:", err)
+		}
+	}
+}
+
+// runSystem runs the system side until ctx is done. A listener for systems in client mode is opened by the caller.
+func runSystem(ctx context.Context, ep *endpoint, l net.Listener, toSystem chan []*rwp.OutboundMessage, fromSystem chan []*rwp.InboundMessage, config rwptransport.Config, auth *rwptransport.AuthCredentials, panelUp *atomic.Bool) {
+	config.Encoding = ep.encoding
+	config.DetectEncoding = ep.detect
+	config.PingPeriod = 1000 * time.Millisecond
+	config.ReassembleGraphics = true
+	config.OnWrite = printWrites("Panel -> System: ")
+
+	switch ep.kind {
+	case Dial:
+		onEvent := config.OnEvent
+		config.OnEvent = func(e rwptransport.Event) {
+			if e.Type == rwptransport.Connected {
+				toSystem <- []*rwp.OutboundMessage{{FlowMessage: rwp.OutboundMessage_HELLO}} // Initialize with system ("list" in ASCII)
+			}
+			onEvent(e)
+		}
+		fmt.Println("Trying to connect to system on " + ep.addr + "...")
+		rwptransport.DialSystem(ctx, ep.addr, toSystem, fromSystem, config)
+	case Listen:
+		fmt.Println("Waiting for a system to connect on " + ep.addr + "...")
+		if err := rwptransport.ListenSystem(ctx, l, toSystem, fromSystem, config); err != nil {
+			fmt.Println("System:", err)
+		}
+	case WebSocket:
+		fmt.Println("Connecting to system on " + ep.addr + " while the panel is connected...")
+		dialWebSocket(ctx, ep.addr, auth, config.Timeout, panelUp, toSystem, fromSystem, func(e rwptransport.Event) {
+			if e.Type == rwptransport.Connected {
+				fmt.Println("Success - Connected to system on " + e.Addr + " (WebSocket)")
+				return
+			}
+			config.OnEvent(e)
+		})
+	}
+}
+
+// listen opens the listener of a rwp+listen endpoint, so errors are reported before anything starts
+func listen(ep *endpoint) (net.Listener, error) {
+	if ep.kind != Listen {
+		return nil, nil
+	}
+	return net.Listen("tcp", ep.addr)
+}
+
+func main() {
+
+	// Setting up and parsing command line parameters
+	sessionLog := flag.String("sessionLog", "", "Logs all messages to and from the panel to this file, for replay with RawPanelReplay")
+	timeout := flag.Duration("timeout", 5*time.Second, "Reconnects to the panel or system if nothing is received from it for this long. 0 disables")
+	eventFilterFile := flag.String("eventFilter", "", "File with rules to drop, deadband, rate limit or coalesce events from the panel")
+	clientID := flag.String("client_id", "", "Client ID for authentication with a WebSocket server")
+	clientSecret := flag.String("client_secret", "", "Client secret for authentication with a WebSocket server")
+	allowInsecureAuth := flag.Bool("allow_insecure_auth", false, "Allow authentication over insecure ws:// connections (NOT RECOMMENDED)")
+	flag.Parse()
+
+	arguments := flag.Args()
+	if len(arguments) != 2 {
+		fmt.Println("usage: RwpRouter [-sessionLog file -timeout 5s -eventFilter file -client_id id -client_secret secret] [panel URI] [system URI]")
+		fmt.Println("       URIs: rwp+tcp://host:port?enc=ascii|binary|auto, rwp+listen://:port?enc=..., ws://host:port/path, wss://...")
+		fmt.Println("       WebSocket URIs are for the system side only, a Raw Panel WebSocket server takes the role of a system")
+		fmt.Println("example: RwpRouter rwp+tcp://192.168.10.99:9923?enc=binary rwp+listen://:9924?enc=ascii")
+		fmt.Println("help:  RwpRouter -h")
+		fmt.Println("")
+		return
+	}
+
+	panelEndpoint, err := parseEndpoint(arguments[0], true)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	systemEndpoint, err := parseEndpoint(arguments[1], false)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	var auth *rwptransport.AuthCredentials
+	if *clientID != "" || *clientSecret != "" {
+		if systemEndpoint.kind != WebSocket {
+			fmt.Println("-client_id and -client_secret are only used with a WebSocket system")
+			return
+		}
+		if !strings.HasPrefix(systemEndpoint.addr, "wss://") {
+			if !*allowInsecureAuth {
+				fmt.Println("The system must use wss:// when -client_id or -client_secret is set. Use -allow_insecure_auth to override (NOT RECOMMENDED)")
+				return
+			}
+			fmt.Println("WARNING: Using authentication over insecure ws:// connection to " + systemEndpoint.addr)
+		}
+		auth = &rwptransport.AuthCredentials{ClientID: *clientID, ClientSecret: *clientSecret}
+	}
+
+	var eventFilter *rwptransport.EventFilter
+	if *eventFilterFile != "" {
+		if eventFilter, err = rwptransport.LoadEventFilter(*eventFilterFile); err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+
+	// Welcome message!
+	fmt.Println("Welcome to Raw Panel - Router!")
+	fmt.Println("Configuration:")
+	fmt.Println("  panel:   ", panelEndpoint)
+	fmt.Println("  system:  ", systemEndpoint)
+	fmt.Println("  timeout: ", *timeout)
+	if *eventFilterFile != "" {
+		fmt.Println("  eventFilter:", *eventFilterFile)
+	}
+	fmt.Print("Ready to facilitate communication between the panel and system...\n\n")
+
+	panelListener, err := listen(panelEndpoint)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	systemListener, err := listen(systemEndpoint)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	var recorder *rwptransport.Recorder
+	if *sessionLog != "" {
+		recorder, err = rwptransport.CreateRecorder(*sessionLog)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		defer recorder.Close()
+	}
+
+	r := &router{
+		panel:    panelEndpoint,
+		system:   systemEndpoint,
+		toPanel:  make(chan []*rwp.InboundMessage, 10),
+		toSystem: make(chan []*rwp.OutboundMessage, 10),
+	}
+	fromPanel := make(chan []*rwp.OutboundMessage, 10)
+	fromSystem := make(chan []*rwp.InboundMessage, 10)
+
+	ctx := context.Background()
+	var panelUp atomic.Bool
+	panelEvents := printEvents("Panel")
+	go runPanel(ctx, panelEndpoint, panelListener, r.toPanel, fromPanel, rwptransport.Config{
+		Timeout:  *timeout,
+		Recorder: recorder,
+		OnEvent: func(e rwptransport.Event) {
+			switch e.Type {
+			case rwptransport.Connected:
+				panelUp.Store(true)
+			case rwptransport.Disconnected:
+				if !errors.Is(e.Err, rwptransport.ErrBusy) {
+					panelUp.Store(false)
+				}
+			}
+			panelEvents(e)
+		},
+	})
+	go runSystem(ctx, systemEndpoint, systemListener, r.toSystem, fromSystem, rwptransport.Config{
+		Timeout: *timeout,
+		OnEvent: printEvents("System"),
+	}, auth, &panelUp)
+
+	r.run(eventFilter.Pipe(ctx, fromPanel), fromSystem)
+}
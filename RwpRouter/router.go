@@ -0,0 +1,117 @@
+package main
+
+import (
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// router forwards messages between the panel and system sides and fills in the parts of the handshake that get lost
+// when the two sides don't have the roles they expect (eg. a panel in server mode talking to a system in server mode).
+// Each side is pinged by its own connection, so pings are answered here and acks are not forwarded.
+type router struct {
+	panel    *endpoint
+	system   *endpoint
+	toPanel  chan []*rwp.InboundMessage
+	toSystem chan []*rwp.OutboundMessage
+}
+
+// run forwards messages until the channels from the sides are closed
+func (r *router) run(fromPanel <-chan []*rwp.OutboundMessage, fromSystem <-chan []*rwp.InboundMessage) {
+	for fromPanel != nil || fromSystem != nil {
+		select {
+		case msgs, ok := <-fromPanel:
+			if !ok {
+				fromPanel = nil
+				continue
+			}
+			forward, reply := r.fromPanel(msgs)
+			if len(reply) > 0 {
+				r.toPanel <- reply
+			}
+			if len(forward) > 0 {
+				r.toSystem <- forward
+			}
+		case msgs, ok := <-fromSystem:
+			if !ok {
+				fromSystem = nil
+				continue
+			}
+			forward, reply := r.fromSystem(msgs)
+			if len(reply) > 0 {
+				r.toSystem <- reply
+			}
+			if len(forward) > 0 {
+				r.toPanel <- forward
+			}
+		}
+	}
+}
+
+// fromPanel returns the messages to forward to the system and the replies to send back to the panel
+func (r *router) fromPanel(msgs []*rwp.OutboundMessage) (forward []*rwp.OutboundMessage, reply []*rwp.InboundMessage) {
+	for _, msg := range msgs {
+		msg = proto.Clone(msg).(*rwp.OutboundMessage)
+
+		switch msg.FlowMessage {
+		case rwp.OutboundMessage_PING:
+			reply = append(reply, &rwp.InboundMessage{FlowMessage: rwp.InboundMessage_ACK})
+			msg.FlowMessage = 0
+		case rwp.OutboundMessage_ACK: // Acks are for our pings
+			msg.FlowMessage = 0
+		case rwp.OutboundMessage_HELLO:
+			if r.system.kind != Dial { // Only a system in server mode answers "list", for the others it is answered here
+				reply = append(reply, &rwp.InboundMessage{Command: &rwp.Command{ActivatePanel: true}})
+				msg.FlowMessage = 0
+			}
+		}
+
+		if msg.PanelInfo != nil && r.system.kind == WebSocket {
+			rewriteRawPanelSupport(msg.PanelInfo)
+		}
+
+		if proto.Size(msg) > 0 {
+			forward = append(forward, msg)
+		}
+	}
+	return forward, reply
+}
+
+// fromSystem returns the messages to forward to the panel and the replies to send back to the system
+func (r *router) fromSystem(msgs []*rwp.InboundMessage) (forward []*rwp.InboundMessage, reply []*rwp.OutboundMessage) {
+	for _, msg := range msgs {
+		msg = proto.Clone(msg).(*rwp.InboundMessage)
+
+		switch msg.FlowMessage {
+		case rwp.InboundMessage_PING:
+			reply = append(reply, &rwp.OutboundMessage{FlowMessage: rwp.OutboundMessage_ACK})
+			msg.FlowMessage = 0
+		case rwp.InboundMessage_ACK: // Acks are for our pings
+			msg.FlowMessage = 0
+		}
+
+		if proto.Size(msg) > 0 {
+			forward = append(forward, msg)
+		}
+		if msg.Command != nil && msg.Command.ActivatePanel && r.panel.serverMode() { // Ask the panel for "map" since it will only do that on its own initiative in client mode
+			forward = append(forward, &rwp.InboundMessage{Command: &rwp.Command{ReportHWCavailability: true}})
+		}
+	}
+	return forward, reply
+}
+
+// rewriteRawPanelSupport tells a WebSocket server to talk JSON to the panel, as RwpWebsocketBridge does.
+// UniSketch panels don't report RawPanelSupport at all, so it's added for them.
+// Processors are left as the panel reports them since the router doesn't run them.
+func rewriteRawPanelSupport(info *rwp.PanelInfo) {
+	if info.Model != "" && info.RawPanelSupport == nil {
+		info.RawPanelSupport = &rwp.RawPanelSupport{}
+	}
+	if support := info.RawPanelSupport; support != nil {
+		support.ASCII = true
+		support.Binary = false
+		support.ASCII_JSONfeedback = true
+		support.ASCII_Inbound = true
+		support.ASCII_Outbound = true
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"testing"
+
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+
+	"google.golang.org/protobuf/proto"
+)
+
+func TestRouterFromPanel(t *testing.T) {
+	tests := []struct {
+		name       string
+		systemKind string
+		msg        *rwp.OutboundMessage
+		forwarded  bool
+		reply      *rwp.InboundMessage
+	}{
+		{"ping is answered", Dial, &rwp.OutboundMessage{FlowMessage: rwp.OutboundMessage_PING}, false, &rwp.InboundMessage{FlowMessage: rwp.InboundMessage_ACK}},
+		{"ack is dropped", Dial, &rwp.OutboundMessage{FlowMessage: rwp.OutboundMessage_ACK}, false, nil},
+		{"list to system in server mode", Dial, &rwp.OutboundMessage{FlowMessage: rwp.OutboundMessage_HELLO}, true, nil},
+		{"list to system in client mode", Listen, &rwp.OutboundMessage{FlowMessage: rwp.OutboundMessage_HELLO}, false, &rwp.InboundMessage{Command: &rwp.Command{ActivatePanel: true}}},
+		{"list to WebSocket system", WebSocket, &rwp.OutboundMessage{FlowMessage: rwp.OutboundMessage_HELLO}, false, &rwp.InboundMessage{Command: &rwp.Command{ActivatePanel: true}}},
+		{"events are forwarded", Listen, &rwp.OutboundMessage{Events: []*rwp.HWCEvent{{HWCID: 3}}}, true, nil},
+	}
+
+	for _, test := range tests {
+		r := &router{panel: &endpoint{kind: Dial}, system: &endpoint{kind: test.systemKind}}
+		forward, reply := r.fromPanel([]*rwp.OutboundMessage{test.msg})
+		if test.forwarded != (len(forward) == 1) {
+			t.Errorf("%s: forwarded %v", test.name, forward)
+		}
+		if test.reply == nil && len(reply) != 0 || test.reply != nil && (len(reply) != 1 || !proto.Equal(reply[0], test.reply)) {
+			t.Errorf("%s: expected reply %v, got %v", test.name, test.reply, reply)
+		}
+	}
+}
+
+func TestRouterFromPanelRewritesPanelInfo(t *testing.T) {
+	info := &rwp.PanelInfo{Model: "SK_PTZFLY"}
+	for _, kind := range []string{Dial, WebSocket} {
+		r := &router{panel: &endpoint{kind: Dial}, system: &endpoint{kind: kind}}
+		forward, _ := r.fromPanel([]*rwp.OutboundMessage{{PanelInfo: info}})
+		if len(forward) != 1 {
+			t.Fatalf("%s: expected the panel info to be forwarded, got %v", kind, forward)
+		}
+		if rewritten := forward[0].PanelInfo.RawPanelSupport != nil; rewritten != (kind == WebSocket) {
+			t.Errorf("%s: RawPanelSupport is %v", kind, forward[0].PanelInfo.RawPanelSupport)
+		}
+	}
+	if info.RawPanelSupport != nil {
+		t.Error("the message from the panel was modified")
+	}
+}
+
+func TestRouterFromSystem(t *testing.T) {
+	msgs := []*rwp.InboundMessage{
+		{FlowMessage: rwp.InboundMessage_PING},
+		{FlowMessage: rwp.InboundMessage_ACK},
+		{Command: &rwp.Command{ActivatePanel: true}},
+	}
+
+	r := &router{panel: &endpoint{kind: Dial}, system: &endpoint{kind: Listen}}
+	forward, reply := r.fromSystem(msgs)
+	if len(reply) != 1 || reply[0].FlowMessage != rwp.OutboundMessage_ACK {
+		t.Errorf("expected an ack for the ping, got %v", reply)
+	}
+	if len(forward) != 2 || !forward[0].Command.GetActivatePanel() || !forward[1].Command.GetReportHWCavailability() {
+		t.Errorf("expected activation and a map request for a panel in server mode, got %v", forward)
+	}
+
+	r.panel.kind = Listen
+	if forward, _ = r.fromSystem(msgs); len(forward) != 1 || !forward[0].Command.GetActivatePanel() {
+		t.Errorf("expected activation only for a panel in client mode, got %v", forward)
+	}
+}
+
+func TestRewriteRawPanelSupport(t *testing.T) {
+	info := &rwp.PanelInfo{Model: "SK_PTZFLY"} // UniSketch panels don't report RawPanelSupport
+	rewriteRawPanelSupport(info)
+	support := info.RawPanelSupport
+	if support == nil || !support.ASCII || support.Binary || !support.ASCII_JSONfeedback || !support.ASCII_Inbound || !support.ASCII_Outbound {
+		t.Errorf("unexpected RawPanelSupport %v", support)
+	}
+
+	info = &rwp.PanelInfo{RawPanelSupport: &rwp.RawPanelSupport{Binary: true, ASCII: true}}
+	rewriteRawPanelSupport(info)
+	if support := info.RawPanelSupport; support.Binary || !support.ASCII_JSONfeedback {
+		t.Errorf("unexpected RawPanelSupport %v", support)
+	}
+
+	info = &rwp.PanelInfo{Serial: "123"} // Only the model tells it's a panel info worth completing
+	if rewriteRawPanelSupport(info); info.RawPanelSupport != nil {
+		t.Errorf("unexpected RawPanelSupport %v", info.RawPanelSupport)
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+	"github.com/gorilla/websocket"
+
+	"rwptransport"
+)
+
+// errPanelGone ends a WebSocket connection when the panel disconnects, so the server greets the panel again on the next connection
+var errPanelGone = errors.New("panel disconnected")
+
+// dialWebSocket keeps a connection to a WebSocket server while the panel is connected, until ctx is done.
+// Like the rwptransport connections, messages on toSystem are discarded while unconnected and fromSystem must be read continuously.
+// auth is sent as the first message if given. timeout drops a connection where nothing (including WebSocket pings) is received for that long.
+func dialWebSocket(ctx context.Context, addr string, auth *rwptransport.AuthCredentials, timeout time.Duration, panelUp *atomic.Bool, toSystem <-chan []*rwp.OutboundMessage, fromSystem chan<- []*rwp.InboundMessage, onEvent func(rwptransport.Event)) {
+	for {
+		if !panelUp.Load() {
+			if !drain(ctx, time.Second, toSystem) {
+				return
+			}
+			continue
+		}
+
+		conn, _, err := websocket.DefaultDialer.DialContext(ctx, addr, nil)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			onEvent(rwptransport.Event{Type: rwptransport.DialFailed, Addr: addr, Err: err})
+			if !drain(ctx, 5*time.Second, toSystem) {
+				return
+			}
+			continue
+		}
+
+		onEvent(rwptransport.Event{Type: rwptransport.Connected, Addr: addr})
+		err = serveWebSocket(ctx, conn, addr, auth, timeout, panelUp, toSystem, fromSystem, onEvent)
+		if ctx.Err() != nil {
+			err = nil
+		}
+		onEvent(rwptransport.Event{Type: rwptransport.Disconnected, Addr: addr, Err: err})
+
+		if !drain(ctx, time.Second, toSystem) {
+			return
+		}
+	}
+}
+
+// serveWebSocket runs one WebSocket connection. Messages only flow once the server has reported the status "ready".
+func serveWebSocket(ctx context.Context, conn *websocket.Conn, addr string, auth *rwptransport.AuthCredentials, timeout time.Duration, panelUp *atomic.Bool, toSystem <-chan []*rwp.OutboundMessage, fromSystem chan<- []*rwp.InboundMessage, onEvent func(rwptransport.Event)) error {
+	defer conn.Close()
+
+	var ready atomic.Bool
+	extendDeadline := func() {
+		if timeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(timeout))
+		}
+	}
+	extendDeadline()
+	conn.SetPingHandler(func(data string) error {
+		extendDeadline()
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+
+	if auth != nil {
+		payload, err := json.Marshal(rwptransport.WSMessageToServer{Auth: auth})
+		if err != nil {
+			return err
+		}
+		if err := conn.WriteMessage(websocket.TextMessage, payload); err != nil {
+			return err
+		}
+	}
+
+	readErr := make(chan error, 1)
+	go func() {
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				readErr <- err
+				return
+			}
+			extendDeadline()
+
+			var envelope rwptransport.WSMessageFromServer
+			if err := json.Unmarshal(data, &envelope); err != nil {
+				onEvent(rwptransport.Event{Type: rwptransport.ReadError, Addr: addr, Err: fmt.Errorf("invalid message: %w", err)})
+				continue
+			}
+			switch envelope.Status {
+			case "":
+			case "ready":
+				ready.Store(true)
+				fmt.Println("System: " + addr + " is ready")
+			case "auth_required":
+				fmt.Println("System: " + addr + " requires authentication, use -client_id and -client_secret")
+			default:
+				fmt.Println("System: " + addr + " status: " + envelope.Status)
+			}
+			if envelope.Error != "" {
+				fmt.Println("System: " + addr + " error: " + envelope.Error)
+			}
+			if envelope.Message != "" {
+				fmt.Println("System: " + addr + ": " + envelope.Message)
+			}
+
+			if len(envelope.MsgsToPanel) > 0 && ready.Load() {
+				select {
+				case fromSystem <- envelope.MsgsToPanel:
+				case <-ctx.Done():
+				}
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-readErr:
+			return err
+		case <-ticker.C:
+			if !panelUp.Load() {
+				return errPanelGone
+			}
+		case msgs := <-toSystem:
+			if !ready.Load() {
+				continue // Like RwpWebsocketBridge, nothing is sent before the server is ready
+			}
+			payload, err := json.Marshal(rwptransport.WSMessageToServer{MsgsFromPanel: msgs})
+			if err != nil {
+				fmt.Println("System:", err)
+				continue
+			}
+			if err := conn.WriteMessage(websocket.TextMessage, payload); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// drain waits for d while discarding messages on outgoing. It returns false if ctx is done.
+func drain[T any](ctx context.Context, d time.Duration, outgoing <-chan T) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-outgoing:
+		case <-timer.C:
+			return true
+		}
+	}
+}
@@ -15,6 +15,8 @@ import (
 	log "github.com/s00500/env_logger"
 
 	"github.com/gorilla/websocket"
+
+	"rwptransport"
 )
 
 // BridgeConnection encapsulates all state and channels related to a single raw panel connection through websocket.
@@ -44,6 +46,9 @@ type BridgeConnection struct {
 	ClientSecret string      // "Password" for authentication
 	Processors   atomic.Bool // Enable processors for this panel
 
+	// Recorder logs the messages to and from the panel, if session logging is enabled (nil otherwise).
+	Recorder *rwptransport.Recorder
+
 	WSConn         *websocket.Conn
 	WSMutex        sync.Mutex // To safely send on WSConn
 	PanelConnected atomic.Bool
@@ -51,27 +56,8 @@ type BridgeConnection struct {
 	wsReady        atomic.Bool
 }
 
-// Message envelopes
-type WSMessageToServer struct {
-	MsgsFromPanel []*rwp.OutboundMessage `json:"msgsFromPanel,omitempty"` // raw json passed back to client
-	Auth          *AuthCredentials       `json:"auth,omitempty"`          // optional auth field
-}
-
-// AuthCredentials holds username/password login info
-type AuthCredentials struct {
-	ClientID     string `json:"client_id"`     // "Username" for authentication
-	ClientSecret string `json:"client_secret"` // "Password" for authentication
-}
-
-type WSMessageFromServer struct {
-	MsgsToPanel []*rwp.InboundMessage `json:"msgsToPanel,omitempty"` // raw json we receive from client
-	Error       string                `json:"error,omitempty"`       // optional error message
-	Message     string                `json:"message,omitempty"`     // optional success message
-	Status      string                `json:"status,omitempty"`      // connection status (e.g., "ready", "auth required")
-}
-
 // NewBridgeManager initializes a new BridgeConnection instance with the given parameters.
-func NewBridgeManager(addr string, wsEndpoint string, clientID, clientSecret string, processors bool) *BridgeConnection {
+func NewBridgeManager(addr string, wsEndpoint string, clientID, clientSecret string, processors bool, recorder *rwptransport.Recorder) *BridgeConnection {
 
 	// Create a cancellable context used to control this connection's lifecycle.
 	ctx, cancel := context.WithCancel(context.Background())
@@ -92,6 +78,7 @@ func NewBridgeManager(addr string, wsEndpoint string, clientID, clientSecret str
 		ClientID:       clientID,                               // Client ID ("username") for authentication
 		ClientSecret:   clientSecret,                           // Client Secret ("password") for authentication
 		Processors:     processorsFlag,                         // Processors enabled/disabled
+		Recorder:       recorder,                               // Session log, may be nil
 	}
 }
 
@@ -202,8 +189,8 @@ func (bc *BridgeConnection) connectWebSocket() {
 
 		// Authentication
 		if bc.ClientID != "" || bc.ClientSecret != "" {
-			envelope := WSMessageToServer{
-				Auth: &AuthCredentials{
+			envelope := rwptransport.WSMessageToServer{
+				Auth: &rwptransport.AuthCredentials{
 					ClientID:     bc.ClientID,
 					ClientSecret: bc.ClientSecret,
 				},
@@ -255,7 +242,7 @@ func (bc *BridgeConnection) readWebSocketMessages() {
 			return
 		}
 
-		var envelope WSMessageFromServer
+		var envelope rwptransport.WSMessageFromServer
 		if err := json.Unmarshal(message, &envelope); err != nil {
 			log.Warnf("[%s] Invalid WS message format: %v", bc.PanelAddr, err)
 			continue
@@ -302,6 +289,7 @@ func (bc *BridgeConnection) readWebSocketMessages() {
 
 				select {
 				case bc.Incoming <- envelope.MsgsToPanel:
+					bc.Recorder.Inbound(bc.PanelAddr, envelope.MsgsToPanel)
 				default:
 					log.Warnf("[%s] Dropping message: panel not ready", bc.PanelAddr)
 				}
@@ -334,6 +322,8 @@ func (bc *BridgeConnection) messageLoop() {
 			return
 
 		case msgs := <-bc.Outgoing:
+			bc.Recorder.Outbound(bc.PanelAddr, msgs)
+
 			if !bc.wsReady.Load() {
 				log.Warnf("[%s] Skipping outgoing message: WebSocket not ready", bc.PanelAddr)
 				continue
@@ -363,7 +353,7 @@ func (bc *BridgeConnection) messageLoop() {
 				}
 			}
 
-			payload, err := json.Marshal(WSMessageToServer{MsgsFromPanel: msgs})
+			payload, err := json.Marshal(rwptransport.WSMessageToServer{MsgsFromPanel: msgs})
 			if err != nil {
 				log.Warnf("[%s] Failed to encode panel message for WS: %v", bc.PanelAddr, err)
 				continue
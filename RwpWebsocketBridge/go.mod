@@ -7,6 +7,7 @@ require (
 	github.com/SKAARHOJ/rawpanel-processors v1.1.0
 	github.com/gorilla/websocket v1.5.3
 	github.com/s00500/env_logger v0.1.29
+	rwptransport v0.0.0
 )
 
 require (
@@ -25,3 +26,5 @@ require (
 	golang.org/x/sys v0.33.0 // indirect
 	google.golang.org/protobuf v1.36.6 // indirect
 )
+
+replace rwptransport => ../rwptransport
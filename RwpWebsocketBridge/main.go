@@ -8,6 +8,8 @@ import (
 	"time"
 
 	log "github.com/s00500/env_logger"
+
+	"rwptransport"
 )
 
 var (
@@ -15,6 +17,7 @@ var (
 	clientSecret      = flag.String("client_secret", "", "Client secret for authentication")
 	allowInsecureAuth = flag.Bool("allow_insecure_auth", false, "Allow authentication over insecure ws:// connections (NOT RECOMMENDED)")
 	processors        = flag.Bool("processors", false, "Force-Enable Raw Panel Protocol processors in-flight to panel")
+	sessionLog        = flag.String("sessionLog", "", "Logs all messages to and from the panels to this file, for replay with RawPanelReplay")
 )
 
 func main() {
@@ -26,6 +29,7 @@ func main() {
 	  -client_id     Client ID for authentication
 	  -client_secret Client secret for authentication
   	  -allow_insecure_auth   Allow client_id/client_secret over insecure ws:// (NOT RECOMMENDED)
+	  -sessionLog    File to log all messages to and from the panels to
 	`)
 	}
 	flag.Parse()
@@ -75,13 +79,24 @@ func main() {
 		})
 	}
 
+	var recorder *rwptransport.Recorder
+	if *sessionLog != "" {
+		var err error
+		recorder, err = rwptransport.CreateRecorder(*sessionLog)
+		if err != nil {
+			log.Errorf("Could not open session log: %v", err)
+			os.Exit(1)
+		}
+		defer recorder.Close()
+	}
+
 	for {
 		var bridges []*BridgeConnection
 
 		// Create a bridge connection for each mapping
 		for _, m := range mappings {
 			log.Infof("Starting bridge for %s => %s", m.PanelAddr, m.ServerEndpoint)
-			bridgeConn := NewBridgeManager(m.PanelAddr, m.ServerEndpoint, *clientID, *clientSecret, *processors)
+			bridgeConn := NewBridgeManager(m.PanelAddr, m.ServerEndpoint, *clientID, *clientSecret, *processors, recorder)
 			bridgeConn.Start()
 			bridges = append(bridges, bridgeConn)
 		}
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+	"github.com/SKAARHOJ/rawpanel-lib/topology"
+
+	"google.golang.org/protobuf/proto"
+
+	"rwptransport"
+)
+
+// Space between panels in a merged topology (1/10th mm)
+const panelGap = 200
+
+// aggregatedPanel is one of the physical panels behind the virtual panel
+type aggregatedPanel struct {
+	addr         string
+	offset       uint32 // Added to the HWC numbers of this panel
+	toPanel      chan []*rwp.InboundMessage
+	connected    bool
+	busy         bool
+	info         *rwp.PanelInfo
+	topology     *topologyJSON
+	availability map[uint32]uint32 // In HWC numbers of the virtual panel
+}
+
+// topologyJSON is the PanelTopology JSON of a panel. Types are kept as raw JSON so they can be compared between panels.
+type topologyJSON struct {
+	Title     string                         `json:"title,omitempty"`
+	HWc       []topology.TopologyHWcomponent `json:"HWc"`
+	TypeIndex map[uint32]json.RawMessage     `json:"typeIndex"`
+}
+
+// aggregator presents several panels as one virtual panel. HWC n of the i'th panel is HWC i*offset+n on the virtual panel.
+type aggregator struct {
+	panels    []*aggregatedPanel
+	offset    uint32
+	column    bool // Stack the topologies vertically instead of side by side
+	toSystems chan<- []*rwp.OutboundMessage
+	activated bool // The system has sent ActivatePanel, so reconnecting panels must be activated too
+	busy      bool // BSY has been sent to the systems
+	mu        sync.Mutex
+}
+
+// aggregatePanels connects to all panels and runs the virtual panel until ctx is done.
+// Messages on incoming are routed to the panels, and messages from the panels are combined on outgoing.
+func aggregatePanels(ctx context.Context, addrs []string, offset uint32, column bool, incoming <-chan []*rwp.InboundMessage, outgoing chan<- []*rwp.OutboundMessage, binaryPanel bool, autoPanel bool, recorder *rwptransport.Recorder, onEvent func(rwptransport.Event)) {
+	agg := &aggregator{offset: offset, column: column, toSystems: outgoing}
+
+	for i, addr := range addrs {
+		panel := &aggregatedPanel{
+			addr:    addr,
+			offset:  uint32(i) * offset,
+			toPanel: make(chan []*rwp.InboundMessage, 10),
+		}
+		agg.panels = append(agg.panels, panel)
+		fmt.Printf("Panel %s has HWCs %d-%d on the virtual panel\n", addr, panel.offset+1, panel.offset+offset-1)
+
+		fromPanel := make(chan []*rwp.OutboundMessage, 10)
+		go connectToPanel(ctx, addr, panel.toPanel, fromPanel, binaryPanel, autoPanel, recorder, 500*time.Millisecond, func(e rwptransport.Event) {
+			agg.connectionChanged(panel, e)
+			onEvent(e)
+		})
+		go func() {
+			for msgs := range fromPanel {
+				if msgs = agg.fromPanel(panel, msgs); len(msgs) > 0 {
+					outgoing <- msgs
+				}
+			}
+		}()
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msgs := <-incoming:
+			agg.fromSystem(msgs)
+		}
+	}
+}
+
+// connectionChanged keeps track of which panels are connected. A panel coming back is activated, and a panel going away is removed from the availability.
+func (agg *aggregator) connectionChanged(panel *aggregatedPanel, e rwptransport.Event) {
+	var toPanel []*rwp.InboundMessage
+	var toSystems []*rwp.OutboundMessage
+
+	agg.mu.Lock()
+	switch e.Type {
+	case rwptransport.Connected:
+		panel.connected = true
+		if agg.activated {
+			toPanel = []*rwp.InboundMessage{{Command: &rwp.Command{ActivatePanel: true, ReportHWCavailability: true}}}
+		}
+	case rwptransport.Disconnected:
+		panel.connected = false
+		panel.busy = false
+		panel.availability = nil
+		toSystems = []*rwp.OutboundMessage{{HWCavailability: agg.availability()}}
+		if flow := agg.flow(); flow != 0 {
+			toSystems = append(toSystems, &rwp.OutboundMessage{FlowMessage: flow})
+		}
+	}
+	agg.mu.Unlock()
+
+	// Sent without the lock, so a stalled panel or system doesn't hold up the other panels
+	if len(toPanel) > 0 {
+		panel.toPanel <- toPanel
+	}
+	if len(toSystems) > 0 {
+		agg.toSystems <- toSystems
+	}
+}
+
+// fromPanel translates messages from a panel into messages from the virtual panel
+func (agg *aggregator) fromPanel(panel *aggregatedPanel, msgs []*rwp.OutboundMessage) []*rwp.OutboundMessage {
+	pinged := false
+	defer func() {
+		if pinged { // Sent without the lock, the panel connection may be waiting for it to report a disconnect
+			panel.toPanel <- []*rwp.InboundMessage{{FlowMessage: rwp.InboundMessage_ACK}}
+		}
+	}()
+
+	agg.mu.Lock()
+	defer agg.mu.Unlock()
+
+	forward := make([]*rwp.OutboundMessage, 0, len(msgs))
+	for _, msg := range msgs {
+		msg = proto.Clone(msg).(*rwp.OutboundMessage)
+
+		switch msg.FlowMessage {
+		case rwp.OutboundMessage_PING:
+			pinged = true
+		case rwp.OutboundMessage_BSY:
+			panel.busy = true
+		case rwp.OutboundMessage_RDY:
+			panel.busy = false
+		}
+		msg.FlowMessage = agg.flow() // Pings and acks are answered here, busy and ready are combined
+
+		events := msg.Events[:0]
+		for _, event := range msg.Events {
+			if event.HWCID >= agg.offset {
+				fmt.Printf("Panel %s: HWC %d is beyond the offset between panels, ignored\n", panel.addr, event.HWCID)
+				continue
+			}
+			event.HWCID += panel.offset
+			events = append(events, event)
+		}
+		msg.Events = events
+
+		if msg.HWCavailability != nil {
+			panel.availability = make(map[uint32]uint32)
+			for hwc, value := range msg.HWCavailability {
+				if hwc >= agg.offset || value >= agg.offset {
+					continue
+				}
+				if value != 0 {
+					value += panel.offset
+				}
+				panel.availability[hwc+panel.offset] = value
+			}
+			msg.HWCavailability = agg.availability()
+		}
+
+		if msg.PanelInfo != nil {
+			panel.info = msg.PanelInfo
+			msg.PanelInfo = agg.panelInfo()
+		}
+
+		if msg.PanelTopology != nil {
+			panel.topology = &topologyJSON{}
+			if err := json.Unmarshal([]byte(msg.PanelTopology.Json), panel.topology); err != nil {
+				fmt.Println("Panel "+panel.addr+": topology not understood:", err)
+				panel.topology = nil
+			}
+			msg.PanelTopology = &rwp.PanelTopology{Json: agg.mergedTopology()}
+		}
+
+		if proto.Size(msg) > 0 {
+			forward = append(forward, msg)
+		}
+	}
+	return forward
+}
+
+// flow returns BSY or RDY if the combined busy state has changed since last reported. Must be called with agg.mu locked.
+func (agg *aggregator) flow() rwp.OutboundMessage_FlowMsg {
+	busy := false
+	for _, panel := range agg.panels {
+		busy = busy || panel.busy
+	}
+	if busy == agg.busy {
+		return 0
+	}
+	agg.busy = busy
+	if busy {
+		return rwp.OutboundMessage_BSY
+	}
+	return rwp.OutboundMessage_RDY
+}
+
+// fromSystem routes states to the panels owning the HWCs and copies everything else to all panels
+func (agg *aggregator) fromSystem(msgs []*rwp.InboundMessage) {
+	var toSystems []*rwp.OutboundMessage
+
+	agg.mu.Lock()
+	toPanels := make([][]*rwp.InboundMessage, len(agg.panels))
+	for _, msg := range msgs {
+		msg = proto.Clone(msg).(*rwp.InboundMessage)
+
+		if msg.FlowMessage == rwp.InboundMessage_PING {
+			toSystems = append(toSystems, &rwp.OutboundMessage{FlowMessage: rwp.OutboundMessage_ACK})
+		}
+		msg.FlowMessage = 0 // The panels are pinged by their own connections
+
+		if msg.Command != nil && msg.Command.ActivatePanel {
+			agg.activated = true
+		}
+
+		states := make([][]*rwp.HWCState, len(agg.panels))
+		for _, state := range msg.States {
+			hwcs := make([][]uint32, len(agg.panels))
+			for _, hwc := range state.HWCIDs {
+				index := int(hwc / agg.offset)
+				if index < len(agg.panels) && hwc%agg.offset != 0 {
+					hwcs[index] = append(hwcs[index], hwc%agg.offset)
+				}
+			}
+			for index := range agg.panels {
+				if len(hwcs[index]) > 0 {
+					panelState := proto.Clone(state).(*rwp.HWCState)
+					panelState.HWCIDs = hwcs[index]
+					states[index] = append(states[index], panelState)
+				}
+			}
+		}
+		msg.States = nil
+
+		for index := range agg.panels {
+			panelMsg := msg
+			if len(states[index]) > 0 {
+				panelMsg = proto.Clone(msg).(*rwp.InboundMessage)
+				panelMsg.States = states[index]
+			}
+			if proto.Size(panelMsg) > 0 {
+				toPanels[index] = append(toPanels[index], panelMsg)
+			}
+		}
+	}
+
+	agg.mu.Unlock()
+
+	// Sent without the lock, a panel connection may be waiting for it to report a disconnect
+	if len(toSystems) > 0 {
+		agg.toSystems <- toSystems
+	}
+	for index, panel := range agg.panels {
+		if len(toPanels[index]) > 0 {
+			panel.toPanel <- toPanels[index]
+		}
+	}
+}
+
+// availability combines the HWCavailability of all connected panels. Must be called with agg.mu locked.
+func (agg *aggregator) availability() map[uint32]uint32 {
+	availability := make(map[uint32]uint32)
+	for _, panel := range agg.panels {
+		for hwc, value := range panel.availability {
+			availability[hwc] = value
+		}
+	}
+	return availability
+}
+
+// panelInfo describes the virtual panel from the info of the panels known so far. Must be called with agg.mu locked.
+func (agg *aggregator) panelInfo() *rwp.PanelInfo {
+	var info *rwp.PanelInfo
+	models, serials, names := []string{}, []string{}, []string{}
+	for _, panel := range agg.panels {
+		if panel.info == nil {
+			continue
+		}
+		if info == nil {
+			info = proto.Clone(panel.info).(*rwp.PanelInfo)
+		}
+		models = append(models, panel.info.Model)
+		serials = append(serials, panel.info.Serial)
+		names = append(names, panel.info.Name)
+	}
+
+	info.Model = strings.Join(models, "+")
+	info.Serial = strings.Join(serials, "+")
+	info.Name = strings.Join(names, " + ")
+	info.PanelType = rwp.PanelInfo_COMPOSITE
+	return info
+}
+
+// mergedTopology places the topologies of the panels known so far next to each other (or below each other in column layout).
+// Types which differ between panels under the same number are renumbered. Must be called with agg.mu locked.
+func (agg *aggregator) mergedTopology() string {
+	merged := &topologyJSON{TypeIndex: make(map[uint32]json.RawMessage)}
+	titles := []string{}
+	shift := 0
+
+	for _, panel := range agg.panels {
+		if panel.topology == nil {
+			continue
+		}
+		if panel.topology.Title != "" {
+			titles = append(titles, panel.topology.Title)
+		}
+
+		typeIDs := make([]uint32, 0, len(panel.topology.TypeIndex))
+		for typeID := range panel.topology.TypeIndex {
+			typeIDs = append(typeIDs, typeID)
+		}
+		sort.Slice(typeIDs, func(i, j int) bool { return typeIDs[i] < typeIDs[j] })
+
+		renumbered := make(map[uint32]uint32)
+		for _, typeID := range typeIDs {
+			typeDef := panel.topology.TypeIndex[typeID]
+			newID := typeID
+			if existing, taken := merged.TypeIndex[typeID]; taken && !bytes.Equal(existing, typeDef) {
+				newID = typeID + 1
+				for merged.TypeIndex[newID] != nil || panel.topology.TypeIndex[newID] != nil {
+					newID++
+				}
+			}
+			merged.TypeIndex[newID] = typeDef
+			renumbered[typeID] = newID
+		}
+
+		extent := 0
+		for _, hwc := range panel.topology.HWc {
+			width, height := typeSize(panel.topology.TypeIndex[hwc.Type], hwc.TypeOverride)
+			if agg.column {
+				extent = max(extent, hwc.Y+height/2)
+				hwc.Y += shift
+			} else {
+				extent = max(extent, hwc.X+width/2)
+				hwc.X += shift
+			}
+
+			hwc.Id += panel.offset
+			if hwc.Type != 0 {
+				hwc.Type = renumbered[hwc.Type]
+			}
+			if hwc.UIparent != 0 {
+				hwc.UIparent += panel.offset
+			}
+			if hwc.UIyang != 0 {
+				hwc.UIyang += panel.offset
+			}
+			merged.HWc = append(merged.HWc, hwc)
+		}
+		shift += extent + panelGap
+	}
+	merged.Title = strings.Join(titles, " + ")
+
+	jsonData, err := json.Marshal(merged)
+	if err != nil {
+		fmt.Println("Merging topologies:", err)
+		return ""
+	}
+	return string(jsonData)
+}
+
+// typeSize returns the size of a component from its type, or the type override if given. Components without a height are circles.
+func typeSize(typeDef json.RawMessage, override *topology.TopologyHWcTypeDef) (width int, height int) {
+	if override != nil && override.W != 0 {
+		width, height = override.W, override.H
+	} else {
+		size := struct {
+			W int `json:"w"`
+			H int `json:"h"`
+		}{}
+		json.Unmarshal(typeDef, &size)
+		width, height = size.W, size.H
+	}
+	if height == 0 {
+		height = width
+	}
+	return width, height
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+)
+
+// newTestAggregator returns an aggregator of two panels with an offset of 1000, without connections
+func newTestAggregator(column bool) (*aggregator, chan []*rwp.OutboundMessage) {
+	toSystems := make(chan []*rwp.OutboundMessage, 10)
+	agg := &aggregator{offset: 1000, column: column, toSystems: toSystems}
+	for i, addr := range []string{"panel1:9923", "panel2:9923"} {
+		agg.panels = append(agg.panels, &aggregatedPanel{
+			addr:    addr,
+			offset:  uint32(i) * agg.offset,
+			toPanel: make(chan []*rwp.InboundMessage, 10),
+		})
+	}
+	return agg, toSystems
+}
+
+func TestAggregatorEventsAndAvailability(t *testing.T) {
+	agg, _ := newTestAggregator(false)
+
+	msgs := agg.fromPanel(agg.panels[1], []*rwp.OutboundMessage{{Events: []*rwp.HWCEvent{{HWCID: 5}, {HWCID: 1500}}}})
+	if len(msgs) != 1 || len(msgs[0].Events) != 1 || msgs[0].Events[0].HWCID != 1005 {
+		t.Fatalf("expected event on HWC 1005, got %v", msgs)
+	}
+
+	agg.fromPanel(agg.panels[0], []*rwp.OutboundMessage{{HWCavailability: map[uint32]uint32{1: 0, 2: 1}}})
+	msgs = agg.fromPanel(agg.panels[1], []*rwp.OutboundMessage{{HWCavailability: map[uint32]uint32{1: 2, 1500: 1}}})
+	expected := map[uint32]uint32{1: 0, 2: 1, 1001: 1002}
+	if len(msgs) != 1 || !reflect.DeepEqual(msgs[0].HWCavailability, expected) {
+		t.Fatalf("expected availability %v, got %v", expected, msgs)
+	}
+
+	agg.panels[0].availability = nil // As when the panel disconnects
+	if availability := agg.availability(); !reflect.DeepEqual(availability, map[uint32]uint32{1001: 1002}) {
+		t.Fatalf("unexpected availability %v", availability)
+	}
+}
+
+func TestAggregatorFlow(t *testing.T) {
+	agg, _ := newTestAggregator(false)
+
+	steps := []struct {
+		panel    int
+		flow     rwp.OutboundMessage_FlowMsg
+		expected rwp.OutboundMessage_FlowMsg // Zero if nothing should be forwarded
+	}{
+		{0, rwp.OutboundMessage_BSY, rwp.OutboundMessage_BSY},
+		{1, rwp.OutboundMessage_BSY, 0},
+		{0, rwp.OutboundMessage_RDY, 0}, // Panel 2 is still busy
+		{1, rwp.OutboundMessage_RDY, rwp.OutboundMessage_RDY},
+		{1, rwp.OutboundMessage_PING, 0},
+	}
+	for i, step := range steps {
+		msgs := agg.fromPanel(agg.panels[step.panel], []*rwp.OutboundMessage{{FlowMessage: step.flow}})
+		var flow rwp.OutboundMessage_FlowMsg
+		if len(msgs) > 0 {
+			flow = msgs[0].FlowMessage
+		}
+		if flow != step.expected {
+			t.Errorf("step %d: expected %v, got %v", i, step.expected, msgs)
+		}
+	}
+
+	select {
+	case msgs := <-agg.panels[1].toPanel:
+		if len(msgs) != 1 || msgs[0].FlowMessage != rwp.InboundMessage_ACK {
+			t.Errorf("expected ack to the panel, got %v", msgs)
+		}
+	default:
+		t.Error("the ping was not acked")
+	}
+}
+
+func TestAggregatorFromSystem(t *testing.T) {
+	agg, toSystems := newTestAggregator(false)
+
+	agg.fromSystem([]*rwp.InboundMessage{
+		{FlowMessage: rwp.InboundMessage_PING},
+		{Command: &rwp.Command{ActivatePanel: true}},
+		{States: []*rwp.HWCState{{HWCIDs: []uint32{3, 1003, 1000, 2005}, HWCMode: &rwp.HWCMode{State: rwp.HWCMode_ON}}}},
+	})
+
+	if msgs := <-toSystems; len(msgs) != 1 || msgs[0].FlowMessage != rwp.OutboundMessage_ACK {
+		t.Errorf("expected ack to the system, got %v", msgs)
+	}
+	if !agg.activated {
+		t.Error("expected the aggregator to be activated")
+	}
+	for i, panel := range agg.panels {
+		msgs := <-panel.toPanel
+		if len(msgs) != 2 || msgs[0].Command == nil || !msgs[0].Command.ActivatePanel {
+			t.Fatalf("panel %d: expected command and state, got %v", i+1, msgs)
+		}
+		if states := msgs[1].States; len(states) != 1 || !reflect.DeepEqual(states[0].HWCIDs, []uint32{3}) {
+			t.Errorf("panel %d: expected state for HWC 3, got %v", i+1, states)
+		}
+	}
+}
+
+func TestAggregatorMergedTopology(t *testing.T) {
+	topologies := []string{
+		`{"title":"A","HWc":[{"id":1,"x":100,"y":50,"txt":"","type":1}],"typeIndex":{"1":{"w":100,"h":40}}}`,
+		`{"title":"B","HWc":[{"id":1,"x":100,"y":50,"txt":"","type":1,"UIparent":2}],"typeIndex":{"1":{"w":50}}}`,
+	}
+
+	tests := []struct {
+		column bool
+		x, y   int // Expected position of HWC 1001
+	}{
+		{false, 100 + 150 + panelGap, 50}, // Right of panel A, which extends to x=150
+		{true, 100, 50 + 70 + panelGap},   // Below panel A, which extends to y=70
+	}
+	for _, test := range tests {
+		agg, _ := newTestAggregator(test.column)
+		var msgs []*rwp.OutboundMessage
+		for i, jsonData := range topologies {
+			msgs = agg.fromPanel(agg.panels[i], []*rwp.OutboundMessage{{PanelTopology: &rwp.PanelTopology{Json: jsonData}}})
+		}
+		if len(msgs) != 1 || msgs[0].PanelTopology == nil {
+			t.Fatalf("expected a topology, got %v", msgs)
+		}
+
+		merged := &topologyJSON{}
+		if err := json.Unmarshal([]byte(msgs[0].PanelTopology.Json), merged); err != nil {
+			t.Fatal(err)
+		}
+		if merged.Title != "A + B" || len(merged.HWc) != 2 {
+			t.Fatalf("unexpected topology %s", msgs[0].PanelTopology.Json)
+		}
+		hwc := merged.HWc[1]
+		if hwc.Id != 1001 || hwc.X != test.x || hwc.Y != test.y || hwc.UIparent != 1002 {
+			t.Errorf("column=%v: unexpected HWC %+v", test.column, hwc)
+		}
+		if hwc.Type != 2 || string(merged.TypeIndex[2]) != `{"w":50}` || string(merged.TypeIndex[1]) != `{"w":100,"h":40}` {
+			t.Errorf("column=%v: expected the second type to be renumbered, got type %d in %s", test.column, hwc.Type, msgs[0].PanelTopology.Json)
+		}
+	}
+}
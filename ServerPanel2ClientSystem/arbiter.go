@@ -0,0 +1,264 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+
+	"google.golang.org/protobuf/proto"
+
+	"rwptransport"
+)
+
+// Arbitration modes deciding which system may set the state of an HWC when several are connected
+const (
+	ArbitrateLastWriter = "last"     // Every state is forwarded, the last one sent to an HWC wins
+	ArbitratePriority   = "priority" // A state is dropped if a connected system with higher priority has set the HWC
+	ArbitrateRanges     = "ranges"   // Each system owns HWC ranges. HWCs in no range are open to all systems
+)
+
+// system is a connected system as seen by the arbiter
+type system struct {
+	addr     string
+	priority int
+	hwcs     map[uint32]bool // HWCs owned in ranges mode
+	toSystem chan []*rwp.OutboundMessage
+}
+
+// arbiter fans messages from the panel out to all systems and filters the states the systems send to the panel
+type arbiter struct {
+	mode       string
+	priorities map[string]int             // By system IP
+	ranges     map[string]map[uint32]bool // By system IP
+	claimed    map[uint32]bool            // All HWCs in a range
+
+	systems map[*system]bool
+	owners  map[uint32]*system // Priority mode: The system which last set an HWC
+	mu      sync.Mutex
+
+	offline   *rwptransport.OfflineScreen // Takes over the panel while no system is connected, if set
+	toPanel   chan<- []*rwp.InboundMessage
+	offlineMu sync.Mutex // Held from a system coming or going until the offline screen has been sent, so the screens are sent in order
+}
+
+func newArbiter(mode string, priorities settingsFlag, ranges settingsFlag) (*arbiter, error) {
+	arb := &arbiter{
+		mode:       mode,
+		priorities: make(map[string]int),
+		ranges:     make(map[string]map[uint32]bool),
+		claimed:    make(map[uint32]bool),
+		systems:    make(map[*system]bool),
+		owners:     make(map[uint32]*system),
+	}
+
+	switch mode {
+	case ArbitrateLastWriter, ArbitratePriority, ArbitrateRanges:
+	default:
+		return nil, fmt.Errorf("unknown arbitration mode %q", mode)
+	}
+
+	for ip, value := range priorities {
+		priority, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("priority for %s: %w", ip, err)
+		}
+		arb.priorities[ip] = priority
+	}
+	for ip, value := range ranges {
+		hwcs, err := rwptransport.ParseHWCList(value)
+		if err != nil {
+			return nil, fmt.Errorf("HWCs for %s: %w", ip, err)
+		}
+		arb.ranges[ip] = make(map[uint32]bool)
+		for _, hwc := range hwcs {
+			if arb.claimed[hwc] {
+				return nil, fmt.Errorf("HWC %d is given to more than one system", hwc)
+			}
+			arb.claimed[hwc] = true
+			arb.ranges[ip][hwc] = true
+		}
+	}
+	return arb, nil
+}
+
+// showOffline makes the arbiter take over the panel with screen while no system is connected
+func (arb *arbiter) showOffline(screen *rwptransport.OfflineScreen, toPanel chan<- []*rwp.InboundMessage) {
+	arb.mu.Lock()
+	defer arb.mu.Unlock()
+	arb.offline = screen
+	arb.toPanel = toPanel
+}
+
+// sendOffline queues messages from the offline screen for the panel. Must be called with arb.offlineMu locked and arb.mu unlocked,
+// so a stalled panel holds up systems coming and going only, not the messages of the connected systems.
+func (arb *arbiter) sendOffline(msgs []*rwp.InboundMessage) {
+	if len(msgs) > 0 {
+		arb.toPanel <- msgs
+	}
+}
+
+// addSystem registers a new connection. Messages for it are queued on the returned system's toSystem channel.
+func (arb *arbiter) addSystem(addr string) *system {
+	ip := addr
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		ip = host
+	}
+
+	sys := &system{
+		addr:     addr,
+		priority: arb.priorities[ip],
+		hwcs:     arb.ranges[ip],
+		toSystem: make(chan []*rwp.OutboundMessage, 10),
+	}
+
+	arb.offlineMu.Lock()
+	arb.mu.Lock()
+	arb.systems[sys] = true
+	count := len(arb.systems)
+	var restore []*rwp.InboundMessage
+	if count == 1 {
+		restore = arb.offline.SystemBack()
+	}
+	arb.mu.Unlock()
+	arb.sendOffline(restore) // Before the system's own messages, as it isn't read from until this returns
+	arb.offlineMu.Unlock()
+
+	switch arb.mode {
+	case ArbitratePriority:
+		fmt.Printf("System: %s has priority %d\n", addr, sys.priority)
+	case ArbitrateRanges:
+		if len(sys.hwcs) == 0 {
+			fmt.Println("System: " + addr + " owns no HWCs, it can only set HWCs which are not in a range")
+		}
+	}
+	fmt.Println("Systems connected:", count)
+	return sys
+}
+
+// removeSystem unregisters a connection and releases the HWCs it had set
+func (arb *arbiter) removeSystem(sys *system) {
+	arb.offlineMu.Lock()
+	arb.mu.Lock()
+	delete(arb.systems, sys)
+	for hwc, owner := range arb.owners {
+		if owner == sys {
+			delete(arb.owners, hwc)
+		}
+	}
+	count := len(arb.systems)
+	var takeover []*rwp.InboundMessage
+	if count == 0 {
+		takeover = arb.offline.SystemLost()
+	}
+	arb.mu.Unlock()
+	arb.sendOffline(takeover)
+	arb.offlineMu.Unlock()
+
+	fmt.Println("Systems connected:", count)
+}
+
+// fanOut queues messages from the panel for all connected systems. A system which doesn't keep up loses messages rather than holding up the others.
+func (arb *arbiter) fanOut(msgs []*rwp.OutboundMessage) {
+	arb.mu.Lock()
+	defer arb.mu.Unlock()
+
+	for sys := range arb.systems {
+		select {
+		case sys.toSystem <- msgs:
+		default:
+			fmt.Println("System: " + sys.addr + " is not keeping up, dropped messages from the panel")
+		}
+	}
+}
+
+// filter removes the HWCs from states which sys is not allowed to set. Everything but states is passed on untouched.
+func (arb *arbiter) filter(sys *system, msgs []*rwp.InboundMessage) []*rwp.InboundMessage {
+	if arb.mode == ArbitrateLastWriter {
+		return msgs
+	}
+
+	arb.mu.Lock()
+	defer arb.mu.Unlock()
+
+	filtered := make([]*rwp.InboundMessage, 0, len(msgs))
+	for _, msg := range msgs {
+		if len(msg.States) == 0 {
+			filtered = append(filtered, msg)
+			continue
+		}
+
+		states := make([]*rwp.HWCState, 0, len(msg.States))
+		changed := false
+		for _, state := range msg.States {
+			hwcs := make([]uint32, 0, len(state.HWCIDs))
+			for _, hwc := range state.HWCIDs {
+				if arb.allowed(sys, hwc) {
+					hwcs = append(hwcs, hwc)
+				}
+			}
+			if len(hwcs) == len(state.HWCIDs) {
+				states = append(states, state)
+				continue
+			}
+			changed = true
+			if len(hwcs) > 0 {
+				state = proto.Clone(state).(*rwp.HWCState)
+				state.HWCIDs = hwcs
+				states = append(states, state)
+			}
+		}
+
+		if !changed {
+			filtered = append(filtered, msg)
+			continue
+		}
+		msg = proto.Clone(msg).(*rwp.InboundMessage)
+		msg.States = states
+		if proto.Size(msg) > 0 {
+			filtered = append(filtered, msg)
+		}
+	}
+	return filtered
+}
+
+// allowed tells if sys may set hwc, and takes ownership of it in priority mode. Must be called with arb.mu locked.
+func (arb *arbiter) allowed(sys *system, hwc uint32) bool {
+	switch arb.mode {
+	case ArbitratePriority:
+		owner := arb.owners[hwc]
+		if owner != nil && owner != sys && owner.priority > sys.priority {
+			return false
+		}
+		arb.owners[hwc] = sys
+		return true
+	case ArbitrateRanges:
+		return sys.hwcs[hwc] || !arb.claimed[hwc]
+	}
+	return true
+}
+
+// settingsFlag is a command line flag which can be given several times as "IP=value"
+type settingsFlag map[string]string
+
+func (s settingsFlag) String() string {
+	settings := make([]string, 0, len(s))
+	for ip, value := range s {
+		settings = append(settings, ip+"="+value)
+	}
+	sort.Strings(settings)
+	return strings.Join(settings, " ")
+}
+
+func (s settingsFlag) Set(setting string) error {
+	ip, value, found := strings.Cut(setting, "=")
+	if !found || ip == "" {
+		return fmt.Errorf("expected IP=value, got %q", setting)
+	}
+	s[ip] = value
+	return nil
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+
+	"rwptransport"
+)
+
+func TestSettingsFlag(t *testing.T) {
+	settings := settingsFlag{}
+	for _, setting := range []string{"10.0.0.2=5", "10.0.0.1=1-4", "10.0.0.2=7"} {
+		if err := settings.Set(setting); err != nil {
+			t.Fatalf("Set(%q): %v", setting, err)
+		}
+	}
+	if got := settings.String(); got != "10.0.0.1=1-4 10.0.0.2=7" {
+		t.Errorf("got %q", got)
+	}
+
+	for _, setting := range []string{"10.0.0.1", "=5", ""} {
+		if err := settings.Set(setting); err == nil {
+			t.Errorf("Set(%q) should fail", setting)
+		}
+	}
+}
+
+func TestNewArbiterErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		mode       string
+		priorities settingsFlag
+		ranges     settingsFlag
+	}{
+		{"unknown mode", "first", nil, nil},
+		{"bad priority", ArbitratePriority, settingsFlag{"10.0.0.1": "high"}, nil},
+		{"bad range", ArbitrateRanges, nil, settingsFlag{"10.0.0.1": "4-1"}},
+		{"overlapping ranges", ArbitrateRanges, nil, settingsFlag{"10.0.0.1": "1-4", "10.0.0.2": "4-8"}},
+	}
+	for _, test := range tests {
+		if _, err := newArbiter(test.mode, test.priorities, test.ranges); err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+	}
+}
+
+// stateFor is a message setting the color of hwcs
+func stateFor(hwcs ...uint32) []*rwp.InboundMessage {
+	return []*rwp.InboundMessage{{States: []*rwp.HWCState{{HWCIDs: hwcs, HWCColor: &rwp.HWCColor{ColorIndex: &rwp.ColorIndex{Index: rwp.ColorIndex_RED}}}}}}
+}
+
+// passedHWCs lists the HWCs left in the states of msgs after filtering
+func passedHWCs(msgs []*rwp.InboundMessage) []uint32 {
+	hwcs := []uint32{}
+	for _, msg := range msgs {
+		for _, state := range msg.States {
+			hwcs = append(hwcs, state.HWCIDs...)
+		}
+	}
+	return hwcs
+}
+
+func TestArbiterFilter(t *testing.T) {
+	type step struct {
+		system int // 0 or 1, for the systems at 10.0.0.1 and 10.0.0.2
+		hwcs   []uint32
+		passed []uint32
+	}
+	tests := []struct {
+		name       string
+		mode       string
+		priorities settingsFlag
+		ranges     settingsFlag
+		steps      []step
+	}{
+		{
+			name: "last writer",
+			mode: ArbitrateLastWriter,
+			steps: []step{
+				{0, []uint32{1, 2}, []uint32{1, 2}},
+				{1, []uint32{1, 2}, []uint32{1, 2}},
+				{0, []uint32{2}, []uint32{2}},
+			},
+		},
+		{
+			name:       "priority",
+			mode:       ArbitratePriority,
+			priorities: settingsFlag{"10.0.0.1": "10", "10.0.0.2": "1"},
+			steps: []step{
+				{1, []uint32{1, 2}, []uint32{1, 2}}, // Nobody set them before
+				{0, []uint32{1}, []uint32{1}},       // Higher priority takes over
+				{1, []uint32{1, 2}, []uint32{2}},    // HWC 1 belongs to the higher priority now
+				{1, []uint32{3}, []uint32{3}},
+			},
+		},
+		{
+			name:   "ranges",
+			mode:   ArbitrateRanges,
+			ranges: settingsFlag{"10.0.0.1": "1-4", "10.0.0.2": "5-8"},
+			steps: []step{
+				{0, []uint32{1, 5, 9}, []uint32{1, 9}},
+				{1, []uint32{1, 5, 9}, []uint32{5, 9}},
+				{1, []uint32{2, 3}, []uint32{}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		arb, err := newArbiter(test.mode, test.priorities, test.ranges)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		systems := []*system{arb.addSystem("10.0.0.1:50000"), arb.addSystem("10.0.0.2:50000")}
+		for i, step := range test.steps {
+			got := passedHWCs(arb.filter(systems[step.system], stateFor(step.hwcs...)))
+			if !reflect.DeepEqual(got, step.passed) {
+				t.Errorf("%s, step %d: expected %v to pass, got %v", test.name, i, step.passed, got)
+			}
+		}
+	}
+}
+
+func TestArbiterFilterKeepsOtherMessages(t *testing.T) {
+	arb, err := newArbiter(ArbitrateRanges, nil, settingsFlag{"10.0.0.2": "1-4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sys := arb.addSystem("10.0.0.1:50000")
+
+	msgs := []*rwp.InboundMessage{{Command: &rwp.Command{SendPanelInfo: true}}}
+	if got := arb.filter(sys, msgs); len(got) != 1 || got[0] != msgs[0] {
+		t.Errorf("expected the command to pass untouched, got %v", got)
+	}
+
+	// A message left with nothing in it is dropped, but the input is not modified:
+	msgs = stateFor(1, 2)
+	if got := arb.filter(sys, msgs); len(got) != 0 {
+		t.Errorf("expected the message to be dropped, got %v", got)
+	}
+	if !reflect.DeepEqual(msgs[0].States[0].HWCIDs, []uint32{1, 2}) {
+		t.Errorf("input was modified: %v", msgs[0].States[0].HWCIDs)
+	}
+}
+
+func TestArbiterRemoveSystemReleasesHWCs(t *testing.T) {
+	arb, err := newArbiter(ArbitratePriority, settingsFlag{"10.0.0.1": "10"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	high := arb.addSystem("10.0.0.1:50000")
+	low := arb.addSystem("10.0.0.2:50000")
+
+	arb.filter(high, stateFor(1))
+	if got := passedHWCs(arb.filter(low, stateFor(1))); len(got) != 0 {
+		t.Fatalf("expected HWC 1 to be blocked, got %v", got)
+	}
+	arb.removeSystem(high)
+	if got := passedHWCs(arb.filter(low, stateFor(1))); !reflect.DeepEqual(got, []uint32{1}) {
+		t.Fatalf("expected HWC 1 to be released, got %v", got)
+	}
+}
+
+func TestArbiterOfflineHandoff(t *testing.T) {
+	arb, err := newArbiter(ArbitrateLastWriter, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache := rwptransport.NewStateCache()
+	screen := rwptransport.NewOfflineScreen(cache, "System offline", "", "")
+	toPanel := make(chan []*rwp.InboundMessage, 10)
+	arb.showOffline(screen, toPanel)
+
+	expectToPanel := func(step string, check func([]*rwp.InboundMessage) bool) {
+		t.Helper()
+		select {
+		case msgs := <-toPanel:
+			if check == nil || !check(msgs) {
+				t.Errorf("%s: unexpected messages to the panel: %v", step, msgs)
+			}
+		default:
+			if check != nil {
+				t.Errorf("%s: expected messages to the panel", step)
+			}
+		}
+	}
+
+	first := arb.addSystem("10.0.0.1:50000")
+	expectToPanel("first system", func(msgs []*rwp.InboundMessage) bool {
+		return len(msgs) == 1 && msgs[0].Command != nil && msgs[0].Command.ClearAll
+	})
+	cache.Update(stateFor(3))
+
+	second := arb.addSystem("10.0.0.2:50000")
+	expectToPanel("second system", nil)
+	arb.removeSystem(first)
+	expectToPanel("one system left", nil)
+
+	arb.removeSystem(second)
+	expectToPanel("last system gone", func(msgs []*rwp.InboundMessage) bool {
+		return len(msgs) == 1 && msgs[0].Command != nil && msgs[0].Command.SendPanelTopology // No topology yet to take over the panel with
+	})
+
+	arb.addSystem("10.0.0.1:50001")
+	expectToPanel("system back", func(msgs []*rwp.InboundMessage) bool {
+		return len(msgs) == 2 && msgs[0].Command.GetClearAll() && reflect.DeepEqual(passedHWCs(msgs[1:]), []uint32{3})
+	})
+}
@@ -6,17 +6,22 @@ toolchain go1.23.0
 
 require (
 	github.com/SKAARHOJ/rawpanel-lib v1.4.0
-	github.com/s00500/env_logger v0.1.29
 	google.golang.org/protobuf v1.36.3
+	rwptransport v0.0.0
 )
 
 require (
 	github.com/SKAARHOJ/ibeam-lib-utils v1.0.0 // indirect
-	github.com/disintegration/gift v1.2.1 // indirect
+	github.com/antchfx/xpath v1.2.4 // indirect
 	github.com/mattn/go-colorable v0.1.14 // indirect
 	github.com/mattn/go-isatty v0.0.20 // indirect
+	github.com/s00500/env_logger v0.1.29 // indirect
 	github.com/sirupsen/logrus v1.9.3 // indirect
+	github.com/subchen/go-xmldom v1.1.2 // indirect
 	go.uber.org/atomic v1.11.0 // indirect
+	golang.org/x/exp v0.0.0-20230728194245-b0cb94b80691 // indirect
 	golang.org/x/sys v0.29.0 // indirect
 	golang.org/x/xerrors v0.0.0-20200804184101-5ec99f83aff1 // indirect
 )
+
+replace rwptransport => ../rwptransport
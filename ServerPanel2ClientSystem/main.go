@@ -1,248 +1,168 @@
 /*
-	Raw Panel ASCII / Binary converter
-	Facilitates a connection from a Raw Panel (Server Mode) Panel to a (TCP Client) System.
-	Uses protobuf format internally
+Raw Panel ASCII / Binary converter
+Facilitates a connection from a Raw Panel (Server Mode) Panel to a (TCP Client) System.
+Uses protobuf format internally
 
-	NOTICE:
-	- Currently doesn't support graphics in multiline incoming ASCII format!
+Several systems can be connected at the same time. Messages from the panel go to all of them.
+The states they send are arbitrated with -arbitration:
+- last: Every state is forwarded, the last system to set an HWC wins
+- priority: A system can't change HWCs set by a connected system with higher priority (-priority IP=N)
+- ranges: Each system owns the HWCs given with -hwcs IP=1-20,45. HWCs not given to any system are open to all
 
-	Distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
-	without even the implied warranty of MERCHANTABILITY or FITNESS FOR A
-	PARTICULAR PURPOSE. MIT License
+Several panels can be given, separated by commas. They are then presented to the systems as one virtual panel:
+HWC n of the i'th panel becomes HWC i*1000+n (see -panelOffset), and their topologies are merged side by side (or stacked with -layout column).
+
+With -offline, the connector takes over the panel while no system is connected: LEDs are dimmed and displays say the system is offline.
+When a system connects, the panel is restored to the last states sent by the systems.
+The same states are sent to the panel again when it reconnects (eg. after a reboot), unless -replay=false. Type "state" to print them.
+
+With -hwcMap, HWC numbers are translated between the panel and the systems (see rwptransport/hwcmap.go for the file format).
+Priorities and ranges refer to the HWC numbers of the systems.
+
+With -eventFilter, events from the panel are dropped, deadbanded, rate limited or coalesced before they are written to the systems,
+to keep faders and joysticks from flooding them (see rwptransport/filter.go for the file format). HWC numbers are those of the (virtual) panel.
+
+Distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+without even the implied warranty of MERCHANTABILITY or FITNESS FOR A
+PARTICULAR PURPOSE. MIT License
 */
 package main
 
 import (
 	"bufio"
-	"encoding/binary"
+	"context"
 	"flag"
 	"fmt"
-	"io"
 	"net"
+	"os"
 	"strconv"
 	"strings"
 	"time"
 
-	helpers "github.com/SKAARHOJ/rawpanel-lib"
 	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
-	log "github.com/s00500/env_logger"
 
-	"google.golang.org/protobuf/proto"
+	"rwptransport"
 )
 
 // Panel centric view:
 // Inbound TCP commands - from external system to SKAARHOJ panel
 // Outbound TCP commands - from panel to external system
-func connectToPanel(panelIPAndPort string, incoming chan []*rwp.InboundMessage, outgoing chan []*rwp.OutboundMessage, binaryPanel bool) {
-
-	for {
-		fmt.Println("Trying to connect to panel on " + panelIPAndPort + "...")
-		c, err := net.Dial("tcp", panelIPAndPort)
-
-		if err != nil {
-			fmt.Println(err)
-			time.Sleep(time.Second * 3)
-		} else {
-			fmt.Println("Success - Connected to panel")
-			quit := make(chan bool)
-			go func() {
-				for {
-					select {
-					case <-quit:
-						close(quit)
-						return
-					case incomingMessages := <-incoming:
-
-						//su.Debug(outboundMessages)
-						if binaryPanel {
-							for _, msg := range incomingMessages {
-								pbdata, err := proto.Marshal(msg)
-								log.Should(err)
-								header := make([]byte, 4)                                  // Create a 4-bytes header
-								binary.LittleEndian.PutUint32(header, uint32(len(pbdata))) // Fill it in
-								pbdata = append(header, pbdata...)                         // and concatenate it with the binary message
-								//log.Println("System -> Panel: ", pbdata)
-								_, err = c.Write(pbdata)
-								log.Should(err)
-							}
-						} else {
-							lines := helpers.InboundMessagesToRawPanelASCIIstrings(incomingMessages)
-
-							for _, line := range lines {
-								publicMessage := string("System -> Panel: " + strings.TrimSpace(string(line)))
-								fmt.Println(publicMessage)
-								c.Write([]byte(line + "\n"))
-							}
-						}
-					}
-				}
-			}()
-
-			if binaryPanel {
-				for {
-					c.SetReadDeadline(time.Time{}) // Reset deadline, waiting for header
-					headerArray := make([]byte, 4)
-					_, err := io.ReadFull(c, headerArray) // Read 4 header bytes
-					if err != nil {
-						if err == io.EOF {
-							fmt.Println("Panel: " + c.RemoteAddr().String() + " disconnected")
-							time.Sleep(time.Second)
-						} else {
-							fmt.Println("Binary: ", err)
-						}
-						break
-					} else {
-						currentPayloadLength := binary.LittleEndian.Uint32(headerArray[0:4])
-						if currentPayloadLength < 500000 {
-							payload := make([]byte, currentPayloadLength)
-							c.SetReadDeadline(time.Now().Add(2 * time.Second)) // Set a deadline that we want all data within at most 2 seconds. This helps a run-away scenario where not all data arrives or we read the wront (and too big) header
-							_, err := io.ReadFull(c, payload)
-							if err != nil {
-								fmt.Println(err)
-								break
-							} else {
-								outcomingMessage := &rwp.OutboundMessage{}
-								proto.Unmarshal(payload, outcomingMessage)
-								outgoing <- []*rwp.OutboundMessage{outcomingMessage}
-							}
-						} else {
-							fmt.Println("Error: Payload", currentPayloadLength, "exceed limit")
-							break
-						}
-					}
-				}
-			} else {
-				connectionReader := bufio.NewReader(c) // Define OUTSIDE the for loop
-				for {
-					netData, err := connectionReader.ReadString('\n')
-					if err != nil {
-						if err == io.EOF {
-							fmt.Println("Panel: " + c.RemoteAddr().String() + " disconnected")
-							time.Sleep(time.Second)
-						} else {
-							fmt.Println(err)
-						}
-						break
-					} else {
-						outgoing <- helpers.RawPanelASCIIstringsToOutboundMessages([]string{strings.TrimSpace(netData)})
-					}
-				}
+// pingPeriod and onEvent are optional
+func connectToPanel(ctx context.Context, panelIPAndPort string, incoming chan []*rwp.InboundMessage, outgoing chan []*rwp.OutboundMessage, binaryPanel bool, autoPanel bool, recorder *rwptransport.Recorder, pingPeriod time.Duration, onEvent func(rwptransport.Event)) {
+	config := rwptransport.Config{
+		Encoding:       rwptransport.EncodingFromFlag(binaryPanel),
+		DetectEncoding: autoPanel,
+		Recorder:       recorder,
+		PingPeriod:     pingPeriod,
+		OnEvent: func(e rwptransport.Event) {
+			if onEvent != nil {
+				defer onEvent(e)
 			}
-
-			quit <- true
-			c.Close()
-		}
+			switch e.Type {
+			case rwptransport.Connected:
+				fmt.Println("Success - Connected to panel on " + e.Addr + " (" + e.Encoding.String() + ")")
+			case rwptransport.Disconnected:
+				fmt.Println("Panel: " + e.Addr + " disconnected")
+			case rwptransport.DialFailed:
+				fmt.Println(e.Err)
+				fmt.Println("Trying to connect to panel on " + panelIPAndPort + "...")
+			case rwptransport.ReadError:
+				fmt.Println("Panel:", e.Err)
+			}
+		},
+		OnWrite: func(line []byte, encoding rwptransport.Encoding) {
+			if encoding == rwptransport.ASCII {
+				fmt.Println("System -> Panel: " + strings.TrimSpace(string(line)))
+			}
+		},
 	}
+
+	fmt.Println("Trying to connect to panel on " + panelIPAndPort + "...")
+	rwptransport.DialPanel(ctx, panelIPAndPort, incoming, outgoing, config)
 }
 
-func connectToSystem(c net.Conn, incoming chan []*rwp.InboundMessage, outgoing chan []*rwp.OutboundMessage, binarySystem bool) {
+func connectToSystem(ctx context.Context, c net.Conn, incoming chan []*rwp.InboundMessage, arb *arbiter, hwcMap *rwptransport.HWCMap, cache *rwptransport.StateCache, binarySystem bool, autoSystem bool) {
 
 	fmt.Println("Success - TCP Connection from a system at " + c.RemoteAddr().String() + "...")
-	var rwpASCIIreader helpers.ASCIIreader
 
-	quit := make(chan bool)
+	sys := arb.addSystem(c.RemoteAddr().String())
+	fromSystem := make(chan []*rwp.InboundMessage, 10)
 	go func() {
-		for {
-			select {
-			case <-quit:
-				close(quit)
-				return
-			case outboundMessages := <-outgoing:
-
-				//su.Debug(outboundMessages)
-				if binarySystem {
-					for _, msg := range outboundMessages {
-						pbdata, err := proto.Marshal(msg)
-						log.Should(err)
-						header := make([]byte, 4)                                  // Create a 4-bytes header
-						binary.LittleEndian.PutUint32(header, uint32(len(pbdata))) // Fill it in
-						pbdata = append(header, pbdata...)                         // and concatenate it with the binary message
-						fmt.Println("Panel -> System: ", pbdata)
-						_, err = c.Write(pbdata)
-						log.Should(err)
-					}
-				} else {
-					lines := helpers.OutboundMessagesToRawPanelASCIIstrings(outboundMessages)
-
-					for _, line := range lines {
-						publicMessage := string("Panel -> System: " + strings.TrimSpace(string(line)))
-						fmt.Println(publicMessage)
-						c.Write([]byte(line + "\n"))
-					}
-				}
+		for msgs := range fromSystem {
+			if msgs = hwcMap.SystemToPanel(arb.filter(sys, msgs)); len(msgs) > 0 {
+				cache.Update(msgs)
+				incoming <- msgs
 			}
 		}
 	}()
 
-	if binarySystem {
-		for {
-			c.SetReadDeadline(time.Time{}) // Reset deadline, waiting for header
-			headerArray := make([]byte, 4)
-			_, err := io.ReadFull(c, headerArray) // Read 4 header bytes
-			if err != nil {
-				if err == io.EOF {
-					fmt.Println("System: " + c.RemoteAddr().String() + " disconnected")
-					time.Sleep(time.Second)
-				} else {
-					fmt.Println("Binary: ", err)
-				}
-				break
-			} else {
-				currentPayloadLength := binary.LittleEndian.Uint32(headerArray[0:4])
-				if currentPayloadLength < 500000 {
-					payload := make([]byte, currentPayloadLength)
-					c.SetReadDeadline(time.Now().Add(2 * time.Second)) // Set a deadline that we want all data within at most 2 seconds. This helps a run-away scenario where not all data arrives or we read the wront (and too big) header
-					_, err := io.ReadFull(c, payload)
-					if err != nil {
-						fmt.Println(err)
-						break
-					} else {
-						incomingMessage := &rwp.InboundMessage{}
-						proto.Unmarshal(payload, incomingMessage)
-						incoming <- []*rwp.InboundMessage{incomingMessage}
-					}
-				} else {
-					fmt.Println("Error: Payload", currentPayloadLength, "exceed limit")
-					break
-				}
+	rwptransport.ServeSystem(ctx, c, sys.toSystem, fromSystem, rwptransport.Config{
+		Encoding:           rwptransport.EncodingFromFlag(binarySystem),
+		DetectEncoding:     autoSystem,
+		ReassembleGraphics: true,
+		OnEvent: func(e rwptransport.Event) {
+			switch e.Type {
+			case rwptransport.Connected:
+				fmt.Println("System: " + e.Addr + " uses " + e.Encoding.String() + " encoding")
+			case rwptransport.Disconnected:
+				fmt.Println("System: " + e.Addr + " disconnected")
+			case rwptransport.ReadError:
+				fmt.Println("System:", e.Err)
 			}
-		}
-	} else {
-		connectionReader := bufio.NewReader(c) // Define OUTSIDE the for loop
-		for {
-			netData, err := connectionReader.ReadString('\n')
-			if err != nil {
-				if err == io.EOF {
-					fmt.Println("System: " + c.RemoteAddr().String() + " disconnected")
-				} else {
-					fmt.Println(err)
-				}
-				break
+		},
+		OnWrite: func(data []byte, encoding rwptransport.Encoding) {
+			if encoding == rwptransport.Binary {
+				fmt.Println("Panel -> System "+sys.addr+": ", data)
 			} else {
-				inputString := strings.TrimSpace(netData)
-				//log.Debugln("System -> Panel: ", inputString)
+				fmt.Println("Panel -> System " + sys.addr + ": " + strings.TrimSpace(string(data)))
+			}
+		},
+	})
 
-				asciiConvertedMessages := rwpASCIIreader.Parse(inputString)
-				if asciiConvertedMessages != nil {
-					incoming <- asciiConvertedMessages
-				}
+	arb.removeSystem(sys)
+	close(fromSystem)
+}
+
+// console prints the cached states when "state" is typed
+func console(cache *rwptransport.StateCache) {
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		switch strings.TrimSpace(scanner.Text()) {
+		case "":
+		case "state":
+			for _, line := range cache.Dump() {
+				fmt.Println("State: " + line)
 			}
+		default:
+			fmt.Println("Commands: state (prints the states sent to the panel)")
 		}
 	}
-	quit <- true
-	c.Close()
 }
 
 func main() {
 
 	// Setting up and parsing command line parameters
-	binPanel := flag.Bool("binPanel", false, "Works with the panel in binary mode")
-	binSystem := flag.Bool("binSystem", false, "Works with the system in binary mode")
+	binPanel := flag.Bool("binPanel", false, "Works with the panel in binary mode (-binPanel=false for ASCII). If not given, the encoding is detected")
+	binSystem := flag.Bool("binSystem", false, "Works with the system in binary mode (-binSystem=false for ASCII). If not given, the encoding is detected")
+	sessionLog := flag.String("sessionLog", "", "Logs all messages to and from the panel to this file, for replay with RawPanelReplay")
+	arbitration := flag.String("arbitration", ArbitrateLastWriter, "How states from several systems are arbitrated: 'last', 'priority' or 'ranges'")
+	priorities := settingsFlag{}
+	flag.Var(priorities, "priority", "Priority of the system at an IP for -arbitration priority, eg. 192.168.10.250=2. Can be given several times, default is 0")
+	ranges := settingsFlag{}
+	flag.Var(ranges, "hwcs", "HWCs owned by the system at an IP for -arbitration ranges, eg. 192.168.10.250=1-20,45. Can be given several times")
+	hwcMapFile := flag.String("hwcMap", "", "File with rules to translate HWC numbers between the panel and the systems")
+	eventFilterFile := flag.String("eventFilter", "", "File with rules to drop, deadband, rate limit or coalesce events from the panel")
+	panelOffset := flag.Uint("panelOffset", 1000, "With several panels, the HWC numbers of each panel are offset by this much more than the previous one")
+	offlineScreen := flag.Bool("offline", false, "Dims the LEDs and shows a message on the displays of the panel while no system is connected")
+	offlineText := flag.String("offlineText", "System offline|Waiting for system|on port {port}", "Title and two text lines on the displays while no system is connected, separated by |")
+	offlineLEDs := flag.String("offlineLEDs", "dimmed", "LEDs while no system is connected: 'dimmed', 'off' or 'keep'")
+	replay := flag.Bool("replay", true, "Sends the last states from the systems to the panel again when it reconnects")
+	layout := flag.String("layout", "row", "With several panels, their topologies are merged side by side ('row') or stacked ('column')")
 	flag.Parse()
 
 	arguments := flag.Args()
 	if len(arguments) == 0 {
-		fmt.Println("usage: ServerPanel2ClientSystem [-binPanel -binSystem] [panelIP:port] [port for system connections]")
+		fmt.Println("usage: ServerPanel2ClientSystem [-binPanel -binSystem -sessionLog file -arbitration last|priority|ranges -hwcMap file -eventFilter file -offline] [panelIP:port[,panelIP:port...]] [port for system connections]")
 		fmt.Println("help:  ServerPanel2ClientSystem -h")
 		fmt.Println("")
 		return
@@ -256,14 +176,90 @@ func main() {
 	}
 
 	panelIPAndPort := string(arguments[0])
+	panelAddrs := strings.Split(panelIPAndPort, ",")
+	if len(panelAddrs) > 1 && (*panelOffset == 0 || (*layout != "row" && *layout != "column")) {
+		fmt.Println("panelOffset must be positive and layout 'row' or 'column'")
+		fmt.Println("")
+		return
+	}
+
+	arb, err := newArbiter(*arbitration, priorities, ranges)
+	if err != nil {
+		fmt.Println(err)
+		fmt.Println("")
+		return
+	}
+
+	cache := rwptransport.NewStateCache()
+	var offline *rwptransport.OfflineScreen
+	if *offlineScreen {
+		text := strings.Split(strings.ReplaceAll(*offlineText, "{port}", arguments[1])+"||", "|")
+		offline = rwptransport.NewOfflineScreen(cache, text[0], text[1], text[2])
+		if err := offline.SetLEDs(*offlineLEDs); err != nil {
+			fmt.Println(err)
+			fmt.Println("")
+			return
+		}
+	}
+
+	var hwcMap *rwptransport.HWCMap
+	if *hwcMapFile != "" {
+		hwcMap, err = rwptransport.LoadHWCMap(*hwcMapFile)
+		if err != nil {
+			fmt.Println(err)
+			fmt.Println("")
+			return
+		}
+	}
+
+	var eventFilter *rwptransport.EventFilter
+	if *eventFilterFile != "" {
+		eventFilter, err = rwptransport.LoadEventFilter(*eventFilterFile)
+		if err != nil {
+			fmt.Println(err)
+			fmt.Println("")
+			return
+		}
+	}
 
 	// Welcome message!
 	fmt.Println("Welcome to Raw Panel - Server Panel to Client System! Made by Kasper Skaarhoj (c) 2020-2022")
 	fmt.Println("Configuration:")
-	fmt.Println("  binPanel:  ", *binPanel)
-	fmt.Println("  binSystem: ", *binSystem)
+	autoPanel := !rwptransport.FlagPassed("binPanel")
+	autoSystem := !rwptransport.FlagPassed("binSystem")
+	if autoPanel {
+		fmt.Println("  binPanel:   auto detect")
+	} else {
+		fmt.Println("  binPanel:  ", *binPanel)
+	}
+	if autoSystem {
+		fmt.Println("  binSystem:  auto detect")
+	} else {
+		fmt.Println("  binSystem: ", *binSystem)
+	}
 	fmt.Println("  system port: ", portArg)
-	fmt.Println("Ready to accept TCP connections on port", int(portArg), "and facilitate communication to panel on "+panelIPAndPort+"...\n")
+	fmt.Println("  arbitration: ", *arbitration)
+	fmt.Println("  offline:     ", *offlineScreen)
+	fmt.Println("  replay:      ", *replay)
+	if len(priorities) > 0 {
+		fmt.Println("  priorities:  ", priorities)
+	}
+	if len(ranges) > 0 {
+		fmt.Println("  hwcs:        ", ranges)
+	}
+	if *hwcMapFile != "" {
+		fmt.Println("  hwcMap:      ", *hwcMapFile)
+	}
+	if *eventFilterFile != "" {
+		fmt.Println("  eventFilter: ", *eventFilterFile)
+	}
+	if len(panelAddrs) > 1 {
+		fmt.Println("  panels:      ", len(panelAddrs))
+		fmt.Println("  panelOffset: ", *panelOffset)
+		fmt.Println("  layout:      ", *layout)
+	}
+	fmt.Println("Ready to accept TCP connections on port", int(portArg), "and facilitate communication to panel on "+panelIPAndPort+"...")
+	fmt.Println("")
 
 	// Set up server:
 	PORT := ":" + arguments[1]
@@ -275,12 +271,55 @@ func main() {
 	}
 	defer l.Close()
 
+	var recorder *rwptransport.Recorder
+	if *sessionLog != "" {
+		var err error
+		recorder, err = rwptransport.CreateRecorder(*sessionLog)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		defer recorder.Close()
+	}
+
 	incoming := make(chan []*rwp.InboundMessage, 10)
 	outgoing := make(chan []*rwp.OutboundMessage, 10)
 
-	go connectToPanel(panelIPAndPort, incoming, outgoing, *binPanel)
+	// A panel (re)connecting is taken over right away while no system is connected, otherwise it gets the last states back:
+	arb.showOffline(offline, incoming)
+	panelEvents := func(e rwptransport.Event) {
+		if e.Type != rwptransport.Connected {
+			return
+		}
+		if msgs := offline.PanelConnected(); len(msgs) > 0 {
+			incoming <- msgs
+		} else if msgs := cache.Replay(); *replay && len(msgs) > 0 {
+			incoming <- msgs
+		}
+	}
 
-	// Looks for a single incoming connection from the system:
+	ctx := context.Background()
+	if len(panelAddrs) > 1 {
+		go aggregatePanels(ctx, panelAddrs, uint32(*panelOffset), *layout == "column", incoming, outgoing, *binPanel, autoPanel, recorder, panelEvents)
+	} else {
+		go connectToPanel(ctx, panelIPAndPort, incoming, outgoing, *binPanel, autoPanel, recorder, 0, panelEvents)
+	}
+
+	// Everything from the panel goes to all systems:
+	go func() {
+		for msgs := range eventFilter.Pipe(ctx, outgoing) {
+			if takeover := offline.FromPanel(msgs); len(takeover) > 0 {
+				incoming <- takeover
+			}
+			if msgs = hwcMap.PanelToSystem(msgs); len(msgs) > 0 {
+				arb.fanOut(msgs)
+			}
+		}
+	}()
+
+	go console(cache)
+
+	// Accepts any number of systems:
 	for {
 		c, err := l.Accept()
 		if err != nil {
@@ -288,8 +327,6 @@ func main() {
 			return
 		}
 
-		connectToSystem(c, incoming, outgoing, *binSystem)
+		go connectToSystem(ctx, c, incoming, arb, hwcMap, cache, *binSystem, autoSystem)
 	}
-
-	select {}
 }
@@ -8,7 +8,16 @@ It facilitates that the panel sends "list" to the system server (something a Uni
 It also sends "map" over to the panel from the server since this is usually done by the panels initiative in client mode.
 
 Other than that it just forwards messages between the panel and server, but translates into the intermediate Raw Panel protobuf format forth and back
-The consequence of this translation is that any graphics over multiple lines from the server side will NOT survive the translation as this gets parsed as individual lines: Graphics won't come through!
+Graphics sent over multiple ASCII lines from the system (HWCg#, HWCgRGB#, HWCgGray# with part index 0..k) are buffered until complete
+and forwarded as a single HWCGfx state, so images come through to both ASCII and binary panels.
+Both sides are pinged, and a side which hasn't sent anything (such as the ack for a ping) within -timeout is disconnected and dialed again,
+so a half-open connection doesn't go unnoticed. A status line with the round trip time of each side is printed every -statusPeriod.
+With -offline, the connector takes over the panel while the system is away: LEDs are dimmed and displays say the system is offline.
+When the system is back, the panel is restored to the last states the system sent.
+The same states are sent to the panel again when it reconnects (eg. after a reboot), unless -replay=false. Type "state" to print them.
+With -hwcMap, HWC numbers are translated between the panel and the system (see rwptransport/hwcmap.go for the file format).
+With -eventFilter, events from the panel are dropped, deadbanded, rate limited or coalesced before they are written to the system,
+to keep faders and joysticks from flooding it (see rwptransport/filter.go for the file format).
 
 Distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
 without even the implied warranty of MERCHANTABILITY or FITNESS FOR A
@@ -18,324 +27,159 @@ package main
 
 import (
 	"bufio"
-	"encoding/binary"
+	"context"
 	"flag"
 	"fmt"
-	"io"
-	"net"
+	"os"
 	"strings"
 	"time"
 
-	helpers "github.com/SKAARHOJ/rawpanel-lib"
 	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
 
-	"google.golang.org/protobuf/proto"
+	"rwptransport"
 )
 
-// Panel centric view:
-// Inbound TCP commands - from external system to SKAARHOJ panel
-// Outbound TCP commands - from panel to external system
-func connectToPanel(panelIPAndPort string, incoming chan []*rwp.InboundMessage, outgoing chan []*rwp.OutboundMessage, binaryPanel bool) {
-
-	for {
-		fmt.Println("Trying to connect to panel on " + panelIPAndPort + "...")
-		c, err := net.Dial("tcp", panelIPAndPort)
-
-		if err != nil {
-			fmt.Println(err)
-			time.Sleep(time.Second * 3)
-		} else {
-			fmt.Println("Success - Connected to panel")
-			quit := make(chan bool)
-			go func() {
-				for {
-					select {
-					case <-quit:
-						close(quit)
-						return
-					case incomingMessages := <-incoming:
-
-						//su.Debug(outboundMessages)
-						if binaryPanel {
-							for _, msg := range incomingMessages {
-								pbdata, _ := proto.Marshal(msg)
-								fmt.Println("System -> Panel: ", pbdata)
-								header := make([]byte, 4)                                  // Create a 4-bytes header
-								binary.LittleEndian.PutUint32(header, uint32(len(pbdata))) // Fill it in
-								pbdata = append(header, pbdata...)                         // and concatenate it with the binary message
-								c.Write(pbdata)
-							}
-						} else {
-							lines := helpers.InboundMessagesToRawPanelASCIIstrings(incomingMessages)
-
-							for _, line := range lines {
-								fmt.Println(string("System -> Panel: " + strings.TrimSpace(string(line))))
-								c.Write([]byte(line + "\n"))
-							}
-						}
-					}
-				}
-			}()
-
-			ticker := time.NewTicker(500 * time.Millisecond)
-			done := make(chan bool)
-			go func() {
-				for {
-					select {
-					case <-done:
-						return
-					case t := <-ticker.C:
-						if binaryPanel {
-							msg := &rwp.InboundMessage{
-								FlowMessage: 1,
-							}
-							pbdata, _ := proto.Marshal(msg)
-							header := make([]byte, 4)                                  // Create a 4-bytes header
-							binary.LittleEndian.PutUint32(header, uint32(len(pbdata))) // Fill it in
-							pbdata = append(header, pbdata...)                         // and concatenate it with the binary message
-							c.Write(pbdata)
-						} else {
-							c.Write([]byte("ping\n"))
-						}
-
-						_ = t
-						//fmt.Println("Tick at", t)
-						fmt.Println(".")
-					}
-				}
-			}()
-
-			if binaryPanel {
-				for {
-					c.SetReadDeadline(time.Time{}) // Reset deadline, waiting for header
-					headerArray := make([]byte, 4)
-					_, err := io.ReadFull(c, headerArray) // Read 4 header bytes
-					if err != nil {
-						if err == io.EOF {
-							fmt.Println("Panel: " + c.RemoteAddr().String() + " disconnected")
-							time.Sleep(time.Second)
-						} else {
-							fmt.Println("Binary: ", err)
-						}
-						break
-					} else {
-						currentPayloadLength := binary.LittleEndian.Uint32(headerArray[0:4])
-						if currentPayloadLength < 500000 {
-							payload := make([]byte, currentPayloadLength)
-							c.SetReadDeadline(time.Now().Add(2 * time.Second)) // Set a deadline that we want all data within at most 2 seconds. This helps a run-away scenario where not all data arrives or we read the wront (and too big) header
-							_, err := io.ReadFull(c, payload)
-							if err != nil {
-								fmt.Println(err)
-								break
-							} else {
-								outgoingMessage := &rwp.OutboundMessage{}
-								proto.Unmarshal(payload, outgoingMessage)
-								if outgoingMessage.FlowMessage != 2 { // ack
-									outgoing <- []*rwp.OutboundMessage{outgoingMessage}
-								}
-							}
-						} else {
-							fmt.Println("Error: Payload", currentPayloadLength, "exceed limit")
-							break
-						}
-					}
-				}
+// Prints connection events of one side of the connector
+func printEvents(side string) func(rwptransport.Event) {
+	return func(e rwptransport.Event) {
+		switch e.Type {
+		case rwptransport.Connected:
+			fmt.Println("Success - Connected to " + strings.ToLower(side) + " on " + e.Addr + " (" + e.Encoding.String() + ")")
+		case rwptransport.Disconnected:
+			if e.Err != nil {
+				fmt.Println(side+": "+e.Addr+" disconnected:", e.Err)
 			} else {
-				connectionReader := bufio.NewReader(c) // Define OUTSIDE the for loop
-				for {
-					netData, err := connectionReader.ReadString('\n')
-					if err != nil {
-						if err == io.EOF {
-							fmt.Println("Panel: " + c.RemoteAddr().String() + " disconnected")
-							time.Sleep(time.Second)
-						} else {
-							fmt.Println(err)
-						}
-					} else {
-						netDataStr := strings.TrimSpace(netData)
-						switch netDataStr {
-						case "ack":
-						default:
-							outgoing <- helpers.RawPanelASCIIstringsToOutboundMessages([]string{netDataStr})
-						}
-					}
-				}
+				fmt.Println(side + ": " + e.Addr + " disconnected")
 			}
-
-			done <- true
-			quit <- true
-			c.Close()
+		case rwptransport.DialFailed:
+			fmt.Println(e.Err)
+			fmt.Println("Trying to connect to " + strings.ToLower(side) + " on " + e.Addr + "...")
+		case rwptransport.ReadError:
+			fmt.Println(side+":", e.Err)
 		}
 	}
 }
 
-func connectToSystem(systemIPAndPort string, incoming chan []*rwp.InboundMessage, outgoing chan []*rwp.OutboundMessage, binarySystem bool) {
-	var rwpASCIIreader helpers.ASCIIreader
+// Prints what is written to one side of the connector
+func printWrites(prefix string) func([]byte, rwptransport.Encoding) {
+	return func(data []byte, encoding rwptransport.Encoding) {
+		if encoding == rwptransport.Binary {
+			fmt.Println(prefix, data)
+		} else {
+			fmt.Println(prefix + strings.TrimSpace(string(data)))
+		}
+	}
+}
 
-	for {
-		fmt.Println("Trying to connect to system on " + systemIPAndPort + "...")
-		c, err := net.Dial("tcp", systemIPAndPort)
+// Panel centric view:
+// Inbound TCP commands - from external system to SKAARHOJ panel
+// Outbound TCP commands - from panel to external system
+func connectToPanel(ctx context.Context, panelIPAndPort string, incoming chan []*rwp.InboundMessage, fromPanel chan []*rwp.OutboundMessage, binaryPanel bool, autoPanel bool, recorder *rwptransport.Recorder, timeout time.Duration, status *rwptransport.Status, onEvent func(rwptransport.Event)) {
+	events := printEvents("Panel")
+
+	fmt.Println("Trying to connect to panel on " + panelIPAndPort + "...")
+	rwptransport.DialPanel(ctx, panelIPAndPort, incoming, fromPanel, rwptransport.Config{
+		Encoding:       rwptransport.EncodingFromFlag(binaryPanel),
+		DetectEncoding: autoPanel,
+		Recorder:       recorder,
+		PingPeriod:     500 * time.Millisecond,
+		Timeout:        timeout,
+		Status:         status,
+		OnEvent: func(e rwptransport.Event) {
+			events(e)
+			onEvent(e)
+		},
+		OnWrite: printWrites("System -> Panel: "),
+	})
+}
 
-		if err != nil {
-			fmt.Println(err)
-			time.Sleep(time.Second * 3)
-		} else {
-			fmt.Println("Success - Connected to system")
+func connectToSystem(ctx context.Context, systemIPAndPort string, fromSystem chan []*rwp.InboundMessage, outgoing chan []*rwp.OutboundMessage, binarySystem bool, timeout time.Duration, status *rwptransport.Status, onEvent func(rwptransport.Event)) {
+	events := printEvents("System")
+
+	fmt.Println("Trying to connect to system on " + systemIPAndPort + "...")
+	rwptransport.DialSystem(ctx, systemIPAndPort, outgoing, fromSystem, rwptransport.Config{
+		Encoding:           rwptransport.EncodingFromFlag(binarySystem),
+		PingPeriod:         1000 * time.Millisecond,
+		Timeout:            timeout,
+		Status:             status,
+		ReassembleGraphics: true,
+		OnEvent: func(e rwptransport.Event) {
+			events(e)
+			if e.Type == rwptransport.Connected {
+				outgoing <- []*rwp.OutboundMessage{{FlowMessage: rwp.OutboundMessage_HELLO}} // Initialize with system ("list" in ASCII)
+			}
+			onEvent(e)
+		},
+		OnWrite: printWrites("Panel -> System: "),
+	})
+}
 
-			if binarySystem {
-				msg := &rwp.OutboundMessage{
-					FlowMessage: 100, // Hello
+// Forwards messages between the two sides, taking care of the handshake parts the panel and system don't do themselves when both are in server mode
+func route(incoming chan []*rwp.InboundMessage, outgoing chan []*rwp.OutboundMessage, fromPanel <-chan []*rwp.OutboundMessage, fromSystem chan []*rwp.InboundMessage, hwcMap *rwptransport.HWCMap, offline *rwptransport.OfflineScreen, cache *rwptransport.StateCache) {
+	for {
+		select {
+		case outboundMessages := <-fromPanel:
+			if takeover := offline.FromPanel(outboundMessages); len(takeover) > 0 {
+				incoming <- takeover
+			}
+			forward := []*rwp.OutboundMessage{}
+			for _, msg := range outboundMessages {
+				if msg.FlowMessage != rwp.OutboundMessage_ACK { // Acks are for our pings
+					forward = append(forward, msg)
 				}
-				pbdata, _ := proto.Marshal(msg)
-				header := make([]byte, 4)                                  // Create a 4-bytes header
-				binary.LittleEndian.PutUint32(header, uint32(len(pbdata))) // Fill it in
-				pbdata = append(header, pbdata...)                         // and concatenate it with the binary message
-				c.Write(pbdata)
-			} else {
-				c.Write([]byte("list\n")) // Initialize with system
 			}
-
-			quit := make(chan bool)
-			go func() {
-				for {
-					select {
-					case <-quit:
-						close(quit)
-						return
-					case outboundMessages := <-outgoing:
-
-						//su.Debug(outboundMessages)
-						if binarySystem {
-							for _, msg := range outboundMessages {
-								pbdata, _ := proto.Marshal(msg)
-								fmt.Println("Panel -> System: ", pbdata)
-								header := make([]byte, 4)                                  // Create a 4-bytes header
-								binary.LittleEndian.PutUint32(header, uint32(len(pbdata))) // Fill it in
-								pbdata = append(header, pbdata...)                         // and concatenate it with the binary message
-								c.Write(pbdata)
-							}
-						} else {
-							lines := helpers.OutboundMessagesToRawPanelASCIIstrings(outboundMessages)
-
-							for _, line := range lines {
-								fmt.Println(string("Panel -> System: " + strings.TrimSpace(string(line))))
-								c.Write([]byte(line + "\n"))
-							}
-						}
-					}
+			if forward = hwcMap.PanelToSystem(forward); len(forward) > 0 {
+				outgoing <- forward
+			}
+		case inboundMessages := <-fromSystem:
+			forward := []*rwp.InboundMessage{}
+			for _, msg := range inboundMessages {
+				if msg.FlowMessage == rwp.InboundMessage_ACK { // Acks are for our pings
+					continue
 				}
-			}()
-
-			ticker := time.NewTicker(1000 * time.Millisecond)
-			done := make(chan bool)
-			go func() {
-				for {
-					select {
-					case <-done:
-						return
-					case t := <-ticker.C:
-						if binarySystem {
-							msg := &rwp.OutboundMessage{
-								FlowMessage: 1,
-							}
-							pbdata, _ := proto.Marshal(msg)
-							header := make([]byte, 4)                                  // Create a 4-bytes header
-							binary.LittleEndian.PutUint32(header, uint32(len(pbdata))) // Fill it in
-							pbdata = append(header, pbdata...)                         // and concatenate it with the binary message
-							c.Write(pbdata)
-						} else {
-							c.Write([]byte("ping\n"))
-						}
-						_ = t
-						//fmt.Println("Tick at", t)
-					}
+				forward = append(forward, msg)
+				if msg.Command != nil && msg.Command.ActivatePanel { // Ask the panel for "map" since it will only do that on its own initiative in client mode
+					forward = append(forward, &rwp.InboundMessage{
+						Command: &rwp.Command{
+							ReportHWCavailability: true,
+						},
+					})
 				}
-			}()
-
-			if binarySystem {
-				for {
-					c.SetReadDeadline(time.Time{}) // Reset deadline, waiting for header
-					headerArray := make([]byte, 4)
-					_, err := io.ReadFull(c, headerArray) // Read 4 header bytes
-					if err != nil {
-						if err == io.EOF {
-							fmt.Println("System: " + c.RemoteAddr().String() + " disconnected")
-							time.Sleep(time.Second)
-						} else {
-							fmt.Println("Binary: ", err)
-						}
-						break
-					} else {
-						currentPayloadLength := binary.LittleEndian.Uint32(headerArray[0:4])
-						if currentPayloadLength < 500000 {
-							payload := make([]byte, currentPayloadLength)
-							c.SetReadDeadline(time.Now().Add(2 * time.Second)) // Set a deadline that we want all data within at most 2 seconds. This helps a run-away scenario where not all data arrives or we read the wront (and too big) header
-							_, err := io.ReadFull(c, payload)
-							if err != nil {
-								fmt.Println(err)
-								break
-							} else {
-								incomingMessage := &rwp.InboundMessage{}
-								proto.Unmarshal(payload, incomingMessage)
-								inboundMessages := []*rwp.InboundMessage{incomingMessage}
-								if incomingMessage.FlowMessage != 2 { // ack
-									if incomingMessage.Command != nil && incomingMessage.Command.ActivatePanel {
-										inboundMessages = append(inboundMessages, &rwp.InboundMessage{
-											Command: &rwp.Command{
-												ReportHWCavailability: true,
-											},
-										})
-									}
-
-									incoming <- inboundMessages
-								}
-							}
-						} else {
-							fmt.Println("Error: Payload", currentPayloadLength, "exceed limit")
-							break
-						}
-					}
-				}
-			} else {
-				connectionReader := bufio.NewReader(c) // Define OUTSIDE the for loop
-				for {
-					netData, err := connectionReader.ReadString('\n')
-					if err != nil {
-						if err == io.EOF {
-							fmt.Println("System: " + c.RemoteAddr().String() + " disconnected")
-						} else {
-							fmt.Println(err)
-						}
-						break
-					} else {
-						netDataStr := strings.TrimSpace(netData)
-
-						inboundMessages := helpers.RawPanelASCIIstringsToInboundMessages([]string{netDataStr})
+			}
+			if forward = hwcMap.SystemToPanel(forward); len(forward) > 0 {
+				cache.Update(forward)
+				incoming <- forward
+			}
+		}
+	}
+}
 
-						switch netDataStr {
-						case "ack":
-						default:
-							if netDataStr == "ActivePanel=1" {
-								inboundMessages = append(inboundMessages, &rwp.InboundMessage{
-									Command: &rwp.Command{
-										ReportHWCavailability: true,
-									},
-								})
-							}
-							incoming <- inboundMessages
+// printStatus prints the state of both sides with the given period until ctx is done
+func printStatus(ctx context.Context, period time.Duration, panelStatus *rwptransport.Status, systemStatus *rwptransport.Status) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			fmt.Println("Status: Panel " + panelStatus.Snapshot().String() + " | System " + systemStatus.Snapshot().String())
+		}
+	}
+}
 
-							asciiConvertedMessages := rwpASCIIreader.Parse(netDataStr)
-							if asciiConvertedMessages != nil {
-								incoming <- asciiConvertedMessages
-							}
-						}
-					}
-				}
+// console prints the cached states when "state" is typed
+func console(cache *rwptransport.StateCache) {
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		switch strings.TrimSpace(scanner.Text()) {
+		case "":
+		case "state":
+			for _, line := range cache.Dump() {
+				fmt.Println("State: " + line)
 			}
-
-			done <- true
-			quit <- true
-			c.Close()
+		default:
+			fmt.Println("Commands: state (prints the states sent to the panel)")
 		}
 	}
 }
@@ -343,13 +187,22 @@ func connectToSystem(systemIPAndPort string, incoming chan []*rwp.InboundMessage
 func main() {
 
 	// Setting up and parsing command line parameters
-	binPanel := flag.Bool("binPanel", false, "Works with the panel in binary mode")
+	binPanel := flag.Bool("binPanel", false, "Works with the panel in binary mode (-binPanel=false for ASCII). If not given, the encoding is detected")
 	binSystem := flag.Bool("binSystem", false, "Works with the system in binary mode")
+	sessionLog := flag.String("sessionLog", "", "Logs all messages to and from the panel to this file, for replay with RawPanelReplay")
+	hwcMapFile := flag.String("hwcMap", "", "File with rules to translate HWC numbers between the panel and the system")
+	eventFilterFile := flag.String("eventFilter", "", "File with rules to drop, deadband, rate limit or coalesce events from the panel")
+	timeout := flag.Duration("timeout", 5*time.Second, "Reconnects to the panel or system if nothing is received from it for this long. 0 disables")
+	offlineScreen := flag.Bool("offline", false, "Dims the LEDs and shows a message on the displays of the panel while the system is disconnected")
+	offlineText := flag.String("offlineText", "System offline|Reconnecting to|{system}", "Title and two text lines on the displays while the system is disconnected, separated by |")
+	offlineLEDs := flag.String("offlineLEDs", "dimmed", "LEDs while the system is disconnected: 'dimmed', 'off' or 'keep'")
+	replay := flag.Bool("replay", true, "Sends the last states from the system to the panel again when it reconnects")
+	statusPeriod := flag.Duration("statusPeriod", 10*time.Second, "Prints the connection status with this period. 0 disables")
 	flag.Parse()
 
 	arguments := flag.Args()
 	if len(arguments) == 0 {
-		fmt.Println("usage: ServerPanel2ServerSystem [-binPanel -binSystem] [panelIP:port] [systemIP:port]")
+		fmt.Println("usage: ServerPanel2ServerSystem [-binPanel -binSystem -sessionLog file -hwcMap file -eventFilter file -timeout 5s -offline] [panelIP:port] [systemIP:port]")
 		fmt.Println("help:  ServerPanel2ServerSystem -h")
 		fmt.Println("")
 		return
@@ -361,16 +214,103 @@ func main() {
 	// Welcome message!
 	fmt.Println("Welcome to Raw Panel - Server Panel to Server System! Made by Kasper Skaarhoj (c) 2020-2022")
 	fmt.Println("Configuration:")
-	fmt.Println("  binPanel:  ", *binPanel)
+	autoPanel := !rwptransport.FlagPassed("binPanel")
+	if autoPanel {
+		fmt.Println("  binPanel:   auto detect")
+	} else {
+		fmt.Println("  binPanel:  ", *binPanel)
+	}
 	fmt.Println("  binSystem: ", *binSystem)
-	fmt.Println("Ready to facilitate communication between a panel and system, both in server mode. Starting to connect...\n")
+	fmt.Println("  timeout:   ", *timeout)
+	fmt.Println("  offline:   ", *offlineScreen)
+	fmt.Println("  replay:    ", *replay)
+	if *hwcMapFile != "" {
+		fmt.Println("  hwcMap:    ", *hwcMapFile)
+	}
+	if *eventFilterFile != "" {
+		fmt.Println("  eventFilter:", *eventFilterFile)
+	}
+	fmt.Print("Ready to facilitate communication between a panel and system, both in server mode. Starting to connect...\n\n")
+
+	var hwcMap *rwptransport.HWCMap
+	if *hwcMapFile != "" {
+		var err error
+		hwcMap, err = rwptransport.LoadHWCMap(*hwcMapFile)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+
+	var eventFilter *rwptransport.EventFilter
+	if *eventFilterFile != "" {
+		var err error
+		eventFilter, err = rwptransport.LoadEventFilter(*eventFilterFile)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+
+	cache := rwptransport.NewStateCache()
+	var offline *rwptransport.OfflineScreen
+	if *offlineScreen {
+		text := strings.Split(strings.ReplaceAll(*offlineText, "{system}", systemIPAndPort)+"||", "|")
+		offline = rwptransport.NewOfflineScreen(cache, text[0], text[1], text[2])
+		if err := offline.SetLEDs(*offlineLEDs); err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+
+	var recorder *rwptransport.Recorder
+	if *sessionLog != "" {
+		var err error
+		recorder, err = rwptransport.CreateRecorder(*sessionLog)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		defer recorder.Close()
+	}
 
 	// Set up server:
 	incoming := make(chan []*rwp.InboundMessage, 10)
 	outgoing := make(chan []*rwp.OutboundMessage, 10)
+	fromPanel := make(chan []*rwp.OutboundMessage, 10)
+	fromSystem := make(chan []*rwp.InboundMessage, 10)
+
+	ctx := context.Background()
+	panelStatus := &rwptransport.Status{}
+	systemStatus := &rwptransport.Status{}
+	toPanel := func(msgs []*rwp.InboundMessage) {
+		if len(msgs) > 0 {
+			incoming <- msgs
+		}
+	}
+	go connectToPanel(ctx, panelIPAndPort, incoming, fromPanel, *binPanel, autoPanel, recorder, *timeout, panelStatus, func(e rwptransport.Event) {
+		if e.Type != rwptransport.Connected {
+			return
+		}
+		if msgs := offline.PanelConnected(); len(msgs) > 0 {
+			toPanel(msgs)
+		} else if *replay {
+			toPanel(cache.Replay())
+		}
+	})
+	go connectToSystem(ctx, systemIPAndPort, fromSystem, outgoing, *binSystem, *timeout, systemStatus, func(e rwptransport.Event) {
+		switch e.Type {
+		case rwptransport.Connected:
+			toPanel(offline.SystemBack())
+		case rwptransport.Disconnected:
+			toPanel(offline.SystemLost())
+		}
+	})
+	if *statusPeriod > 0 {
+		go printStatus(ctx, *statusPeriod, panelStatus, systemStatus)
+	}
 
-	go connectToPanel(panelIPAndPort, incoming, outgoing, *binPanel)
-	go connectToSystem(systemIPAndPort, incoming, outgoing, *binSystem)
+	go console(cache)
 
-	select {}
+	route(incoming, outgoing, eventFilter.Pipe(ctx, fromPanel), fromSystem, hwcMap, offline, cache)
 }
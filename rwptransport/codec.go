@@ -0,0 +1,291 @@
+package rwptransport
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	helpers "github.com/SKAARHOJ/rawpanel-lib"
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// Encoding is the wire format of a Raw Panel connection
+type Encoding int
+
+const (
+	ASCII Encoding = iota
+	Binary
+)
+
+func (e Encoding) String() string {
+	if e == Binary {
+		return "binary"
+	}
+	return "ASCII"
+}
+
+// EncodingFromFlag maps the -binPanel / -binSystem flags of the tools to an Encoding
+func EncodingFromFlag(binary bool) Encoding {
+	if binary {
+		return Binary
+	}
+	return ASCII
+}
+
+// FlagPassed tells if a flag was given on the command line, as opposed to having its default value.
+// The tools use it to detect the encoding unless -binPanel / -binSystem is given explicitly.
+func FlagPassed(name string) bool {
+	passed := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			passed = true
+		}
+	})
+	return passed
+}
+
+// DecodeError is returned when a binary frame was read completely but could not be unmarshalled.
+// The stream is still in sync, so the connection can be kept.
+type DecodeError struct {
+	Payload []byte
+	Err     error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("could not decode %d byte payload: %v", len(e.Payload), e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// wire holds the encoding independent parts of a codec: serialized writes and buffered reads
+type wire struct {
+	Encoding Encoding
+	OnWrite  func(data []byte, encoding Encoding) // Optional. Called with every protobuf payload (binary) or line without line ending (ASCII) written
+
+	rw     io.ReadWriter
+	reader *bufio.Reader // Must live as long as the connection, otherwise it can skip content
+	mu     sync.Mutex
+}
+
+func newWire(rw io.ReadWriter, encoding Encoding) wire {
+	return wire{
+		Encoding: encoding,
+		rw:       rw,
+		reader:   bufio.NewReader(rw),
+	}
+}
+
+func (w *wire) writeFrames(msgs []proto.Message) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, msg := range msgs {
+		pbdata, err := proto.Marshal(msg)
+		if err != nil {
+			return err
+		}
+		if w.OnWrite != nil {
+			w.OnWrite(pbdata, Binary)
+		}
+		if _, err := w.rw.Write(EncodeFrame(pbdata)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *wire) writeLines(lines []string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, line := range lines {
+		if w.OnWrite != nil {
+			w.OnWrite([]byte(line), ASCII)
+		}
+		if _, err := w.rw.Write([]byte(line + "\n")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readFrame returns the next binary payload
+func (w *wire) readFrame() ([]byte, error) {
+	deadliner, _ := w.rw.(readDeadliner)
+	return readFrame(w.reader, deadliner)
+}
+
+// readLine returns the next ASCII line with surrounding white space removed
+func (w *wire) readLine() (string, error) {
+	netData, err := w.reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(netData), nil
+}
+
+// PanelCodec is the system side of a connection to a panel: It writes inbound messages and reads outbound messages.
+type PanelCodec struct {
+	wire
+}
+
+// NewPanelCodec returns a codec for a panel connection in the given encoding
+func NewPanelCodec(rw io.ReadWriter, encoding Encoding) *PanelCodec {
+	return &PanelCodec{wire: newWire(rw, encoding)}
+}
+
+// WriteMessages sends messages to the panel
+func (pc *PanelCodec) WriteMessages(msgs []*rwp.InboundMessage) error {
+	if pc.Encoding == Binary {
+		pbMsgs := make([]proto.Message, len(msgs))
+		for i, msg := range msgs {
+			pbMsgs[i] = msg
+		}
+		return pc.writeFrames(pbMsgs)
+	}
+	return pc.writeLines(helpers.InboundMessagesToRawPanelASCIIstrings(msgs))
+}
+
+// DetectEncoding probes the panel with a binary ping and sets Encoding from the first bytes of the reply, which are not consumed.
+// A binary panel answers with an ACK frame, which is consumed. An ASCII panel answers with a line or not at all within ProbeTimeout,
+// after which a newline is sent to clear the ping from its line buffer.
+func (pc *PanelCodec) DetectEncoding() (Encoding, error) {
+	pbdata, err := proto.Marshal(&rwp.InboundMessage{FlowMessage: rwp.InboundMessage_PING})
+	if err != nil {
+		return pc.Encoding, err
+	}
+
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	if _, err := pc.rw.Write(EncodeFrame(pbdata)); err != nil {
+		return pc.Encoding, err
+	}
+
+	if deadliner, ok := pc.rw.(readDeadliner); ok {
+		deadliner.SetReadDeadline(time.Now().Add(ProbeTimeout))
+		defer deadliner.SetReadDeadline(time.Time{})
+	}
+	header, err := pc.reader.Peek(4)
+	if err == nil && binary.LittleEndian.Uint32(header) < MaxPayloadLength {
+		pc.Encoding = Binary
+
+		// Swallow the ACK for our probe, it's not for whoever reads the messages:
+		frameLength := 4 + int(binary.LittleEndian.Uint32(header))
+		if frame, err := pc.reader.Peek(frameLength); err == nil {
+			reply := &rwp.OutboundMessage{}
+			if proto.Unmarshal(frame[4:], reply) == nil && proto.Equal(reply, &rwp.OutboundMessage{FlowMessage: rwp.OutboundMessage_ACK}) {
+				pc.reader.Discard(frameLength)
+			}
+		}
+		return pc.Encoding, nil
+	}
+	var netErr net.Error
+	if err != nil && !(errors.As(err, &netErr) && netErr.Timeout()) {
+		return pc.Encoding, err
+	}
+
+	pc.Encoding = ASCII
+	_, err = pc.rw.Write([]byte("\n"))
+	return pc.Encoding, err
+}
+
+// ReadMessages blocks until the next frame or line arrives from the panel.
+// Blank lines return an empty slice. A *DecodeError is not fatal to the connection.
+func (pc *PanelCodec) ReadMessages() ([]*rwp.OutboundMessage, error) {
+	if pc.Encoding == Binary {
+		payload, err := pc.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		msg := &rwp.OutboundMessage{}
+		if err := proto.Unmarshal(payload, msg); err != nil {
+			return nil, &DecodeError{Payload: payload, Err: err}
+		}
+		return []*rwp.OutboundMessage{msg}, nil
+	}
+
+	line, err := pc.readLine()
+	if err != nil {
+		return nil, err
+	}
+	return helpers.RawPanelASCIIstringsToOutboundMessages([]string{line}), nil
+}
+
+// SystemCodec is the panel side of a connection to a system: It writes outbound messages and reads inbound messages.
+type SystemCodec struct {
+	wire
+
+	// If set, ASCII lines are passed through this reader so graphics sent over multiple lines are assembled into a single message.
+	// Otherwise every line is converted on its own.
+	ASCIIreader *helpers.ASCIIreader
+}
+
+// NewSystemCodec returns a codec for a system connection in the given encoding
+func NewSystemCodec(rw io.ReadWriter, encoding Encoding) *SystemCodec {
+	return &SystemCodec{wire: newWire(rw, encoding)}
+}
+
+// DetectEncoding looks at the first four bytes from the system without consuming them and sets Encoding accordingly.
+// A binary frame starts with a little endian length below MaxPayloadLength, so its fourth byte is zero, which never happens on an ASCII line.
+// Blocks until four bytes have arrived.
+func (sc *SystemCodec) DetectEncoding() (Encoding, error) {
+	header, err := sc.reader.Peek(4)
+	if err != nil {
+		return sc.Encoding, err
+	}
+	if binary.LittleEndian.Uint32(header) < MaxPayloadLength {
+		sc.Encoding = Binary
+	} else {
+		sc.Encoding = ASCII
+	}
+	return sc.Encoding, nil
+}
+
+// WriteMessages sends messages to the system
+func (sc *SystemCodec) WriteMessages(msgs []*rwp.OutboundMessage) error {
+	if sc.Encoding == Binary {
+		pbMsgs := make([]proto.Message, len(msgs))
+		for i, msg := range msgs {
+			pbMsgs[i] = msg
+		}
+		return sc.writeFrames(pbMsgs)
+	}
+	return sc.writeLines(helpers.OutboundMessagesToRawPanelASCIIstrings(msgs))
+}
+
+// ReadMessages blocks until the next frame or line arrives from the system.
+// Blank lines and lines that are part of an unfinished graphics transfer return an empty slice. A *DecodeError is not fatal to the connection.
+func (sc *SystemCodec) ReadMessages() ([]*rwp.InboundMessage, error) {
+	if sc.Encoding == Binary {
+		payload, err := sc.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		msg := &rwp.InboundMessage{}
+		if err := proto.Unmarshal(payload, msg); err != nil {
+			return nil, &DecodeError{Payload: payload, Err: err}
+		}
+		return []*rwp.InboundMessage{msg}, nil
+	}
+
+	line, err := sc.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if sc.ASCIIreader != nil {
+		return sc.ASCIIreader.Parse(line), nil
+	}
+	return helpers.RawPanelASCIIstringsToInboundMessages([]string{line}), nil
+}
@@ -0,0 +1,269 @@
+package rwptransport
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	helpers "github.com/SKAARHOJ/rawpanel-lib"
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// loopback is a ReadWriter where reads return what was previously written
+type loopback struct {
+	bytes.Buffer
+}
+
+func TestPanelCodecBinary(t *testing.T) {
+	var buf loopback
+	codec := NewPanelCodec(&buf, Binary)
+
+	var traced [][]byte
+	codec.OnWrite = func(data []byte, encoding Encoding) { traced = append(traced, data) }
+
+	msg := &rwp.InboundMessage{Command: &rwp.Command{ActivatePanel: true}}
+	if err := codec.WriteMessages([]*rwp.InboundMessage{msg, {FlowMessage: rwp.InboundMessage_PING}}); err != nil {
+		t.Fatal(err)
+	}
+	if len(traced) != 2 {
+		t.Fatalf("expected 2 traced frames, got %d", len(traced))
+	}
+
+	// Read the frames back as the panel would:
+	for _, expected := range []*rwp.InboundMessage{msg, {FlowMessage: rwp.InboundMessage_PING}} {
+		payload, err := ReadFrame(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded := &rwp.InboundMessage{}
+		if err := proto.Unmarshal(payload, decoded); err != nil {
+			t.Fatal(err)
+		}
+		if !proto.Equal(decoded, expected) {
+			t.Fatalf("got %v, expected %v", decoded, expected)
+		}
+	}
+
+	// Reply from the panel:
+	pbdata, _ := proto.Marshal(&rwp.OutboundMessage{Events: []*rwp.HWCEvent{{HWCID: 7, Binary: &rwp.BinaryEvent{Pressed: true}}}})
+	buf.Write(EncodeFrame(pbdata))
+	msgs, err := codec.ReadMessages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || msgs[0].Events[0].HWCID != 7 || !msgs[0].Events[0].Binary.Pressed {
+		t.Fatalf("unexpected messages: %v", msgs)
+	}
+}
+
+func TestPanelCodecASCII(t *testing.T) {
+	var buf loopback
+	codec := NewPanelCodec(&buf, ASCII)
+
+	if err := codec.WriteMessages([]*rwp.InboundMessage{{FlowMessage: rwp.InboundMessage_PING}}); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "ping\n" {
+		t.Fatalf("got %q", buf.String())
+	}
+	buf.Reset()
+
+	buf.WriteString("HWC#12=Down\r\n\nack\n")
+	msgs, err := codec.ReadMessages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || msgs[0].Events[0].HWCID != 12 {
+		t.Fatalf("unexpected messages: %v", msgs)
+	}
+	if msgs, err = codec.ReadMessages(); err != nil || len(msgs) != 0 {
+		t.Fatalf("blank line should give no messages, got %v, %v", msgs, err)
+	}
+	if msgs, err = codec.ReadMessages(); err != nil || len(msgs) != 1 || msgs[0].FlowMessage != rwp.OutboundMessage_ACK {
+		t.Fatalf("expected ack, got %v, %v", msgs, err)
+	}
+	if _, err = codec.ReadMessages(); err != io.EOF {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+}
+
+func TestSystemCodecDecodeError(t *testing.T) {
+	var buf loopback
+	codec := NewSystemCodec(&buf, Binary)
+
+	buf.Write(EncodeFrame([]byte{0xFF, 0xFF, 0xFF}))
+	pbdata, _ := proto.Marshal(&rwp.InboundMessage{FlowMessage: rwp.InboundMessage_PING})
+	buf.Write(EncodeFrame(pbdata))
+
+	_, err := codec.ReadMessages()
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) {
+		t.Fatalf("expected DecodeError, got %v", err)
+	}
+
+	// The stream is still in sync after a bad payload:
+	msgs, err := codec.ReadMessages()
+	if err != nil || len(msgs) != 1 || msgs[0].FlowMessage != rwp.InboundMessage_PING {
+		t.Fatalf("expected ping, got %v, %v", msgs, err)
+	}
+}
+
+func TestSystemCodecASCIIHello(t *testing.T) {
+	var buf loopback
+	codec := NewSystemCodec(&buf, ASCII)
+
+	if err := codec.WriteMessages([]*rwp.OutboundMessage{{FlowMessage: rwp.OutboundMessage_HELLO}}); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "list\n" {
+		t.Fatalf("got %q", buf.String())
+	}
+}
+
+func TestSystemCodecDetectEncoding(t *testing.T) {
+	var buf loopback
+	codec := NewSystemCodec(&buf, ASCII)
+	pbdata, _ := proto.Marshal(&rwp.InboundMessage{FlowMessage: rwp.InboundMessage_PING})
+	buf.Write(EncodeFrame(pbdata))
+
+	if encoding, err := codec.DetectEncoding(); err != nil || encoding != Binary {
+		t.Fatalf("expected binary, got %v, %v", encoding, err)
+	}
+	msgs, err := codec.ReadMessages()
+	if err != nil || len(msgs) != 1 || msgs[0].FlowMessage != rwp.InboundMessage_PING {
+		t.Fatalf("expected ping, got %v, %v", msgs, err)
+	}
+
+	buf.Reset()
+	codec = NewSystemCodec(&buf, Binary)
+	buf.WriteString("\nlist\n")
+	if encoding, err := codec.DetectEncoding(); err != nil || encoding != ASCII {
+		t.Fatalf("expected ASCII, got %v, %v", encoding, err)
+	}
+	codec.ReadMessages() // Blank line
+	msgs, err = codec.ReadMessages()
+	if err != nil || len(msgs) != 1 || msgs[0].Command == nil || !msgs[0].Command.SendPanelInfo {
+		t.Fatalf("expected SendPanelInfo, got %v, %v", msgs, err)
+	}
+}
+
+func TestPanelCodecDetectEncoding(t *testing.T) {
+	// A binary panel acks the probe:
+	client, panel := net.Pipe()
+	go func() {
+		ReadFrame(panel)
+		pbdata, _ := proto.Marshal(&rwp.OutboundMessage{FlowMessage: rwp.OutboundMessage_ACK})
+		panel.Write(EncodeFrame(pbdata))
+		pbdata, _ = proto.Marshal(&rwp.OutboundMessage{FlowMessage: rwp.OutboundMessage_RDY})
+		panel.Write(EncodeFrame(pbdata))
+	}()
+	codec := NewPanelCodec(client, ASCII)
+	if encoding, err := codec.DetectEncoding(); err != nil || encoding != Binary {
+		t.Fatalf("expected binary, got %v, %v", encoding, err)
+	}
+	msgs, err := codec.ReadMessages()
+	if err != nil || len(msgs) != 1 || msgs[0].FlowMessage != rwp.OutboundMessage_RDY {
+		t.Fatalf("the ack should be consumed and the next message readable, got %v, %v", msgs, err)
+	}
+	client.Close()
+	panel.Close()
+
+	// An ASCII panel complains about the probe and gets a newline afterwards:
+	client, panel = net.Pipe()
+	defer client.Close()
+	defer panel.Close()
+	newline := make(chan []byte)
+	go func() {
+		io.ReadFull(panel, make([]byte, 6))
+		panel.Write([]byte("nack\n"))
+		buf := make([]byte, 1)
+		io.ReadFull(panel, buf)
+		newline <- buf
+	}()
+	codec = NewPanelCodec(client, Binary)
+	if encoding, err := codec.DetectEncoding(); err != nil || encoding != ASCII {
+		t.Fatalf("expected ASCII, got %v, %v", encoding, err)
+	}
+	if buf := <-newline; string(buf) != "\n" {
+		t.Fatalf("expected newline after probe, got %q", buf)
+	}
+	msgs, err = codec.ReadMessages()
+	if err != nil || len(msgs) != 1 || msgs[0].FlowMessage != rwp.OutboundMessage_NACK {
+		t.Fatalf("expected nack, got %v, %v", msgs, err)
+	}
+}
+
+func TestFlagPassed(t *testing.T) {
+	defer func(saved *flag.FlagSet) { flag.CommandLine = saved }(flag.CommandLine)
+	flag.CommandLine = flag.NewFlagSet("test", flag.ContinueOnError)
+	flag.Bool("binPanel", false, "")
+	flag.Bool("binSystem", false, "")
+	if err := flag.CommandLine.Parse([]string{"-binPanel=false"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if !FlagPassed("binPanel") {
+		t.Error("expected binPanel to be passed")
+	}
+	if FlagPassed("binSystem") {
+		t.Error("expected binSystem not to be passed")
+	}
+}
+
+func TestGraphicsReassembly(t *testing.T) {
+	var buf loopback
+	codec := NewSystemCodec(&buf, ASCII)
+	codec.ASCIIreader = &helpers.ASCIIreader{}
+
+	lines := []string{ // 64x48 test image in five parts
+		"HWCg#4=0/4,64x48://///////////////////8hCEIQhCEITyEIQhCEIQhP//////////8hCEIQhCEITyEIQhCEIQhPIQhCEIQhCE8hCEIQhCEIT//////////8=",
+		"HWCg#4=1:yEIQhCEIQhPIQhCEIQhCE8hCEIQhCEITyEIQhCEIQhP//////////8hCEIQhCEITyEIQhCEIQhPIABCEIQACE8gAAAQgAAIT/H4DAHDh8f8=",
+		"HWCg#4=2:yP4HAADjuBPIwA8AAeMYE8jAHxzD4xgTyPwbD4bjuBP4/jMPhuHw/8jGdwcM47gTyMZ/hw/zGBPIxgcPgOMYE8juAx3A47gT/HwDGMDh8f8=",
+		"HWCg#4=3:yAAAAAAAAhPIABAAAQACE8hCEIQhCEITyEIQhCEIQhP//////////8hCEIQhCEITyEIQhCEIQhPIQhCEIQhCE8hCEIQhCEIT//////////8=",
+		"HWCg#4=4:yEIQhCEIQhPIQhCEIQhCE8hCEIQhCEITyEIQhCEIQhP//////////8hCEIQhCEIT/////////////////////w==",
+	}
+	buf.WriteString(strings.Join(lines, "\n") + "\n")
+
+	var assembled []*rwp.InboundMessage
+	for range lines {
+		msgs, err := codec.ReadMessages()
+		if err != nil {
+			t.Fatal(err)
+		}
+		assembled = append(assembled, msgs...)
+	}
+	if len(assembled) != 1 || len(assembled[0].States) != 1 {
+		t.Fatalf("expected a single state message, got %v", assembled)
+	}
+	gfx := assembled[0].States[0].HWCGfx
+	if gfx == nil || gfx.W != 64 || gfx.H != 48 || len(gfx.ImageData) != 64*48/8 {
+		t.Fatalf("unexpected graphics %v", gfx)
+	}
+
+	// An ASCII panel gets it in parts again, a binary panel in one frame:
+	var panelBuf loopback
+	if err := NewPanelCodec(&panelBuf, ASCII).WriteMessages(assembled); err != nil {
+		t.Fatal(err)
+	}
+	if parts := strings.Count(panelBuf.String(), "HWCg#4="); parts < 2 {
+		t.Fatalf("expected graphics in several lines, got %q", panelBuf.String())
+	}
+	panelBuf.Reset()
+	if err := NewPanelCodec(&panelBuf, Binary).WriteMessages(assembled); err != nil {
+		t.Fatal(err)
+	}
+	payload, err := ReadFrame(&panelBuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := &rwp.InboundMessage{}
+	if err := proto.Unmarshal(payload, decoded); err != nil || !proto.Equal(decoded, assembled[0]) {
+		t.Fatalf("binary frame does not hold the assembled image: %v", err)
+	}
+}
@@ -0,0 +1,404 @@
+package rwptransport
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+
+	"google.golang.org/protobuf/proto"
+)
+
+/*
+Event filter files reduce the events from a panel before they are written to a system, one rule per line:
+
+	# Event types for HWCs which are never forwarded
+	drop raw 1-100
+	# Absolute, speed and raw values changing less than this from the last value forwarded are dropped
+	deadband absolute 10-15 8
+	# At most one event per period. The last value within the period is forwarded when the period ends
+	ratelimit absolute,speed 10-15 50ms
+	# Events are held for the window after the first, then only the last value is forwarded
+	coalesce absolute 20-25 20ms
+
+Event types are binary, pulsed, absolute, speed and raw (RawAnalog), or all. HWCs are numbered as the panel sends them.
+Rate limited and coalesced pulsed events are summed. Binary events can't be rate limited or coalesced, as presses would be lost.
+*/
+
+type eventKind int
+
+const (
+	binaryEvent eventKind = iota
+	pulsedEvent
+	absoluteEvent
+	speedEvent
+	rawAnalogEvent
+)
+
+var eventKinds = map[string]eventKind{
+	"binary":   binaryEvent,
+	"pulsed":   pulsedEvent,
+	"absolute": absoluteEvent,
+	"speed":    speedEvent,
+	"raw":      rawAnalogEvent,
+}
+
+type filterKey struct {
+	hwc  uint32
+	kind eventKind
+}
+
+type filterRule struct {
+	drop     bool
+	deadband int64
+	period   time.Duration
+	leading  bool // Rate limit: The first event is forwarded right away. Otherwise it's held for the period (coalesce)
+}
+
+// EventFilter drops, deadbands, rate limits and coalesces events from a panel. A nil *EventFilter passes everything through.
+type EventFilter struct {
+	rules map[filterKey]*filterRule
+}
+
+// LoadEventFilter reads an event filter file
+func LoadEventFilter(filename string) (*EventFilter, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	filter, err := ParseEventFilter(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return filter, nil
+}
+
+// ParseEventFilter reads event filter rules from r
+func ParseEventFilter(r io.Reader) (*EventFilter, error) {
+	filter := &EventFilter{rules: make(map[filterKey]*filterRule)}
+
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if comment := strings.Index(line, "#"); comment >= 0 {
+			line = strings.TrimSpace(line[:comment])
+		}
+		if line == "" {
+			continue
+		}
+		if err := filter.parseRule(strings.Fields(line)); err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return filter, nil
+}
+
+func (filter *EventFilter) parseRule(fields []string) error {
+	switch {
+	case fields[0] == "drop" && len(fields) != 3:
+		return fmt.Errorf("expected drop <event types> <HWCs>")
+	case fields[0] == "deadband" || fields[0] == "ratelimit" || fields[0] == "coalesce":
+		if len(fields) != 4 {
+			return fmt.Errorf("expected %s <event types> <HWCs> <value>", fields[0])
+		}
+	case fields[0] != "drop":
+		return fmt.Errorf("unknown rule %q, expected drop, deadband, ratelimit or coalesce", fields[0])
+	}
+
+	kinds := []eventKind{}
+	for _, name := range strings.Split(fields[1], ",") {
+		if name == "all" {
+			kinds = append(kinds, binaryEvent, pulsedEvent, absoluteEvent, speedEvent, rawAnalogEvent)
+			continue
+		}
+		kind, known := eventKinds[name]
+		if !known {
+			return fmt.Errorf("unknown event type %q", name)
+		}
+		kinds = append(kinds, kind)
+	}
+	hwcs, err := ParseHWCList(fields[2])
+	if err != nil {
+		return err
+	}
+
+	var deadband int64
+	var period time.Duration
+	switch fields[0] {
+	case "deadband":
+		if deadband, err = strconv.ParseInt(fields[3], 10, 32); err != nil || deadband <= 0 {
+			return fmt.Errorf("bad deadband %q", fields[3])
+		}
+	case "ratelimit", "coalesce":
+		if period, err = time.ParseDuration(fields[3]); err != nil || period <= 0 {
+			return fmt.Errorf("bad period %q", fields[3])
+		}
+	}
+
+	for _, kind := range kinds {
+		for _, hwc := range hwcs {
+			key := filterKey{hwc, kind}
+			rule := filter.rules[key]
+			if rule == nil {
+				rule = &filterRule{}
+				filter.rules[key] = rule
+			}
+			switch fields[0] {
+			case "drop":
+				rule.drop = true
+			case "deadband":
+				if kind == binaryEvent || kind == pulsedEvent {
+					if fields[1] == "all" {
+						continue
+					}
+					return fmt.Errorf("deadband only applies to absolute, speed and raw events")
+				}
+				rule.deadband = deadband
+			case "ratelimit", "coalesce":
+				if kind == binaryEvent {
+					if fields[1] == "all" {
+						continue
+					}
+					return fmt.Errorf("binary events can't be rate limited or coalesced")
+				}
+				if rule.period != 0 {
+					return fmt.Errorf("HWC %d is rate limited or coalesced twice", hwc)
+				}
+				rule.period = period
+				rule.leading = fields[0] == "ratelimit"
+			}
+		}
+	}
+	return nil
+}
+
+// Pipe filters the messages on in and delivers them on the returned channel until ctx is done or in is closed.
+// Messages without events pass right away, held events are delivered in messages of their own. A nil *EventFilter returns in.
+func (filter *EventFilter) Pipe(ctx context.Context, in <-chan []*rwp.OutboundMessage) <-chan []*rwp.OutboundMessage {
+	if filter == nil {
+		return in
+	}
+
+	out := make(chan []*rwp.OutboundMessage, 10)
+	go func() {
+		defer close(out)
+		run := newFilterRun(filter)
+		for {
+			var wake <-chan time.Time
+			var timer *time.Timer
+			if next := run.next(); !next.IsZero() {
+				timer = time.NewTimer(time.Until(next))
+				wake = timer.C
+			}
+
+			var msgs []*rwp.OutboundMessage
+			select {
+			case <-ctx.Done():
+				return
+			case received, ok := <-in:
+				if !ok {
+					return
+				}
+				msgs = run.filter(received, time.Now())
+			case now := <-wake:
+				msgs = run.flush(now)
+			}
+			if timer != nil {
+				timer.Stop()
+			}
+
+			if len(msgs) > 0 {
+				select {
+				case out <- msgs:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return out
+}
+
+// filterState is what is known about one event type of one HWC
+type filterState struct {
+	last      int64 // Last value forwarded
+	forwarded bool
+	pending   *rwp.HWCEvent
+	deadline  time.Time // End of the current period. Zero if none is running
+}
+
+// filterRun applies the rules of an EventFilter to a stream of messages
+type filterRun struct {
+	rules  map[filterKey]*filterRule
+	states map[filterKey]*filterState
+}
+
+func newFilterRun(filter *EventFilter) *filterRun {
+	return &filterRun{rules: filter.rules, states: make(map[filterKey]*filterState)}
+}
+
+// filter returns the messages with the events to forward now. The messages passed in are not modified.
+func (run *filterRun) filter(msgs []*rwp.OutboundMessage, now time.Time) []*rwp.OutboundMessage {
+	filtered := make([]*rwp.OutboundMessage, 0, len(msgs))
+	for _, msg := range msgs {
+		if len(msg.Events) == 0 {
+			filtered = append(filtered, msg)
+			continue
+		}
+
+		msg = proto.Clone(msg).(*rwp.OutboundMessage)
+		events := msg.Events[:0]
+		for _, event := range msg.Events {
+			if run.event(event, now) {
+				events = append(events, event)
+			}
+		}
+		msg.Events = events
+
+		if proto.Size(msg) > 0 {
+			filtered = append(filtered, msg)
+		}
+	}
+	return filtered
+}
+
+// event tells if an event is to be forwarded now. Otherwise it's dropped or held.
+func (run *filterRun) event(event *rwp.HWCEvent, now time.Time) bool {
+	kind, ok := kindOf(event)
+	if !ok {
+		return true
+	}
+	key := filterKey{event.HWCID, kind}
+	rule := run.rules[key]
+	if rule == nil {
+		return true
+	}
+	if rule.drop {
+		return false
+	}
+
+	state := run.states[key]
+	if state == nil {
+		state = &filterState{}
+		run.states[key] = state
+	}
+
+	if rule.period == 0 {
+		return run.pass(rule, state, event)
+	}
+	if rule.leading && (state.deadline.IsZero() || !now.Before(state.deadline)) {
+		if !run.pass(rule, state, event) {
+			return false
+		}
+		state.pending = nil
+		state.deadline = now.Add(rule.period)
+		return true
+	}
+
+	if state.deadline.IsZero() {
+		state.deadline = now.Add(rule.period)
+	}
+	if state.pending != nil && kind == pulsedEvent {
+		state.pending.Pulsed.Value += event.Pulsed.Value
+		state.pending.Timestamp = event.Timestamp
+	} else {
+		state.pending = proto.Clone(event).(*rwp.HWCEvent)
+	}
+	return false
+}
+
+// pass applies the deadband and remembers the value forwarded
+func (run *filterRun) pass(rule *filterRule, state *filterState, event *rwp.HWCEvent) bool {
+	value := eventValue(event)
+	if rule.deadband > 0 && state.forwarded && value > state.last-rule.deadband && value < state.last+rule.deadband {
+		return false
+	}
+	state.last = value
+	state.forwarded = true
+	return true
+}
+
+// next returns the end of the earliest running period, or zero if nothing is held
+func (run *filterRun) next() time.Time {
+	var next time.Time
+	for _, state := range run.states {
+		if !state.deadline.IsZero() && (next.IsZero() || state.deadline.Before(next)) {
+			next = state.deadline
+		}
+	}
+	return next
+}
+
+// flush returns a message with the held events whose period has ended, sorted by HWC and type, or nil if there are none
+func (run *filterRun) flush(now time.Time) []*rwp.OutboundMessage {
+	events := []*rwp.HWCEvent{}
+	for key, state := range run.states {
+		if state.deadline.IsZero() || now.Before(state.deadline) {
+			continue
+		}
+		rule := run.rules[key]
+		state.deadline = time.Time{}
+		if state.pending != nil && run.pass(rule, state, state.pending) {
+			events = append(events, state.pending)
+			if rule.leading { // What is forwarded starts a new period
+				state.deadline = now.Add(rule.period)
+			}
+		}
+		state.pending = nil
+	}
+	if len(events) == 0 {
+		return nil
+	}
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].HWCID != events[j].HWCID {
+			return events[i].HWCID < events[j].HWCID
+		}
+		kindI, _ := kindOf(events[i])
+		kindJ, _ := kindOf(events[j])
+		return kindI < kindJ
+	})
+	return []*rwp.OutboundMessage{{Events: events}}
+}
+
+func kindOf(event *rwp.HWCEvent) (eventKind, bool) {
+	switch {
+	case event.Binary != nil:
+		return binaryEvent, true
+	case event.Pulsed != nil:
+		return pulsedEvent, true
+	case event.Absolute != nil:
+		return absoluteEvent, true
+	case event.Speed != nil:
+		return speedEvent, true
+	case event.RawAnalog != nil:
+		return rawAnalogEvent, true
+	}
+	return 0, false
+}
+
+// eventValue returns the value of absolute, speed and raw events for the deadband
+func eventValue(event *rwp.HWCEvent) int64 {
+	switch {
+	case event.Absolute != nil:
+		return int64(event.Absolute.Value)
+	case event.Speed != nil:
+		return int64(event.Speed.Value)
+	case event.RawAnalog != nil:
+		return int64(event.RawAnalog.Value)
+	}
+	return 0
+}
@@ -0,0 +1,156 @@
+package rwptransport
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+)
+
+const testFilter = `
+drop raw 1-5
+deadband absolute 2 10
+ratelimit absolute 3 50ms
+coalesce absolute,pulsed 4 20ms
+`
+
+func absolute(hwc uint32, value uint32) *rwp.OutboundMessage {
+	return &rwp.OutboundMessage{Events: []*rwp.HWCEvent{{HWCID: hwc, Absolute: &rwp.AbsoluteEvent{Value: value}}}}
+}
+
+// filterValues returns the absolute and pulsed values of the events in msgs, in order
+func filterValues(msgs []*rwp.OutboundMessage) []int64 {
+	values := []int64{}
+	for _, msg := range msgs {
+		for _, event := range msg.Events {
+			if event.Pulsed != nil {
+				values = append(values, int64(event.Pulsed.Value))
+			} else {
+				values = append(values, eventValue(event))
+			}
+		}
+	}
+	return values
+}
+
+func equalValues(a []int64, b ...int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestEventFilter(t *testing.T) {
+	filter, err := ParseEventFilter(strings.NewReader(testFilter))
+	if err != nil {
+		t.Fatal(err)
+	}
+	run := newFilterRun(filter)
+	start := time.Now()
+	at := func(ms int) time.Time { return start.Add(time.Duration(ms) * time.Millisecond) }
+
+	// Dropped event types, other messages and HWCs pass:
+	msgs := run.filter([]*rwp.OutboundMessage{
+		{Events: []*rwp.HWCEvent{{HWCID: 1, RawAnalog: &rwp.RawAnalogEvent{Value: 7}}}},
+		{Events: []*rwp.HWCEvent{{HWCID: 1, Binary: &rwp.BinaryEvent{Pressed: true}}}},
+		{FlowMessage: rwp.OutboundMessage_PING},
+		absolute(9, 500),
+	}, at(0))
+	if len(msgs) != 3 || msgs[0].Events[0].Binary == nil || msgs[1].FlowMessage != rwp.OutboundMessage_PING || msgs[2].Events[0].HWCID != 9 {
+		t.Fatalf("unexpected messages %v", msgs)
+	}
+
+	// Deadband:
+	for i, value := range []uint32{100, 105, 95, 110, 101} {
+		msgs = append(msgs[:0], run.filter([]*rwp.OutboundMessage{absolute(2, value)}, at(i))...)
+		if forwarded := len(msgs) == 1; forwarded != (value == 100 || value == 110) {
+			t.Fatalf("deadband: value %d forwarded %v", value, forwarded)
+		}
+	}
+
+	// Rate limit: The first value passes, the last within the period follows at its end
+	if values := filterValues(run.filter([]*rwp.OutboundMessage{absolute(3, 1)}, at(0))); !equalValues(values, 1) {
+		t.Fatalf("rate limit: got %v", values)
+	}
+	for i := 2; i <= 4; i++ {
+		if msgs := run.filter([]*rwp.OutboundMessage{absolute(3, uint32(i))}, at(10*i)); len(msgs) != 0 {
+			t.Fatalf("rate limit: value %d not held", i)
+		}
+	}
+
+	// Coalesce: Everything is held for the window, pulses are summed
+	run.filter([]*rwp.OutboundMessage{absolute(4, 10), absolute(4, 20)}, at(40))
+	for i := 0; i < 3; i++ {
+		run.filter([]*rwp.OutboundMessage{{Events: []*rwp.HWCEvent{{HWCID: 4, Pulsed: &rwp.PulsedEvent{Value: 1}}}}}, at(41))
+	}
+
+	if next := run.next(); !next.Equal(at(50)) {
+		t.Fatalf("next deadline %v, expected 50ms", next.Sub(start))
+	}
+	if values := filterValues(run.flush(at(50))); !equalValues(values, 4) {
+		t.Fatalf("rate limit flush: got %v", values)
+	}
+	if values := filterValues(run.flush(at(61))); !equalValues(values, 3, 20) {
+		t.Fatalf("coalesce flush: got %v", values)
+	}
+	if msgs := run.flush(at(100)); msgs != nil {
+		t.Fatalf("nothing held, got %v", msgs)
+	}
+	if next := run.next(); !next.IsZero() {
+		t.Fatalf("no deadline expected, got %v", next.Sub(start))
+	}
+}
+
+func TestEventFilterPipe(t *testing.T) {
+	filter, err := ParseEventFilter(strings.NewReader("coalesce absolute 1 10ms"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	in := make(chan []*rwp.OutboundMessage, 10)
+	out := filter.Pipe(ctx, in)
+	for value := uint32(1); value <= 5; value++ {
+		in <- []*rwp.OutboundMessage{absolute(1, value)}
+	}
+	select {
+	case msgs := <-out:
+		if values := filterValues(msgs); !equalValues(values, 5) {
+			t.Fatalf("got %v", values)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("coalesced event not delivered")
+	}
+
+	var nilFilter *EventFilter
+	if nilFilter.Pipe(ctx, in) != (<-chan []*rwp.OutboundMessage)(in) {
+		t.Fatal("a nil filter should pass the channel through")
+	}
+}
+
+func TestEventFilterErrors(t *testing.T) {
+	for _, rules := range []string{
+		"ratelimit binary 1 10ms",
+		"deadband pulsed 1 5",
+		"coalesce absolute 1 10ms\nratelimit absolute 1 10ms",
+		"drop fader 1",
+		"deadband absolute 1",
+		"ratelimit absolute 1 fast",
+		"throttle absolute 1 10ms",
+	} {
+		if _, err := ParseEventFilter(strings.NewReader(rules)); err == nil {
+			t.Errorf("%q: expected an error", rules)
+		}
+	}
+	if _, err := ParseEventFilter(strings.NewReader("coalesce all 1-3 10ms # binary is skipped")); err != nil {
+		t.Errorf("coalesce all: %v", err)
+	}
+}
@@ -0,0 +1,86 @@
+/*
+Package rwptransport moves Raw Panel messages over TCP connections.
+
+It holds the framing (4-byte little endian length prefixed protobuf messages for binary mode, newline terminated lines for ASCII mode),
+codecs that translate between the wire and the rwp.InboundMessage/rwp.OutboundMessage structs, and the connect/reconnect loops
+used by the tools in this repository.
+
+Panel centric view:
+Inbound messages - from external system to SKAARHOJ panel
+Outbound messages - from panel to external system
+
+Distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+without even the implied warranty of MERCHANTABILITY or FITNESS FOR A
+PARTICULAR PURPOSE. MIT License
+*/
+package rwptransport
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// MaxPayloadLength is the limit for the payload of a binary frame. A header announcing more than this is taken as a sign that we are reading the wrong bytes as a header (or talking to an ASCII peer).
+const MaxPayloadLength = 500000
+
+// PayloadTimeout is how long we wait for a payload once its header has arrived. This helps a run-away scenario where not all data arrives or we read the wrong (and too big) header
+const PayloadTimeout = 2 * time.Second
+
+// ProbeTimeout is how long PanelCodec.DetectEncoding waits for a panel to answer the binary ping before concluding it's an ASCII panel
+const ProbeTimeout = 2 * time.Second
+
+// ErrPayloadTooLarge is returned by ReadFrame when a header announces a payload of MaxPayloadLength or more
+var ErrPayloadTooLarge = errors.New("payload exceeds limit")
+
+// readDeadliner is implemented by net.Conn
+type readDeadliner interface {
+	SetReadDeadline(t time.Time) error
+}
+
+// EncodeFrame prefixes a marshalled protobuf message with the 4-byte little endian length header
+func EncodeFrame(pbdata []byte) []byte {
+	frame := make([]byte, 4, 4+len(pbdata))
+	binary.LittleEndian.PutUint32(frame, uint32(len(pbdata)))
+	return append(frame, pbdata...)
+}
+
+// ReadFrame reads a single length prefixed frame from r and returns the payload.
+// If r supports read deadlines (like net.Conn does), waiting for a header is unlimited while the payload must follow within PayloadTimeout.
+// A connection closed in the middle of a frame is reported as io.ErrUnexpectedEOF, a connection closed between frames as io.EOF.
+func ReadFrame(r io.Reader) ([]byte, error) {
+	deadliner, _ := r.(readDeadliner)
+	return readFrame(r, deadliner)
+}
+
+// readFrame is ReadFrame with the deadlines applied to a different object than the one read from (when reading through a bufio.Reader)
+func readFrame(r io.Reader, deadliner readDeadliner) ([]byte, error) {
+	hasDeadline := deadliner != nil
+	if hasDeadline {
+		deadliner.SetReadDeadline(time.Time{}) // Reset deadline, waiting for header
+	}
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	payloadLength := binary.LittleEndian.Uint32(header)
+	if payloadLength >= MaxPayloadLength {
+		return nil, fmt.Errorf("%w: %d bytes announced", ErrPayloadTooLarge, payloadLength)
+	}
+
+	if hasDeadline {
+		deadliner.SetReadDeadline(time.Now().Add(PayloadTimeout))
+	}
+	payload := make([]byte, payloadLength)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF // We already have the header, so this is in the middle of a frame
+		}
+		return nil, err
+	}
+	return payload, nil
+}
@@ -0,0 +1,116 @@
+package rwptransport
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// chunkReader returns at most n bytes per Read, like a TCP stream delivering a frame in pieces
+type chunkReader struct {
+	data []byte
+	n    int
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	if len(c.data) == 0 {
+		return 0, io.EOF
+	}
+	n := c.n
+	if n > len(p) {
+		n = len(p)
+	}
+	if n > len(c.data) {
+		n = len(c.data)
+	}
+	copy(p, c.data[:n])
+	c.data = c.data[n:]
+	return n, nil
+}
+
+func TestEncodeFrame(t *testing.T) {
+	frame := EncodeFrame([]byte{1, 2, 3})
+	expected := []byte{3, 0, 0, 0, 1, 2, 3}
+	if !bytes.Equal(frame, expected) {
+		t.Fatalf("got %v, expected %v", frame, expected)
+	}
+}
+
+func TestReadFramePartial(t *testing.T) {
+	stream := append(EncodeFrame([]byte("first")), EncodeFrame([]byte("second frame"))...)
+	stream = append(stream, EncodeFrame(nil)...)
+
+	for _, chunkSize := range []int{1, 2, 3, 5, 1000} {
+		r := &chunkReader{data: append([]byte{}, stream...), n: chunkSize}
+		for _, expected := range []string{"first", "second frame", ""} {
+			payload, err := ReadFrame(r)
+			if err != nil {
+				t.Fatalf("chunk size %d: %v", chunkSize, err)
+			}
+			if string(payload) != expected {
+				t.Fatalf("chunk size %d: got %q, expected %q", chunkSize, payload, expected)
+			}
+		}
+		if _, err := ReadFrame(r); err != io.EOF {
+			t.Fatalf("chunk size %d: expected io.EOF between frames, got %v", chunkSize, err)
+		}
+	}
+}
+
+func TestReadFrameOversizedHeader(t *testing.T) {
+	header := make([]byte, 4)
+	binary.LittleEndian.PutUint32(header, MaxPayloadLength)
+	_, err := ReadFrame(bytes.NewReader(append(header, 0, 0, 0)))
+	if !errors.Is(err, ErrPayloadTooLarge) {
+		t.Fatalf("expected ErrPayloadTooLarge, got %v", err)
+	}
+
+	// An ASCII line read as a header is what typically produces a huge length:
+	_, err = ReadFrame(bytes.NewReader([]byte("HWC#1=Down\n")))
+	if !errors.Is(err, ErrPayloadTooLarge) {
+		t.Fatalf("expected ErrPayloadTooLarge for ASCII input, got %v", err)
+	}
+}
+
+func TestReadFrameMidFrameDisconnect(t *testing.T) {
+	frame := EncodeFrame([]byte("payload"))
+
+	// Disconnect inside the header:
+	if _, err := ReadFrame(bytes.NewReader(frame[:2])); err != io.ErrUnexpectedEOF {
+		t.Fatalf("header cut: expected io.ErrUnexpectedEOF, got %v", err)
+	}
+
+	// Disconnect right after the header:
+	if _, err := ReadFrame(bytes.NewReader(frame[:4])); err != io.ErrUnexpectedEOF {
+		t.Fatalf("payload missing: expected io.ErrUnexpectedEOF, got %v", err)
+	}
+
+	// Disconnect inside the payload:
+	if _, err := ReadFrame(bytes.NewReader(frame[:7])); err != io.ErrUnexpectedEOF {
+		t.Fatalf("payload cut: expected io.ErrUnexpectedEOF, got %v", err)
+	}
+}
+
+func TestReadFramePayloadTimeout(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	go func() {
+		server.Write(EncodeFrame([]byte("payload"))[:6]) // Header and a little, then silence
+	}()
+
+	start := time.Now()
+	_, err := ReadFrame(client)
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("expected timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < PayloadTimeout/2 {
+		t.Fatalf("timed out too early: %v", elapsed)
+	}
+}
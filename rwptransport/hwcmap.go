@@ -0,0 +1,277 @@
+package rwptransport
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+
+	"google.golang.org/protobuf/proto"
+)
+
+/*
+HWC map files translate between the HWC numbers of a panel and those a system expects, one rule per line:
+
+	# Panel HWC : System HWC, like "map=" in the ASCII protocol. Ranges must have the same length
+	12:1
+	13-16:2-5
+	# Panel HWCs never shown to the system, and never set by it
+	drop 40-45,50
+	# Drop everything not mapped above
+	drop unmapped
+
+HWCs which are neither mapped nor dropped pass through with their own number.
+*/
+
+// HWCMap translates HWC numbers in messages between a panel and a system. A nil *HWCMap passes everything through unchanged.
+type HWCMap struct {
+	toSystem     map[uint32]uint32
+	toPanel      map[uint32]uint32
+	dropped      map[uint32]bool // Panel HWCs
+	dropUnmapped bool
+}
+
+// LoadHWCMap reads an HWC map file
+func LoadHWCMap(filename string) (*HWCMap, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	hwcMap, err := ParseHWCMap(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return hwcMap, nil
+}
+
+// ParseHWCMap reads HWC map rules from r
+func ParseHWCMap(r io.Reader) (*HWCMap, error) {
+	hwcMap := &HWCMap{
+		toSystem: make(map[uint32]uint32),
+		toPanel:  make(map[uint32]uint32),
+		dropped:  make(map[uint32]bool),
+	}
+
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if comment := strings.Index(line, "#"); comment >= 0 {
+			line = strings.TrimSpace(line[:comment])
+		}
+		if line == "" {
+			continue
+		}
+
+		if list, isDrop := strings.CutPrefix(line, "drop "); isDrop {
+			list = strings.TrimSpace(list)
+			if list == "unmapped" {
+				hwcMap.dropUnmapped = true
+				continue
+			}
+			hwcs, err := ParseHWCList(list)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+			}
+			for _, hwc := range hwcs {
+				hwcMap.dropped[hwc] = true
+			}
+			continue
+		}
+
+		panelPart, systemPart, found := strings.Cut(line, ":")
+		if !found {
+			return nil, fmt.Errorf("line %d: expected panel HWC:system HWC or drop, got %q", lineNumber, line)
+		}
+		panelHWCs, err := ParseHWCList(panelPart)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+		systemHWCs, err := ParseHWCList(systemPart)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+		if len(panelHWCs) != len(systemHWCs) {
+			return nil, fmt.Errorf("line %d: %d panel HWCs mapped to %d system HWCs", lineNumber, len(panelHWCs), len(systemHWCs))
+		}
+		for i, panelHWC := range panelHWCs {
+			systemHWC := systemHWCs[i]
+			if _, exists := hwcMap.toSystem[panelHWC]; exists {
+				return nil, fmt.Errorf("line %d: panel HWC %d is mapped twice", lineNumber, panelHWC)
+			}
+			if _, exists := hwcMap.toPanel[systemHWC]; exists {
+				return nil, fmt.Errorf("line %d: system HWC %d is mapped twice", lineNumber, systemHWC)
+			}
+			hwcMap.toSystem[panelHWC] = systemHWC
+			hwcMap.toPanel[systemHWC] = panelHWC
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return hwcMap, nil
+}
+
+// PanelHWC returns the panel HWC for a system HWC. False if the system can't address it.
+func (m *HWCMap) PanelHWC(systemHWC uint32) (uint32, bool) {
+	if m == nil {
+		return systemHWC, true
+	}
+	panelHWC, mapped := m.toPanel[systemHWC]
+	if !mapped {
+		if m.dropUnmapped {
+			return 0, false
+		}
+		if _, remapped := m.toSystem[systemHWC]; remapped { // The panel HWC with this number is known to the system by another number
+			return 0, false
+		}
+		panelHWC = systemHWC
+	}
+	return panelHWC, !m.dropped[panelHWC]
+}
+
+// SystemHWC returns the system HWC for a panel HWC. False if the system doesn't see it.
+func (m *HWCMap) SystemHWC(panelHWC uint32) (uint32, bool) {
+	if m == nil {
+		return panelHWC, true
+	}
+	if m.dropped[panelHWC] {
+		return 0, false
+	}
+	systemHWC, mapped := m.toSystem[panelHWC]
+	if !mapped {
+		if m.dropUnmapped {
+			return 0, false
+		}
+		if _, taken := m.toPanel[panelHWC]; taken { // The system number is used by another panel HWC
+			return 0, false
+		}
+		systemHWC = panelHWC
+	}
+	return systemHWC, true
+}
+
+// PanelToSystem translates Events and HWCavailability in messages from the panel. Messages left empty are removed.
+// The messages passed in are not modified.
+func (m *HWCMap) PanelToSystem(msgs []*rwp.OutboundMessage) []*rwp.OutboundMessage {
+	if m == nil {
+		return msgs
+	}
+
+	translated := make([]*rwp.OutboundMessage, 0, len(msgs))
+	for _, msg := range msgs {
+		if len(msg.Events) == 0 && len(msg.HWCavailability) == 0 {
+			translated = append(translated, msg)
+			continue
+		}
+
+		msg = proto.Clone(msg).(*rwp.OutboundMessage)
+		events := msg.Events[:0]
+		for _, event := range msg.Events {
+			if hwc, ok := m.SystemHWC(event.HWCID); ok {
+				event.HWCID = hwc
+				events = append(events, event)
+			}
+		}
+		msg.Events = events
+
+		if msg.HWCavailability != nil {
+			availability := make(map[uint32]uint32, len(msg.HWCavailability))
+			for panelHWC, value := range msg.HWCavailability {
+				hwc, ok := m.SystemHWC(panelHWC)
+				if !ok {
+					continue
+				}
+				if value != 0 {
+					if value, ok = m.SystemHWC(value); !ok {
+						continue
+					}
+				}
+				availability[hwc] = value
+			}
+			msg.HWCavailability = availability
+		}
+
+		if proto.Size(msg) > 0 {
+			translated = append(translated, msg)
+		}
+	}
+	return translated
+}
+
+// SystemToPanel translates the HWCIDs of States in messages from the system. States and messages left empty are removed.
+// The messages passed in are not modified.
+func (m *HWCMap) SystemToPanel(msgs []*rwp.InboundMessage) []*rwp.InboundMessage {
+	if m == nil {
+		return msgs
+	}
+
+	translated := make([]*rwp.InboundMessage, 0, len(msgs))
+	for _, msg := range msgs {
+		if len(msg.States) == 0 {
+			translated = append(translated, msg)
+			continue
+		}
+
+		msg = proto.Clone(msg).(*rwp.InboundMessage)
+		states := msg.States[:0]
+		for _, state := range msg.States {
+			hwcs := state.HWCIDs[:0]
+			for _, systemHWC := range state.HWCIDs {
+				if hwc, ok := m.PanelHWC(systemHWC); ok {
+					hwcs = append(hwcs, hwc)
+				}
+			}
+			state.HWCIDs = hwcs
+			if len(hwcs) > 0 {
+				states = append(states, state)
+			}
+		}
+		msg.States = states
+
+		if proto.Size(msg) > 0 {
+			translated = append(translated, msg)
+		}
+	}
+	return translated
+}
+
+// maxHWCList is the most HWCs a list may expand to. Panels have a few hundred HWCs at most, so longer lists are typos.
+const maxHWCList = 10000
+
+// ParseHWCList parses a comma separated list of HWCs and ranges like "1-20,45", in the given order
+func ParseHWCList(list string) ([]uint32, error) {
+	hwcs := []uint32{}
+	for _, part := range strings.Split(list, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		first, last, isRange := strings.Cut(part, "-")
+		from, err := strconv.ParseUint(strings.TrimSpace(first), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("bad HWC %q", part)
+		}
+		to := from
+		if isRange {
+			to, err = strconv.ParseUint(strings.TrimSpace(last), 10, 32)
+			if err != nil || to < from {
+				return nil, fmt.Errorf("bad HWC range %q", part)
+			}
+		}
+		if to-from >= uint64(maxHWCList-len(hwcs)) {
+			return nil, fmt.Errorf("more than %d HWCs in %q", maxHWCList, list)
+		}
+		for hwc := from; hwc <= to; hwc++ {
+			hwcs = append(hwcs, uint32(hwc))
+		}
+	}
+	return hwcs, nil
+}
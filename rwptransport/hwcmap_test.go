@@ -0,0 +1,110 @@
+package rwptransport
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+
+	"google.golang.org/protobuf/proto"
+)
+
+const testHWCMap = `
+# Panel : System
+12:1
+13-14:2-3 # A range
+drop 40-41
+`
+
+func TestHWCMapPanelToSystem(t *testing.T) {
+	hwcMap, err := ParseHWCMap(strings.NewReader(testHWCMap))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := &rwp.OutboundMessage{
+		Events: []*rwp.HWCEvent{
+			{HWCID: 12, Binary: &rwp.BinaryEvent{Pressed: true}},
+			{HWCID: 40, Binary: &rwp.BinaryEvent{Pressed: true}},
+			{HWCID: 20, Binary: &rwp.BinaryEvent{Pressed: true}},
+			{HWCID: 1, Binary: &rwp.BinaryEvent{Pressed: true}}, // System HWC 1 is panel HWC 12
+		},
+		HWCavailability: map[uint32]uint32{12: 12, 14: 14, 40: 40, 20: 0},
+	}
+	original := proto.Clone(msg)
+
+	translated := hwcMap.PanelToSystem([]*rwp.OutboundMessage{msg})
+	if len(translated) != 1 {
+		t.Fatalf("expected one message, got %v", translated)
+	}
+	hwcs := []uint32{}
+	for _, event := range translated[0].Events {
+		hwcs = append(hwcs, event.HWCID)
+	}
+	if !reflect.DeepEqual(hwcs, []uint32{1, 20}) {
+		t.Errorf("unexpected event HWCs %v", hwcs)
+	}
+	if !reflect.DeepEqual(translated[0].HWCavailability, map[uint32]uint32{1: 1, 3: 3, 20: 0}) {
+		t.Errorf("unexpected availability %v", translated[0].HWCavailability)
+	}
+	if !proto.Equal(msg, original) {
+		t.Error("the original message was modified")
+	}
+
+	dropped := hwcMap.PanelToSystem([]*rwp.OutboundMessage{{Events: []*rwp.HWCEvent{{HWCID: 41}}}})
+	if len(dropped) != 0 {
+		t.Errorf("expected an empty message to be removed, got %v", dropped)
+	}
+}
+
+func TestHWCMapSystemToPanel(t *testing.T) {
+	hwcMap, err := ParseHWCMap(strings.NewReader(testHWCMap + "drop unmapped\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msgs := []*rwp.InboundMessage{
+		{States: []*rwp.HWCState{
+			{HWCIDs: []uint32{1, 3, 20}, HWCMode: &rwp.HWCMode{State: rwp.HWCMode_ON}},
+			{HWCIDs: []uint32{20}, HWCMode: &rwp.HWCMode{State: rwp.HWCMode_ON}},
+		}},
+		{States: []*rwp.HWCState{{HWCIDs: []uint32{21}}}},
+		{Command: &rwp.Command{ActivatePanel: true}},
+	}
+
+	translated := hwcMap.SystemToPanel(msgs)
+	if len(translated) != 2 || len(translated[0].States) != 1 || translated[1].Command == nil {
+		t.Fatalf("unexpected messages %v", translated)
+	}
+	if hwcs := translated[0].States[0].HWCIDs; !reflect.DeepEqual(hwcs, []uint32{12, 14}) {
+		t.Errorf("unexpected state HWCs %v", hwcs)
+	}
+	if hwcs := msgs[0].States[0].HWCIDs; !reflect.DeepEqual(hwcs, []uint32{1, 3, 20}) {
+		t.Errorf("the original message was modified: %v", hwcs)
+	}
+}
+
+func TestHWCMapErrors(t *testing.T) {
+	for _, rules := range []string{
+		"12",
+		"12:1\n13:1",
+		"12:1\n12:2",
+		"1-3:1-2",
+		"drop 5-3",
+		"a:1",
+		"drop 1-4294967295",
+		"1-4294967295:1-4294967295",
+		"drop 1-5000,6000-11000",
+	} {
+		if _, err := ParseHWCMap(strings.NewReader(rules)); err == nil {
+			t.Errorf("expected an error for %q", rules)
+		}
+	}
+
+	var hwcMap *HWCMap
+	msgs := []*rwp.InboundMessage{{States: []*rwp.HWCState{{HWCIDs: []uint32{1}}}}}
+	if translated := hwcMap.SystemToPanel(msgs); !reflect.DeepEqual(translated, msgs) {
+		t.Error("a nil map must pass messages through")
+	}
+}
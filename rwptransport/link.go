@@ -0,0 +1,319 @@
+package rwptransport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	helpers "github.com/SKAARHOJ/rawpanel-lib"
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+)
+
+// EventType tells what happened to a connection
+type EventType int
+
+const (
+	Connected EventType = iota + 1
+	Disconnected
+	DialFailed
+	ReadError // A frame could not be decoded, but the connection is kept
+)
+
+func (t EventType) String() string {
+	switch t {
+	case Connected:
+		return "connected"
+	case Disconnected:
+		return "disconnected"
+	case DialFailed:
+		return "dial failed"
+	case ReadError:
+		return "read error"
+	}
+	return "unknown"
+}
+
+// Event is passed to Config.OnEvent whenever the state of a connection changes
+type Event struct {
+	Type     EventType
+	Addr     string   // The address dialed, or the remote address of an accepted connection
+	Encoding Encoding // The encoding used on the connection
+	Err      error    // Reason for the event, if any. Nil on a disconnect caused by context cancellation.
+}
+
+// Config holds the settings for DialPanel, DialSystem and ServeSystem. The zero value is usable.
+type Config struct {
+	Encoding        Encoding
+	RetryPeriod     time.Duration                        // Wait before retrying a failed dial. Default is 3 seconds
+	ReconnectPeriod time.Duration                        // Wait before dialing again after a disconnect. Default is 1 second
+	PingPeriod      time.Duration                        // If non-zero, a ping is sent to the peer with this period while connected
+	Timeout         time.Duration                        // If non-zero, the connection is dropped with ErrTimeout when nothing is received for this long. Use with PingPeriod
+	Status          *Status                              // If set, it's updated with the liveness of the connection
+	OnEvent         func(Event)                          // Called on connect, disconnect and errors. On Connected it's safe to queue greeting messages on the outgoing channel
+	OnWrite         func(data []byte, encoding Encoding) // Called with every protobuf payload or ASCII line written, for tracing
+	Recorder        *Recorder                            // If set, messages written and read are logged here (pings sent by PingPeriod are not)
+
+	// Ignore Encoding and detect it on every connection. Panels are probed with a binary ping (see PanelCodec.DetectEncoding),
+	// systems are detected from the first bytes they send (see SystemCodec.DetectEncoding). The result is in the Connected event.
+	DetectEncoding bool
+
+	ReassembleGraphics bool // System connections only: Assemble graphics sent over multiple ASCII lines into one message
+}
+
+func (config *Config) retryPeriod() time.Duration {
+	if config.RetryPeriod > 0 {
+		return config.RetryPeriod
+	}
+	return 3 * time.Second
+}
+
+func (config *Config) reconnectPeriod() time.Duration {
+	if config.ReconnectPeriod > 0 {
+		return config.ReconnectPeriod
+	}
+	return time.Second
+}
+
+func (config *Config) event(eventType EventType, addr string, err error) {
+	if config.OnEvent != nil {
+		config.OnEvent(Event{Type: eventType, Addr: addr, Encoding: config.Encoding, Err: err})
+	}
+}
+
+// DialPanel keeps a connection to a panel in server mode (eg. on 192.168.10.99:9923) until ctx is done.
+// Messages on toPanel are written to the panel and messages from the panel are delivered on fromPanel, which must be read continuously.
+// While there is no connection, messages on toPanel are discarded.
+func DialPanel(ctx context.Context, addr string, toPanel <-chan []*rwp.InboundMessage, fromPanel chan<- []*rwp.OutboundMessage, config Config) {
+	dialLoop(ctx, addr, toPanel, &config, func(conn net.Conn) error {
+		return servePanel(ctx, conn, addr, toPanel, fromPanel, &config)
+	})
+}
+
+// DialSystem keeps a connection to a system in server mode (eg. on 192.168.10.250:9923) until ctx is done.
+// Messages on toSystem are written to the system and messages from the system are delivered on fromSystem, which must be read continuously.
+// While there is no connection, messages on toSystem are discarded.
+func DialSystem(ctx context.Context, addr string, toSystem <-chan []*rwp.OutboundMessage, fromSystem chan<- []*rwp.InboundMessage, config Config) {
+	dialLoop(ctx, addr, toSystem, &config, func(conn net.Conn) error {
+		return ServeSystem(ctx, conn, toSystem, fromSystem, config)
+	})
+}
+
+// ServeSystem runs an established connection to a system (typically accepted from a listener) until it fails or ctx is done.
+// The connection is closed when ServeSystem returns.
+// With config.DetectEncoding, nothing is read from toSystem before the system has sent its first bytes.
+func ServeSystem(ctx context.Context, conn net.Conn, toSystem <-chan []*rwp.OutboundMessage, fromSystem chan<- []*rwp.InboundMessage, config Config) error {
+	codec := NewSystemCodec(conn, config.Encoding)
+	if config.DetectEncoding {
+		if err := detectEncoding(ctx, conn, conn.RemoteAddr().String(), &config, codec.DetectEncoding); err != nil {
+			return err
+		}
+	}
+	codec.OnWrite = config.OnWrite
+	if config.ReassembleGraphics {
+		codec.ASCIIreader = &helpers.ASCIIreader{}
+	}
+	ping := func() error {
+		return codec.WriteMessages([]*rwp.OutboundMessage{{FlowMessage: rwp.OutboundMessage_PING}})
+	}
+	addr := conn.RemoteAddr().String()
+	write := func(msgs []*rwp.OutboundMessage) error {
+		err := codec.WriteMessages(msgs)
+		if err == nil {
+			config.Recorder.Outbound(addr, msgs)
+		}
+		return err
+	}
+	read := func() ([]*rwp.InboundMessage, error) {
+		msgs, err := codec.ReadMessages()
+		config.Recorder.Inbound(addr, msgs)
+		return msgs, err
+	}
+	isAck := func(msg *rwp.InboundMessage) bool { return msg.FlowMessage == rwp.InboundMessage_ACK }
+	return serve(ctx, conn, addr, &config, toSystem, fromSystem, write, read, ping, isAck)
+}
+
+func servePanel(ctx context.Context, conn net.Conn, addr string, toPanel <-chan []*rwp.InboundMessage, fromPanel chan<- []*rwp.OutboundMessage, config *Config) error {
+	codec := NewPanelCodec(conn, config.Encoding)
+	if config.DetectEncoding {
+		if err := detectEncoding(ctx, conn, addr, config, codec.DetectEncoding); err != nil {
+			return err
+		}
+	}
+	codec.OnWrite = config.OnWrite
+	ping := func() error {
+		return codec.WriteMessages([]*rwp.InboundMessage{{FlowMessage: rwp.InboundMessage_PING}})
+	}
+	write := func(msgs []*rwp.InboundMessage) error {
+		err := codec.WriteMessages(msgs)
+		if err == nil {
+			config.Recorder.Inbound(addr, msgs)
+		}
+		return err
+	}
+	read := func() ([]*rwp.OutboundMessage, error) {
+		msgs, err := codec.ReadMessages()
+		config.Recorder.Outbound(addr, msgs)
+		return msgs, err
+	}
+	isAck := func(msg *rwp.OutboundMessage) bool { return msg.FlowMessage == rwp.OutboundMessage_ACK }
+	return serve(ctx, conn, addr, config, toPanel, fromPanel, write, read, ping, isAck)
+}
+
+// detectEncoding runs detect on a fresh connection and stores the result in config. The connection is closed if detection fails or ctx is done.
+func detectEncoding(ctx context.Context, conn net.Conn, addr string, config *Config, detect func() (Encoding, error)) error {
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	encoding, err := detect()
+	stop()
+	if err != nil {
+		conn.Close()
+		if ctx.Err() != nil {
+			err = nil
+		}
+		config.event(Disconnected, addr, err)
+		return err
+	}
+	config.Encoding = encoding
+	return nil
+}
+
+// dialLoop dials addr until ctx is done and hands each connection to session. Messages on outgoing are drained while unconnected.
+func dialLoop[T any](ctx context.Context, addr string, outgoing <-chan T, config *Config, session func(conn net.Conn) error) {
+	var dialer net.Dialer
+	for {
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		wait := config.reconnectPeriod()
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			config.event(DialFailed, addr, err)
+			wait = config.retryPeriod()
+		} else {
+			session(conn)
+			if ctx.Err() != nil {
+				return
+			}
+		}
+
+		timer := time.NewTimer(wait)
+	waiting:
+		for {
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-outgoing:
+				// Ignore outgoing messages while unconnected, it's important to read the channel to not pile up stuff there.
+			case <-timer.C:
+				break waiting
+			}
+		}
+	}
+}
+
+// serve runs one connection: A goroutine writes messages from outgoing (and pings) and watches the timeout, while this goroutine reads from the connection into incoming.
+func serve[Out any, In any](ctx context.Context, conn net.Conn, addr string, config *Config, outgoing <-chan []Out, incoming chan<- []In, write func([]Out) error, read func() ([]In, error), ping func() error, isAck func(In) bool) error {
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	var timedOut atomic.Bool
+
+	status := config.Status
+	if status == nil {
+		status = &Status{}
+	}
+	status.connected(addr)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		var pingTicker <-chan time.Time
+		if config.PingPeriod > 0 {
+			ticker := time.NewTicker(config.PingPeriod)
+			defer ticker.Stop()
+			pingTicker = ticker.C
+		}
+
+		var timeoutTicker <-chan time.Time
+		if config.Timeout > 0 {
+			ticker := time.NewTicker(config.Timeout / 10)
+			defer ticker.Stop()
+			timeoutTicker = ticker.C
+		}
+
+		for {
+			var err error
+			select {
+			case <-stop:
+				return
+			case <-ctx.Done():
+				conn.Close() // Makes the reader below fail and exit
+				return
+			case <-pingTicker:
+				if err = ping(); err == nil {
+					status.pinged()
+				}
+			case <-timeoutTicker:
+				if status.sinceReceived() > config.Timeout {
+					timedOut.Store(true)
+					conn.Close() // Makes the reader below fail and exit
+					return
+				}
+			case msgs := <-outgoing:
+				err = write(msgs)
+			}
+			if err != nil {
+				conn.Close() // Makes the reader below fail and exit
+				return
+			}
+		}
+	}()
+
+	config.event(Connected, addr, nil)
+
+	var err error
+	for {
+		var msgs []In
+		msgs, err = read()
+		var decodeErr *DecodeError
+		if err == nil || errors.As(err, &decodeErr) {
+			ack := false
+			for _, msg := range msgs {
+				ack = ack || isAck(msg)
+			}
+			status.received(ack)
+		}
+		if err != nil {
+			if decodeErr != nil {
+				config.event(ReadError, addr, err)
+				continue
+			}
+			break
+		}
+		if len(msgs) == 0 {
+			continue
+		}
+		select {
+		case incoming <- msgs:
+		case <-ctx.Done():
+		}
+	}
+
+	close(stop)
+	conn.Close()
+	wg.Wait()
+	status.disconnected()
+
+	if timedOut.Load() {
+		err = fmt.Errorf("%w: nothing received for %v", ErrTimeout, config.Timeout)
+	}
+	if ctx.Err() != nil {
+		err = nil
+	}
+	config.event(Disconnected, addr, err)
+	return err
+}
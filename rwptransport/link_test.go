@@ -0,0 +1,205 @@
+package rwptransport
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+
+	"google.golang.org/protobuf/proto"
+)
+
+func TestDialPanelReconnect(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events := make(chan Event, 10)
+	toPanel := make(chan []*rwp.InboundMessage, 10)
+	fromPanel := make(chan []*rwp.OutboundMessage, 10)
+
+	done := make(chan struct{})
+	go func() {
+		DialPanel(ctx, l.Addr().String(), toPanel, fromPanel, Config{
+			ReconnectPeriod: 10 * time.Millisecond,
+			OnEvent:         func(e Event) { events <- e },
+		})
+		close(done)
+	}()
+
+	for round := 0; round < 2; round++ {
+		c, err := l.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e := <-events; e.Type != Connected {
+			t.Fatalf("round %d: expected connected event, got %v", round, e.Type)
+		}
+
+		// Panel -> system:
+		c.Write([]byte("HWC#3=Up\n"))
+		msgs := <-fromPanel
+		if msgs[0].Events[0].HWCID != 3 {
+			t.Fatalf("round %d: unexpected messages %v", round, msgs)
+		}
+
+		// System -> panel:
+		toPanel <- []*rwp.InboundMessage{{FlowMessage: rwp.InboundMessage_PING}}
+		line, err := bufio.NewReader(c).ReadString('\n')
+		if err != nil || line != "ping\n" {
+			t.Fatalf("round %d: got %q, %v", round, line, err)
+		}
+
+		c.Close()
+		if e := <-events; e.Type != Disconnected || e.Err == nil {
+			t.Fatalf("round %d: expected disconnected event with error, got %v %v", round, e.Type, e.Err)
+		}
+	}
+
+	// Cancellation while connected ends DialPanel with a clean disconnect:
+	c, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	<-events
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("DialPanel did not return after cancel")
+	}
+	if e := <-events; e.Type != Disconnected || e.Err != nil {
+		t.Fatalf("expected clean disconnect, got %v %v", e.Type, e.Err)
+	}
+}
+
+func TestDialSystemDialFailed(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close() // Nobody listening here anymore
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := make(chan Event, 10)
+	toSystem := make(chan []*rwp.OutboundMessage, 1)
+	go DialSystem(ctx, addr, toSystem, make(chan []*rwp.InboundMessage), Config{
+		RetryPeriod: 10 * time.Millisecond,
+		OnEvent:     func(e Event) { events <- e },
+	})
+
+	if e := <-events; e.Type != DialFailed || e.Err == nil {
+		t.Fatalf("expected dial failure, got %v %v", e.Type, e.Err)
+	}
+
+	// Messages are drained while unconnected, so senders do not block:
+	for i := 0; i < 5; i++ {
+		select {
+		case toSystem <- []*rwp.OutboundMessage{{}}:
+		case <-time.After(time.Second):
+			t.Fatal("toSystem was not drained while unconnected")
+		}
+	}
+}
+
+func TestServeSystemBinaryPing(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go ServeSystem(ctx, server, make(chan []*rwp.OutboundMessage), make(chan []*rwp.InboundMessage), Config{
+		Encoding:   Binary,
+		PingPeriod: 10 * time.Millisecond,
+	})
+
+	payload, err := ReadFrame(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := &rwp.OutboundMessage{}
+	if err := proto.Unmarshal(payload, msg); err != nil || msg.FlowMessage != rwp.OutboundMessage_PING {
+		t.Fatalf("expected binary ping, got %v, %v", payload, err)
+	}
+}
+
+func TestServeSystemTimeout(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go io.Copy(io.Discard, client) // A peer that reads but never answers
+
+	status := &Status{}
+	done := make(chan error)
+	go func() {
+		done <- ServeSystem(context.Background(), server, make(chan []*rwp.OutboundMessage), make(chan []*rwp.InboundMessage), Config{
+			PingPeriod: 10 * time.Millisecond,
+			Timeout:    100 * time.Millisecond,
+			Status:     status,
+		})
+	}()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrTimeout) {
+			t.Fatalf("expected timeout, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("ServeSystem did not time out")
+	}
+	if snapshot := status.Snapshot(); snapshot.Connected || !snapshot.LastAck.IsZero() {
+		t.Fatalf("unexpected status %+v", snapshot)
+	}
+}
+
+func TestServePanelStatus(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	status := &Status{}
+	go DialPanel(ctx, l.Addr().String(), make(chan []*rwp.InboundMessage), make(chan []*rwp.OutboundMessage, 10), Config{
+		PingPeriod: 10 * time.Millisecond,
+		Timeout:    time.Second,
+		Status:     status,
+	})
+
+	c, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// Answer pings like an ASCII panel:
+	reader := bufio.NewReader(c)
+	for i := 0; i < 3; i++ {
+		line, err := reader.ReadString('\n')
+		if err != nil || line != "ping\n" {
+			t.Fatalf("got %q, %v", line, err)
+		}
+		c.Write([]byte("ack\n"))
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for status.Snapshot().LastAck.IsZero() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	snapshot := status.Snapshot()
+	if !snapshot.Connected || snapshot.LastAck.IsZero() || snapshot.RTT <= 0 || snapshot.Addr != l.Addr().String() {
+		t.Fatalf("unexpected status %+v", snapshot)
+	}
+}
@@ -0,0 +1,93 @@
+package rwptransport
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+)
+
+// ErrBusy is the reason for closing a connection accepted while another one is served by ListenPanel or ListenSystem
+var ErrBusy = errors.New("another connection is already served")
+
+// ListenPanel serves panels in client mode connecting to l, one at a time, until ctx is done or l fails. l is closed when ListenPanel returns.
+// Messages on toPanel are written to the panel and messages from the panel are delivered on fromPanel, which must be read continuously.
+// While no panel is connected, messages on toPanel are discarded. A panel connecting while another is served is closed with ErrBusy.
+func ListenPanel(ctx context.Context, l net.Listener, toPanel <-chan []*rwp.InboundMessage, fromPanel chan<- []*rwp.OutboundMessage, config Config) error {
+	return listenLoop(ctx, l, toPanel, &config, func(conn net.Conn) error {
+		session := config // Each connection detects its own encoding
+		return servePanel(ctx, conn, conn.RemoteAddr().String(), toPanel, fromPanel, &session)
+	})
+}
+
+// ListenSystem serves systems in client mode connecting to l, one at a time, until ctx is done or l fails. l is closed when ListenSystem returns.
+// Messages on toSystem are written to the system and messages from the system are delivered on fromSystem, which must be read continuously.
+// While no system is connected, messages on toSystem are discarded. A system connecting while another is served is closed with ErrBusy.
+func ListenSystem(ctx context.Context, l net.Listener, toSystem <-chan []*rwp.OutboundMessage, fromSystem chan<- []*rwp.InboundMessage, config Config) error {
+	return listenLoop(ctx, l, toSystem, &config, func(conn net.Conn) error {
+		return ServeSystem(ctx, conn, toSystem, fromSystem, config)
+	})
+}
+
+// listenLoop accepts connections on l until ctx is done and hands them to session one at a time. Messages on outgoing are drained while no session runs.
+func listenLoop[T any](ctx context.Context, l net.Listener, outgoing <-chan T, config *Config, session func(conn net.Conn) error) error {
+	stop := context.AfterFunc(ctx, func() { l.Close() })
+	defer stop()
+	defer l.Close()
+
+	conns := make(chan net.Conn)
+	acceptErr := make(chan error, 1)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				acceptErr <- err
+				close(conns)
+				return
+			}
+			conns <- conn
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-outgoing:
+			// Ignore outgoing messages while unconnected, it's important to read the channel to not pile up stuff there.
+		case conn, ok := <-conns:
+			if !ok {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return <-acceptErr
+			}
+
+			done := make(chan struct{})
+			go func() {
+				session(conn)
+				close(done)
+			}()
+			for serving := true; serving; {
+				select {
+				case <-done:
+					serving = false
+				case extra, ok := <-conns:
+					if !ok {
+						conns = nil // The listener failed, the current session runs to its end
+						continue
+					}
+					config.event(Disconnected, extra.RemoteAddr().String(), ErrBusy)
+					extra.Close()
+				}
+			}
+			if conns == nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return <-acceptErr
+			}
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package rwptransport
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+)
+
+func TestListenPanel(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events := make(chan Event, 10)
+	toPanel := make(chan []*rwp.InboundMessage, 10)
+	fromPanel := make(chan []*rwp.OutboundMessage, 10)
+
+	done := make(chan error)
+	go func() {
+		done <- ListenPanel(ctx, l, toPanel, fromPanel, Config{OnEvent: func(e Event) { events <- e }})
+	}()
+
+	// Discarded while no panel is connected:
+	toPanel <- []*rwp.InboundMessage{{FlowMessage: rwp.InboundMessage_ACK}}
+
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if e := <-events; e.Type != Connected {
+		t.Fatalf("expected connected event, got %v", e.Type)
+	}
+
+	// A second panel is turned away:
+	extra, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer extra.Close()
+	if e := <-events; e.Type != Disconnected || !errors.Is(e.Err, ErrBusy) {
+		t.Fatalf("expected busy disconnect, got %v %v", e.Type, e.Err)
+	}
+
+	c.Write([]byte("list\n"))
+	if msgs := <-fromPanel; msgs[0].FlowMessage != rwp.OutboundMessage_HELLO {
+		t.Fatalf("unexpected messages %v", msgs)
+	}
+	toPanel <- []*rwp.InboundMessage{{Command: &rwp.Command{ActivatePanel: true}}}
+	line, err := bufio.NewReader(c).ReadString('\n')
+	if err != nil || line != "ActivePanel=1\n" {
+		t.Fatalf("got %q, %v", line, err)
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("expected clean return, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("ListenPanel did not return after cancel")
+	}
+}
@@ -0,0 +1,159 @@
+package rwptransport
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+	"github.com/SKAARHOJ/rawpanel-lib/topology"
+)
+
+// OfflineScreen takes over a panel while the system behind a connector is away: LEDs are dimmed and displays show a message.
+// The panel is restored from a StateCache of what the system has sent when the system is back.
+//
+// The connector passes all messages from the panel through FromPanel, keeps the cache updated with the messages from the system,
+// reports the system connection with SystemLost and SystemBack, and sends the messages these return to the panel.
+// A nil *OfflineScreen does nothing, so callers don't need to check if the feature is enabled.
+type OfflineScreen struct {
+	Title    string             // Display title, eg. "System offline"
+	Lines    [2]string          // Display text lines, eg. "Reconnecting to" and the address
+	LEDs     rwp.HWCMode_StateE // Mode of all LEDs while offline, eg. HWCMode_DIMMED
+	KeepLEDs bool               // Leave the LEDs as they are instead
+
+	cache    *StateCache
+	topology *topology.Topology
+	offline  bool
+	mu       sync.Mutex
+}
+
+// NewOfflineScreen returns an OfflineScreen which considers the system offline until SystemBack is called
+func NewOfflineScreen(cache *StateCache, title string, line1 string, line2 string) *OfflineScreen {
+	return &OfflineScreen{
+		Title:   title,
+		Lines:   [2]string{line1, line2},
+		LEDs:    rwp.HWCMode_DIMMED,
+		cache:   cache,
+		offline: true,
+	}
+}
+
+// SetLEDs sets the LEDs while offline by name: "dimmed", "off" or "keep"
+func (screen *OfflineScreen) SetLEDs(name string) error {
+	switch name {
+	case "dimmed":
+		screen.LEDs, screen.KeepLEDs = rwp.HWCMode_DIMMED, false
+	case "off":
+		screen.LEDs, screen.KeepLEDs = rwp.HWCMode_OFF, false
+	case "keep":
+		screen.KeepLEDs = true
+	default:
+		return fmt.Errorf("unknown LED mode %q, expected dimmed, off or keep", name)
+	}
+	return nil
+}
+
+// FromPanel picks up the topology from messages from the panel. If it arrives while offline, the messages taking over the panel are returned.
+func (screen *OfflineScreen) FromPanel(msgs []*rwp.OutboundMessage) []*rwp.InboundMessage {
+	if screen == nil {
+		return nil
+	}
+	screen.mu.Lock()
+	defer screen.mu.Unlock()
+
+	for _, msg := range msgs {
+		if msg.PanelTopology == nil || msg.PanelTopology.Json == "" {
+			continue
+		}
+		topo := &topology.Topology{}
+		if err := json.Unmarshal([]byte(msg.PanelTopology.Json), topo); err != nil {
+			continue
+		}
+		screen.topology = topo
+		if screen.offline {
+			return screen.takeover()
+		}
+	}
+	return nil
+}
+
+// SystemLost returns the messages taking over the panel. Without a topology, that's a request for it.
+func (screen *OfflineScreen) SystemLost() []*rwp.InboundMessage {
+	if screen == nil {
+		return nil
+	}
+	screen.mu.Lock()
+	defer screen.mu.Unlock()
+
+	screen.offline = true
+	return screen.takeover()
+}
+
+// PanelConnected returns the messages taking over a (re)connected panel, if the system is offline
+func (screen *OfflineScreen) PanelConnected() []*rwp.InboundMessage {
+	if screen == nil {
+		return nil
+	}
+	screen.mu.Lock()
+	defer screen.mu.Unlock()
+
+	if !screen.offline {
+		return nil
+	}
+	return screen.takeover()
+}
+
+// SystemBack returns the messages restoring the panel to the last states sent by the system
+func (screen *OfflineScreen) SystemBack() []*rwp.InboundMessage {
+	if screen == nil {
+		return nil
+	}
+	screen.mu.Lock()
+	defer screen.mu.Unlock()
+
+	if !screen.offline {
+		return nil
+	}
+	screen.offline = false
+	return append([]*rwp.InboundMessage{{Command: &rwp.Command{ClearAll: true}}}, screen.cache.Messages()...)
+}
+
+// takeover must be called with screen.mu locked
+func (screen *OfflineScreen) takeover() []*rwp.InboundMessage {
+	if screen.topology == nil {
+		return []*rwp.InboundMessage{{Command: &rwp.Command{SendPanelTopology: true}}}
+	}
+
+	leds := &rwp.HWCState{}
+	displays := &rwp.HWCState{
+		HWCText: &rwp.HWCText{
+			Formatting:  rwp.HWCText_FMT_TWOLINES,
+			TextStyling: &rwp.HWCText_TextStyle{}, // The ASCII converter needs it with FMT_TWOLINES
+			Title:       screen.Title,
+			Textline1:   screen.Lines[0],
+			Textline2:   screen.Lines[1],
+		},
+	}
+	for _, hwc := range screen.topology.GetHWCs() {
+		typeDef, err := screen.topology.GetHWCtype(hwc)
+		if err != nil || typeDef == nil {
+			continue
+		}
+		if typeDef.HasDisplay() {
+			displays.HWCIDs = append(displays.HWCIDs, hwc)
+		}
+		if typeDef.HasLED() && !screen.KeepLEDs {
+			leds.HWCIDs = append(leds.HWCIDs, hwc)
+		}
+	}
+
+	msgs := []*rwp.InboundMessage{}
+	if len(leds.HWCIDs) > 0 {
+		leds.HWCMode = &rwp.HWCMode{State: screen.LEDs}
+		msgs = append(msgs, &rwp.InboundMessage{States: []*rwp.HWCState{leds}})
+	}
+	if len(displays.HWCIDs) > 0 {
+		msgs = append(msgs, &rwp.InboundMessage{States: []*rwp.HWCState{displays}})
+	}
+	return msgs
+}
@@ -0,0 +1,49 @@
+package rwptransport
+
+import (
+	"testing"
+
+	helpers "github.com/SKAARHOJ/rawpanel-lib"
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+)
+
+const testTopology = `{"HWc":[{"id":1,"x":100,"y":100,"txt":"Button","type":1},{"id":2,"x":300,"y":100,"txt":"Display","type":2}],` +
+	`"typeIndex":{"1":{"w":120,"h":120,"out":"rgb","in":"b"},"2":{"w":200,"h":100,"disp":{"w":128,"h":32}}}}`
+
+func TestOfflineScreen(t *testing.T) {
+	cache := NewStateCache()
+	screen := NewOfflineScreen(cache, "System offline", "Reconnecting to", "10.0.0.1:9923")
+
+	// Without a topology, it's requested first:
+	msgs := screen.SystemLost()
+	if len(msgs) != 1 || msgs[0].Command == nil || !msgs[0].Command.SendPanelTopology {
+		t.Fatalf("expected a topology request, got %v", msgs)
+	}
+	msgs = screen.FromPanel([]*rwp.OutboundMessage{{PanelTopology: &rwp.PanelTopology{Json: testTopology}}})
+	if len(msgs) != 2 {
+		t.Fatalf("expected LED and display states, got %v", msgs)
+	}
+	leds, displays := msgs[0].States[0], msgs[1].States[0]
+	if len(leds.HWCIDs) != 1 || leds.HWCIDs[0] != 1 || leds.HWCMode.State != rwp.HWCMode_DIMMED {
+		t.Errorf("unexpected LED state %v", leds)
+	}
+	if len(displays.HWCIDs) != 1 || displays.HWCIDs[0] != 2 || displays.HWCText.Textline2 != "10.0.0.1:9923" {
+		t.Errorf("unexpected display state %v", displays)
+	}
+	if lines := helpers.InboundMessagesToRawPanelASCIIstrings(msgs); len(lines) != 2 {
+		t.Errorf("expected the takeover in two ASCII lines, got %q", lines)
+	}
+
+	// The panel is restored to what the system sent before:
+	cache.Update([]*rwp.InboundMessage{{States: []*rwp.HWCState{{HWCIDs: []uint32{1}, HWCMode: &rwp.HWCMode{State: rwp.HWCMode_ON}}}}})
+	msgs = screen.SystemBack()
+	if len(msgs) != 2 || !msgs[0].Command.ClearAll || msgs[1].States[0].HWCMode.State != rwp.HWCMode_ON {
+		t.Fatalf("unexpected restore %v", msgs)
+	}
+	if msgs := screen.PanelConnected(); msgs != nil {
+		t.Fatalf("expected nothing while online, got %v", msgs)
+	}
+	if msgs := screen.SystemBack(); msgs != nil {
+		t.Fatalf("expected nothing when already online, got %v", msgs)
+	}
+}
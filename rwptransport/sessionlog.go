@@ -0,0 +1,161 @@
+package rwptransport
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+/*
+Session logs hold the messages exchanged with panels, one JSON object per line:
+
+	{"time":"2025-01-31T14:03:12.123456Z","dir":"in","peer":"192.168.10.99:9923","msg":{"Command":{"ActivatePanel":true}}}
+	{"time":"2025-01-31T14:03:12.234567Z","dir":"out","peer":"192.168.10.99:9923","msg":{"Events":[{"HWCID":12,"Binary":{"Pressed":true}}]}}
+
+"in" is an rwp.InboundMessage (system to panel) and "out" an rwp.OutboundMessage (panel to system), "msg" is its protobuf JSON encoding.
+*/
+
+// Directions in a session log
+const (
+	DirInbound  = "in"
+	DirOutbound = "out"
+)
+
+// logLine is the JSON form of a LogEntry
+type logLine struct {
+	Time time.Time       `json:"time"`
+	Dir  string          `json:"dir"`
+	Peer string          `json:"peer,omitempty"`
+	Msg  json.RawMessage `json:"msg"`
+}
+
+// LogEntry is a message read from a session log. Exactly one of Inbound and Outbound is set.
+type LogEntry struct {
+	Time     time.Time
+	Peer     string // Address of the panel (or system) the message was exchanged with
+	Inbound  *rwp.InboundMessage
+	Outbound *rwp.OutboundMessage
+}
+
+// Recorder writes session logs. A nil *Recorder discards everything, so callers don't need to check if logging is enabled.
+type Recorder struct {
+	w      io.Writer
+	closer io.Closer
+	mu     sync.Mutex
+}
+
+// NewRecorder returns a Recorder writing to w
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{w: w}
+}
+
+// CreateRecorder returns a Recorder writing to a new file, or appending to an existing one
+func CreateRecorder(filename string) (*Recorder, error) {
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &Recorder{w: file, closer: file}, nil
+}
+
+// Inbound logs messages sent to the panel at peer
+func (r *Recorder) Inbound(peer string, msgs []*rwp.InboundMessage) {
+	if r == nil {
+		return
+	}
+	for _, msg := range msgs {
+		r.write(DirInbound, peer, msg)
+	}
+}
+
+// Outbound logs messages received from the panel at peer
+func (r *Recorder) Outbound(peer string, msgs []*rwp.OutboundMessage) {
+	if r == nil {
+		return
+	}
+	for _, msg := range msgs {
+		r.write(DirOutbound, peer, msg)
+	}
+}
+
+// Close closes the underlying file, if the Recorder was created with CreateRecorder
+func (r *Recorder) Close() error {
+	if r == nil || r.closer == nil {
+		return nil
+	}
+	return r.closer.Close()
+}
+
+func (r *Recorder) write(dir string, peer string, msg proto.Message) {
+	msgJSON, err := protojson.Marshal(msg)
+	if err != nil {
+		return
+	}
+	line, err := json.Marshal(logLine{Time: time.Now().UTC(), Dir: dir, Peer: peer, Msg: msgJSON})
+	if err != nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.w.Write(append(line, '\n'))
+}
+
+// LogReader reads a session log entry by entry
+type LogReader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+// NewLogReader returns a LogReader reading from r
+func NewLogReader(r io.Reader) *LogReader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 4*MaxPayloadLength) // Graphics get big in JSON
+	return &LogReader{scanner: scanner}
+}
+
+// Next returns the next entry, or io.EOF at the end of the log. Blank lines are skipped.
+func (lr *LogReader) Next() (*LogEntry, error) {
+	for lr.scanner.Scan() {
+		lr.line++
+		if len(lr.scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var line logLine
+		if err := json.Unmarshal(lr.scanner.Bytes(), &line); err != nil {
+			return nil, fmt.Errorf("line %d: %w", lr.line, err)
+		}
+
+		entry := &LogEntry{Time: line.Time, Peer: line.Peer}
+		var msg proto.Message
+		switch line.Dir {
+		case DirInbound:
+			entry.Inbound = &rwp.InboundMessage{}
+			msg = entry.Inbound
+		case DirOutbound:
+			entry.Outbound = &rwp.OutboundMessage{}
+			msg = entry.Outbound
+		default:
+			return nil, fmt.Errorf("line %d: unknown direction %q", lr.line, line.Dir)
+		}
+		if err := protojson.Unmarshal(line.Msg, msg); err != nil {
+			return nil, fmt.Errorf("line %d: %w", lr.line, err)
+		}
+		return entry, nil
+	}
+
+	if err := lr.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
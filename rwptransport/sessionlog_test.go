@@ -0,0 +1,54 @@
+package rwptransport
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+
+	"google.golang.org/protobuf/proto"
+)
+
+func TestSessionLogRoundtrip(t *testing.T) {
+	var buf bytes.Buffer
+	recorder := NewRecorder(&buf)
+
+	inbound := &rwp.InboundMessage{States: []*rwp.HWCState{{HWCIDs: []uint32{1, 2}, HWCText: &rwp.HWCText{Textline1: "Hello\nWorld"}}}}
+	outbound := &rwp.OutboundMessage{Events: []*rwp.HWCEvent{{HWCID: 7, Pulsed: &rwp.PulsedEvent{Value: -2}}}}
+	recorder.Inbound("panel:9923", []*rwp.InboundMessage{inbound})
+	recorder.Outbound("panel:9923", []*rwp.OutboundMessage{outbound})
+
+	if lines := strings.Split(strings.TrimSpace(buf.String()), "\n"); len(lines) != 2 {
+		t.Fatalf("expected one line per message, got %q", buf.String())
+	}
+
+	reader := NewLogReader(&buf)
+	entry, err := reader.Next()
+	if err != nil || entry.Peer != "panel:9923" || entry.Outbound != nil || !proto.Equal(entry.Inbound, inbound) {
+		t.Fatalf("unexpected first entry %+v, %v", entry, err)
+	}
+	first := entry.Time
+	entry, err = reader.Next()
+	if err != nil || entry.Inbound != nil || !proto.Equal(entry.Outbound, outbound) {
+		t.Fatalf("unexpected second entry %+v, %v", entry, err)
+	}
+	if entry.Time.Before(first) {
+		t.Fatalf("timestamps out of order: %v before %v", entry.Time, first)
+	}
+	if _, err := reader.Next(); err != io.EOF {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+}
+
+func TestSessionLogErrors(t *testing.T) {
+	var nilRecorder *Recorder
+	nilRecorder.Inbound("x", []*rwp.InboundMessage{{}}) // Must not panic
+	nilRecorder.Close()
+
+	reader := NewLogReader(strings.NewReader("\n{\"time\":\"2025-01-01T00:00:00Z\",\"dir\":\"sideways\",\"msg\":{}}\n"))
+	if _, err := reader.Next(); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Fatalf("expected error on line 2, got %v", err)
+	}
+}
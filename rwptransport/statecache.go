@@ -0,0 +1,150 @@
+package rwptransport
+
+import (
+	"sort"
+	"sync"
+
+	helpers "github.com/SKAARHOJ/rawpanel-lib"
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// StateCache keeps the last state set for each HWC by the messages sent to a panel, so it can be sent again, eg. after the panel has rebooted.
+// Each part of a state (mode, color, text...) replaces the part set before, like on the panel. Text and graphics replace each other.
+// Activation and the panel wide settings which a panel forgets on reboot (brightness, dimmed gain, heartbeat timer) are kept too.
+type StateCache struct {
+	states   map[uint32]*rwp.HWCState
+	settings *rwp.Command
+	mu       sync.Mutex
+}
+
+// NewStateCache returns an empty StateCache
+func NewStateCache() *StateCache {
+	return &StateCache{states: make(map[uint32]*rwp.HWCState), settings: &rwp.Command{}}
+}
+
+// Update applies the states and clear commands in messages sent to the panel
+func (cache *StateCache) Update(msgs []*rwp.InboundMessage) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	for _, msg := range msgs {
+		if cmd := msg.Command; cmd != nil {
+			if cmd.ActivatePanel {
+				cache.settings.ActivatePanel = true
+			}
+			if cmd.PanelBrightness != nil {
+				cache.settings.PanelBrightness = proto.Clone(cmd.PanelBrightness).(*rwp.Brightness)
+			}
+			if cmd.SetDimmedGain != nil {
+				cache.settings.SetDimmedGain = proto.Clone(cmd.SetDimmedGain).(*rwp.DimmedGain)
+			}
+			if cmd.SetHeartBeatTimer != nil {
+				cache.settings.SetHeartBeatTimer = proto.Clone(cmd.SetHeartBeatTimer).(*rwp.HeartBeatTimer)
+			}
+		}
+
+		if cmd := msg.Command; cmd != nil && (cmd.ClearAll || cmd.ClearLEDs || cmd.ClearDisplays) {
+			for hwc, state := range cache.states {
+				if cmd.ClearAll || cmd.ClearLEDs {
+					state.HWCMode = nil
+					state.HWCColor = nil
+					state.HWCExtended = nil
+				}
+				if cmd.ClearAll || cmd.ClearDisplays {
+					state.HWCText = nil
+					state.HWCGfx = nil
+				}
+				if state.HWCMode == nil && state.HWCColor == nil && state.HWCExtended == nil && state.HWCText == nil && state.HWCGfx == nil {
+					delete(cache.states, hwc)
+				}
+			}
+		}
+
+		for _, state := range msg.States {
+			for _, hwc := range state.HWCIDs {
+				cache.apply(hwc, state)
+			}
+		}
+	}
+}
+
+func (cache *StateCache) apply(hwc uint32, state *rwp.HWCState) {
+	current, exists := cache.states[hwc]
+	if !exists {
+		current = &rwp.HWCState{HWCIDs: []uint32{hwc}}
+		cache.states[hwc] = current
+	}
+	if state.HWCMode != nil {
+		current.HWCMode = proto.Clone(state.HWCMode).(*rwp.HWCMode)
+	}
+	if state.HWCColor != nil {
+		current.HWCColor = proto.Clone(state.HWCColor).(*rwp.HWCColor)
+	}
+	if state.HWCExtended != nil {
+		current.HWCExtended = proto.Clone(state.HWCExtended).(*rwp.HWCExtended)
+	}
+	if state.HWCText != nil {
+		current.HWCText = proto.Clone(state.HWCText).(*rwp.HWCText)
+		current.HWCGfx = nil
+	}
+	if state.HWCGfx != nil {
+		current.HWCGfx = proto.Clone(state.HWCGfx).(*rwp.HWCGfx)
+		current.HWCText = nil
+	}
+}
+
+// States returns a copy of the cached state of each HWC, sorted by HWC
+func (cache *StateCache) States() []*rwp.HWCState {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	hwcs := make([]uint32, 0, len(cache.states))
+	for hwc := range cache.states {
+		hwcs = append(hwcs, hwc)
+	}
+	sort.Slice(hwcs, func(i, j int) bool { return hwcs[i] < hwcs[j] })
+
+	states := make([]*rwp.HWCState, 0, len(hwcs))
+	for _, hwc := range hwcs {
+		states = append(states, proto.Clone(cache.states[hwc]).(*rwp.HWCState))
+	}
+	return states
+}
+
+// Messages returns the cached states as messages to send to a panel, one state per message
+func (cache *StateCache) Messages() []*rwp.InboundMessage {
+	msgs := []*rwp.InboundMessage{}
+	for _, state := range cache.States() {
+		msgs = append(msgs, &rwp.InboundMessage{States: []*rwp.HWCState{state}})
+	}
+	return msgs
+}
+
+// Replay returns the messages bringing a freshly connected panel back to the cached state: The settings (and activation) first, then the states.
+// Nothing is returned before anything was cached, so a panel isn't activated before the system does it.
+func (cache *StateCache) Replay() []*rwp.InboundMessage {
+	cache.mu.Lock()
+	settings := proto.Clone(cache.settings).(*rwp.Command)
+	cache.mu.Unlock()
+
+	msgs := []*rwp.InboundMessage{}
+	if proto.Size(settings) > 0 {
+		msgs = append(msgs, &rwp.InboundMessage{Command: settings})
+	}
+	return append(msgs, cache.Messages()...)
+}
+
+// Dump returns the cached settings and states in ASCII protocol syntax, for debugging
+func (cache *StateCache) Dump() []string {
+	cache.mu.Lock()
+	settings := proto.Clone(cache.settings).(*rwp.Command)
+	cache.mu.Unlock()
+
+	lines := helpers.InboundMessagesToRawPanelASCIIstrings([]*rwp.InboundMessage{{Command: settings}})
+	for _, state := range cache.States() {
+		lines = append(lines, helpers.InboundMessagesToRawPanelASCIIstrings([]*rwp.InboundMessage{{States: []*rwp.HWCState{state}}})...)
+	}
+	return lines
+}
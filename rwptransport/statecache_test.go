@@ -0,0 +1,75 @@
+package rwptransport
+
+import (
+	"testing"
+
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+
+	"google.golang.org/protobuf/proto"
+)
+
+func TestStateCache(t *testing.T) {
+	cache := NewStateCache()
+	cache.Update([]*rwp.InboundMessage{
+		{States: []*rwp.HWCState{{HWCIDs: []uint32{2, 1}, HWCMode: &rwp.HWCMode{State: rwp.HWCMode_ON}}}},
+		{States: []*rwp.HWCState{{HWCIDs: []uint32{1}, HWCText: &rwp.HWCText{Title: "One"}}}},
+		{States: []*rwp.HWCState{{HWCIDs: []uint32{1}, HWCMode: &rwp.HWCMode{State: rwp.HWCMode_DIMMED}}}},
+		{States: []*rwp.HWCState{{HWCIDs: []uint32{2}, HWCGfx: &rwp.HWCGfx{W: 1, H: 1}}}},
+		{States: []*rwp.HWCState{{HWCIDs: []uint32{2}, HWCText: &rwp.HWCText{Title: "Two"}}}},
+	})
+
+	expected := []*rwp.HWCState{
+		{HWCIDs: []uint32{1}, HWCMode: &rwp.HWCMode{State: rwp.HWCMode_DIMMED}, HWCText: &rwp.HWCText{Title: "One"}},
+		{HWCIDs: []uint32{2}, HWCMode: &rwp.HWCMode{State: rwp.HWCMode_ON}, HWCText: &rwp.HWCText{Title: "Two"}},
+	}
+	states := cache.States()
+	if len(states) != len(expected) {
+		t.Fatalf("expected %d states, got %v", len(expected), states)
+	}
+	for i := range expected {
+		if !proto.Equal(states[i], expected[i]) {
+			t.Errorf("state %d: expected %v, got %v", i, expected[i], states[i])
+		}
+	}
+
+	// Clearing LEDs leaves the texts, clearing displays then leaves nothing:
+	cache.Update([]*rwp.InboundMessage{{Command: &rwp.Command{ClearLEDs: true}}})
+	if states := cache.States(); len(states) != 2 || states[0].HWCMode != nil || states[0].HWCText == nil {
+		t.Fatalf("unexpected states after ClearLEDs: %v", states)
+	}
+	cache.Update([]*rwp.InboundMessage{{Command: &rwp.Command{ClearDisplays: true}}})
+	if msgs := cache.Messages(); len(msgs) != 0 {
+		t.Fatalf("expected an empty cache, got %v", msgs)
+	}
+}
+
+func TestStateCacheReplay(t *testing.T) {
+	cache := NewStateCache()
+	if msgs := cache.Replay(); len(msgs) != 0 {
+		t.Fatalf("expected nothing to replay, got %v", msgs)
+	}
+
+	cache.Update([]*rwp.InboundMessage{
+		{Command: &rwp.Command{ActivatePanel: true, SendPanelInfo: true}},
+		{Command: &rwp.Command{PanelBrightness: &rwp.Brightness{LEDs: 4, OLEDs: 5}}},
+		{States: []*rwp.HWCState{{HWCIDs: []uint32{3}, HWCColor: &rwp.HWCColor{ColorIndex: &rwp.ColorIndex{Index: rwp.ColorIndex_RED}}}}},
+	})
+
+	msgs := cache.Replay()
+	expected := []*rwp.InboundMessage{
+		{Command: &rwp.Command{ActivatePanel: true, PanelBrightness: &rwp.Brightness{LEDs: 4, OLEDs: 5}}},
+		{States: []*rwp.HWCState{{HWCIDs: []uint32{3}, HWCColor: &rwp.HWCColor{ColorIndex: &rwp.ColorIndex{Index: rwp.ColorIndex_RED}}}}},
+	}
+	if len(msgs) != len(expected) {
+		t.Fatalf("expected %d messages, got %v", len(expected), msgs)
+	}
+	for i := range expected {
+		if !proto.Equal(msgs[i], expected[i]) {
+			t.Errorf("message %d: expected %v, got %v", i, expected[i], msgs[i])
+		}
+	}
+
+	if lines := cache.Dump(); len(lines) < 2 {
+		t.Errorf("expected settings and a state in the dump, got %q", lines)
+	}
+}
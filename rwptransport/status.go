@@ -0,0 +1,102 @@
+package rwptransport
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrTimeout is the reason for a disconnect when nothing was received for Config.Timeout
+var ErrTimeout = errors.New("connection timed out")
+
+// Status tracks the liveness of the connections run with a Config. It's safe to read from any goroutine with Snapshot.
+type Status struct {
+	snapshot StatusSnapshot
+	pingSent time.Time // Zero when no ping is waiting for an ack
+	mu       sync.Mutex
+}
+
+// StatusSnapshot is the state of a connection at one point in time
+type StatusSnapshot struct {
+	Connected    bool
+	Addr         string
+	Since        time.Time     // When the connection was established or lost
+	LastReceived time.Time     // Last time anything was received
+	LastAck      time.Time     // Last time an ack was received
+	RTT          time.Duration // Round trip time from the last ping sent to its ack
+}
+
+// Snapshot returns the current state. A nil *Status returns the zero value.
+func (s *Status) Snapshot() StatusSnapshot {
+	if s == nil {
+		return StatusSnapshot{}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.snapshot
+}
+
+// String describes the state in one line, eg. "192.168.10.99:9923 up 1m0s, RTT 2ms, last received 120ms ago"
+func (snapshot StatusSnapshot) String() string {
+	if snapshot.Since.IsZero() {
+		return "never connected"
+	}
+	if !snapshot.Connected {
+		return fmt.Sprintf("%s down %v", snapshot.Addr, time.Since(snapshot.Since).Round(time.Second))
+	}
+
+	parts := []string{fmt.Sprintf("%s up %v", snapshot.Addr, time.Since(snapshot.Since).Round(time.Second))}
+	if !snapshot.LastAck.IsZero() {
+		parts = append(parts, "RTT "+snapshot.RTT.Round(time.Microsecond).String())
+	}
+	if !snapshot.LastReceived.IsZero() {
+		parts = append(parts, "last received "+time.Since(snapshot.LastReceived).Round(time.Millisecond).String()+" ago")
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (s *Status) connected(addr string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.snapshot = StatusSnapshot{Connected: true, Addr: addr, Since: now, LastReceived: now}
+	s.pingSent = time.Time{}
+}
+
+func (s *Status) disconnected() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.snapshot.Connected = false
+	s.snapshot.Since = time.Now()
+}
+
+// pinged is called when a ping has been sent. While a ping is unanswered, the RTT is measured from the first one.
+func (s *Status) pinged() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pingSent.IsZero() {
+		s.pingSent = time.Now()
+	}
+}
+
+func (s *Status) received(ack bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.snapshot.LastReceived = now
+	if ack {
+		s.snapshot.LastAck = now
+		if !s.pingSent.IsZero() {
+			s.snapshot.RTT = now.Sub(s.pingSent)
+			s.pingSent = time.Time{}
+		}
+	}
+}
+
+func (s *Status) sinceReceived() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return time.Since(s.snapshot.LastReceived)
+}
@@ -0,0 +1,25 @@
+package rwptransport
+
+import (
+	rwp "github.com/SKAARHOJ/rawpanel-lib/ibeam_rawpanel"
+)
+
+// WSMessageToServer is the envelope of messages from a panel to a Raw Panel WebSocket server (see RwpWebsocketServer)
+type WSMessageToServer struct {
+	MsgsFromPanel []*rwp.OutboundMessage `json:"msgsFromPanel,omitempty"` // raw json passed back to client
+	Auth          *AuthCredentials       `json:"auth,omitempty"`          // optional auth field
+}
+
+// AuthCredentials holds username/password login info
+type AuthCredentials struct {
+	ClientID     string `json:"client_id"`     // "Username" for authentication
+	ClientSecret string `json:"client_secret"` // "Password" for authentication
+}
+
+// WSMessageFromServer is the envelope of messages from a Raw Panel WebSocket server to a panel
+type WSMessageFromServer struct {
+	MsgsToPanel []*rwp.InboundMessage `json:"msgsToPanel,omitempty"` // raw json we receive from client
+	Error       string                `json:"error,omitempty"`       // optional error message
+	Message     string                `json:"message,omitempty"`     // optional success message
+	Status      string                `json:"status,omitempty"`      // connection status: "ready" when messages can flow, "auth_required" if credentials are missing
+}